@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -37,7 +38,41 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	done <- true
 }
 
+// healthcheck asks the API listening on PORT whether it is alive and exits
+// non-zero if it is not. The image has no shell or curl, so the compose
+// healthcheck runs "api healthcheck" instead. It checks /livez rather than
+// /readyz: Postgres and Redis may run outside the compose project, and their
+// outages should not get the container marked unhealthy or restarted.
+// Orchestrators that route traffic can probe /readyz themselves.
+func healthcheck() {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%s/livez", os.Getenv("PORT")))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "healthcheck:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "healthcheck:", resp.Status)
+		os.Exit(1)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "healthcheck":
+			healthcheck()
+			return
+		case "token":
+			if err := token(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
 	server := server.NewServer()
 
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/auth"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+)
+
+const tokenUsage = `usage:
+  api token create -name <name> [-scopes read,stream] [-max-active n]
+  api token revoke <token-id>`
+
+// token manages API tokens. It runs inside the API container, which has the
+// database settings, so that the first token can be created before any
+// request could be authenticated.
+func token(args []string) error {
+	if len(args) == 0 {
+		return errors.New(tokenUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		return createToken(args[1:])
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(tokenUsage)
+		}
+		return revokeToken(args[1])
+	}
+	return errors.New(tokenUsage)
+}
+
+func createToken(args []string) error {
+	flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+	name := flags.String("name", "", "what the token is for, e.g. \"living room tv\"")
+	scopes := flags.String("scopes", "read,stream", "comma separated scopes: read, stream, add, admin")
+	maxActive := flags.Int("max-active", 0, "most torrents added with the token that may be active at once, 0 for no limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("token create: -name is required")
+	}
+	if *maxActive < 0 {
+		return errors.New("token create: -max-active must not be negative")
+	}
+
+	parsed, err := auth.ParseScopes(*scopes)
+	if err != nil {
+		return err
+	}
+
+	plain, hash := auth.NewToken()
+	record := postgresdb.APIToken{
+		Id:                internal.RandomId(),
+		Name:              *name,
+		TokenHash:         hash,
+		Scopes:            parsed,
+		MaxActiveTorrents: *maxActive,
+		CreatedAt:         time.Now().UTC(),
+	}
+
+	db := postgresdb.New()
+	defer db.Close()
+	if err := db.CreateToken(record); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+
+	fmt.Printf("id:     %s\n", record.Id)
+	fmt.Printf("scopes: %s\n", strings.Join(parsed, ","))
+	fmt.Printf("token:  %s\n", plain)
+	fmt.Println("\nThe token is shown only once, send it as \"Authorization: Bearer <token>\".")
+	return nil
+}
+
+func revokeToken(id string) error {
+	db := postgresdb.New()
+	defer db.Close()
+
+	err := db.RevokeToken(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no token with id %s", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	fmt.Printf("revoked token %s\n", id)
+	return nil
+}
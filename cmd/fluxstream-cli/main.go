@@ -58,12 +58,70 @@ var stopCmd = &cobra.Command{
 	},
 }
 
+var (
+	sharePieceLength int64
+	shareTrackers    []string
+	shareOutput      string
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share <video-id>",
+	Short: "Shares a saved video as a torrent and prints its magnet link",
+	Long:  `Builds a torrent from a video saved in the library, seeds the file in place and writes its .torrent file`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return commands.Share(args[0], sharePieceLength, shareTrackers, shareOutput)
+	},
+}
+
+var (
+	tokenName      string
+	tokenScopes    string
+	tokenMaxActive int
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manages the API tokens clients authenticate with",
+	Long:  `Creates and revokes API tokens. The /admin routes always take an admin token, the others only when the server runs with AUTH_REQUIRED=true; the CLI sends the one in FLUXSTREAM_TOKEN.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Creates an API token and prints it once",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return commands.CreateToken(tokenName, tokenScopes, tokenMaxActive)
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <token-id>",
+	Short: "Revokes an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return commands.RevokeToken(args[0])
+	},
+}
+
 func init() {
+	shareCmd.Flags().Int64Var(&sharePieceLength, "piece-size", 0, "piece size in bytes, a power of two (default: picked from the file size)")
+	shareCmd.Flags().StringSliceVar(&shareTrackers, "tracker", nil, "extra tracker announce URL, can be repeated")
+	shareCmd.Flags().StringVarP(&shareOutput, "output", "o", "", "where to write the .torrent file (default: <video name>.torrent)")
+
+	tokenCreateCmd.Flags().StringVar(&tokenName, "name", "", "what the token is for, e.g. \"living room tv\"")
+	tokenCreateCmd.Flags().StringVar(&tokenScopes, "scopes", "read,stream", "comma separated scopes: read, stream, add, admin")
+	tokenCreateCmd.Flags().IntVar(&tokenMaxActive, "max-active", 0, "most torrents added with the token that may be active at once, 0 for no limit")
+	tokenCreateCmd.MarkFlagRequired("name")
+	tokenCmd.AddCommand(tokenCreateCmd, tokenRevokeCmd)
+
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(setupCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(whereCmd)
 	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(tokenCmd)
 }
 
 func main() {
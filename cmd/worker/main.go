@@ -23,6 +23,7 @@ func main() {
 		go tw.DownloadWorker(i)
 	}
 
+	go tw.WatchBandwidth()
 	go tw.HandleErrors()
 	select {}
 }
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	got, err := ParseScopes(" Read, stream,read ")
+	if err != nil || !slices.Equal(got, []string{"read", "stream"}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	for _, raw := range []string{"", "read,write", " , "} {
+		if _, err := ParseScopes(raw); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("%q: expected ErrInvalidScope, got %v", raw, err)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	if !Allows([]string{"read"}, ScopeRead) || Allows([]string{"read"}, ScopeStream) {
+		t.Error("read grants read only")
+	}
+	if !Allows([]string{"admin"}, ScopeAdd) {
+		t.Error("admin grants every scope")
+	}
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	token, hash := NewToken()
+	if Hash(token) != hash || len(hash) != 64 {
+		t.Fatalf("hash %q does not match token", hash)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "bearer "+token)
+	if got := FromRequest(r); got != token {
+		t.Errorf("got %q", got)
+	}
+
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	if got := FromRequest(r); got != "" {
+		t.Errorf("expected no bearer token, got %q", got)
+	}
+}
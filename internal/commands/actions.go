@@ -2,13 +2,16 @@ package commands
 
 import (
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/scythe504/webtorrent/internal"
 )
@@ -176,21 +179,64 @@ func Status() error {
 	printSuccess("Active FluxStream containers:")
 	fmt.Println(output)
 
-	//  Check backend health endpoint
-	resp, err := http.Get("http://localhost:8080/health")
+	checkBackend("http://localhost:8080")
+	return nil
+}
+
+// checkBackend prints whether the API at baseURL is alive and whether its
+// dependencies are ready, with the reason for each one that is not.
+func checkBackend(baseURL string) {
+	client := http.Client{Timeout: 15 * time.Second}
+
+	resp, err := client.Get(baseURL + "/livez")
 	if err != nil {
-		printError("Backend API not responding at http://localhost:8080")
-		return nil
+		printError("Backend API not responding at " + baseURL)
+		return
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(baseURL + "/readyz")
+	if err != nil {
+		printError(fmt.Sprintf("Backend is running but its health check failed: %v", err))
+		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
+	var report struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		printError(fmt.Sprintf("Backend responded with status: %s", resp.Status))
+		return
+	}
+
+	if report.Status == "up" {
 		printSuccess("Backend is running and healthy.")
 	} else {
-		printError(fmt.Sprintf("Backend responded with status: %s", resp.Status))
+		printError("Backend is running but not ready:")
 	}
 
-	return nil
+	names := make([]string, 0, len(report.Checks))
+	for name := range report.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := report.Checks[name]
+		line := fmt.Sprintf("  %-9s %s", name, c.Status)
+		if c.Message != "" {
+			line += " (" + c.Message + ")"
+		}
+		if c.Status == "up" {
+			fmt.Println(line)
+		} else {
+			fmt.Println(colorize(colorRed, line))
+		}
+	}
 }
 
 // PrintAccessURLs shows both local and LAN URLs where the app is accessible.
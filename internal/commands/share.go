@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// apiURL is where the FluxStream backend listens once started.
+const apiURL = "http://localhost:8080"
+
+// shareClient allows for hashing large videos before the backend answers.
+var shareClient = &http.Client{Timeout: 30 * time.Minute}
+
+// Share asks the backend to build a torrent from a saved video and seed it,
+// then prints the magnet link and writes the .torrent file to output, or to
+// the video's name in the current directory when output is empty.
+func Share(videoId string, pieceLength int64, trackers []string, output string) error {
+	fmt.Println(colorize(colorBlue, "Sharing video "+videoId+"...\n"))
+	printInfo("Hashing the video, this can take a while for large files.")
+
+	body, err := json.Marshal(map[string]any{
+		"piece_length": pieceLength,
+		"trackers":     trackers,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/library/"+url.PathEscape(videoId)+"/share", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := shareClient.Do(authorize(req))
+	if err != nil {
+		printError("Backend API not responding at " + apiURL)
+		printInfo("You can start FluxStream using: fluxstream start")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		printError(fmt.Sprintf("Failed to share video: %s", bytes.TrimSpace(message)))
+		return fmt.Errorf("backend responded with status: %s", resp.Status)
+	}
+
+	var share struct {
+		Name       string `json:"name"`
+		MagnetLink string `json:"magnet_link"`
+		TorrentURL string `json:"torrent_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&share); err != nil {
+		return fmt.Errorf("invalid response from backend: %v", err)
+	}
+
+	if output == "" {
+		output = share.Name + ".torrent"
+	}
+	if err := downloadTorrent(apiURL+share.TorrentURL, output); err != nil {
+		printError(fmt.Sprintf("Failed to download the .torrent file: %v", err))
+		return err
+	}
+
+	printSuccess("Video is being seeded!")
+	fmt.Printf("\n%s %s\n", colorize(colorBlue, "Magnet:"), share.MagnetLink)
+	fmt.Printf("%s %s\n", colorize(colorBlue, "Torrent file:"), output)
+	fmt.Printf("\n%s\n", colorize(colorYellow, "Keep FluxStream running so friends can download it."))
+	return nil
+}
+
+func downloadTorrent(rawURL, output string) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := shareClient.Do(authorize(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("backend responded with status: %s", resp.Status)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
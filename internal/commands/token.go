@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// tokenEnv holds the API token the CLI sends, needed once the backend runs
+// with AUTH_REQUIRED=true.
+const tokenEnv = "FLUXSTREAM_TOKEN"
+
+// CreateToken creates an API token inside the running backend container and
+// prints it. It works before any token exists, as it never goes through the
+// API.
+func CreateToken(name, scopes string, maxActive int) error {
+	if err := DockerRunning(); err != nil {
+		printError(err.Error())
+		return err
+	}
+
+	args := []string{"exec", "api", "/api", "token", "create", "-name", name, "-scopes", scopes, "-max-active", strconv.Itoa(maxActive)}
+	if err := DockerCompose(args...); err != nil {
+		printError("Failed to create the token, is FluxStream running? Start it with: fluxstream start")
+		return err
+	}
+	return nil
+}
+
+// RevokeToken revokes an API token by id. Requests made with it fail within a
+// minute.
+func RevokeToken(id string) error {
+	if err := DockerRunning(); err != nil {
+		printError(err.Error())
+		return err
+	}
+
+	if err := DockerCompose("exec", "api", "/api", "token", "revoke", id); err != nil {
+		printError("Failed to revoke the token")
+		return err
+	}
+	return nil
+}
+
+// authorize adds the token from FLUXSTREAM_TOKEN, if set, to a request to
+// the backend.
+func authorize(req *http.Request) *http.Request {
+	if token := os.Getenv(tokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
@@ -0,0 +1,240 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/scythe504/webtorrent/internal/mkv"
+)
+
+// ErrUnknownDuration is returned when a stream's headers carry no duration.
+var ErrUnknownDuration = errors.New("duration not found in headers")
+
+// maxBoxes bounds how many top-level MP4 boxes are walked before giving up.
+const maxBoxes = 64
+
+// Duration probes the playback duration of an MP4/MOV or Matroska/WebM
+// stream from its headers. It seeks over payloads instead of reading them, so
+// it only touches a few small regions when r is backed by a torrent.
+func Duration(r io.ReadSeeker) (time.Duration, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, fmt.Errorf("failed to read header: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(head[:4]) == ebmlHeaderID:
+		return matroskaDuration(r)
+	case string(head[4:8]) == "ftyp" || string(head[4:8]) == "moov" || string(head[4:8]) == "mdat":
+		return mp4Duration(r)
+	}
+	return 0, fmt.Errorf("unsupported container for duration probe")
+}
+
+// mp4Duration reads the duration from moov/mvhd.
+func mp4Duration(r io.ReadSeeker) (time.Duration, error) {
+	var offset int64
+	for range maxBoxes {
+		size, boxType, headerLen, err := readBoxHeader(r)
+		if err != nil {
+			return 0, err
+		}
+
+		if boxType == "moov" {
+			return mvhdDuration(r, size-headerLen)
+		}
+		if size == 0 {
+			// Box runs to the end of the file
+			break
+		}
+
+		offset += size
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	return 0, ErrUnknownDuration
+}
+
+func mvhdDuration(r io.ReadSeeker, moovLen int64) (time.Duration, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	for offset < moovLen {
+		size, boxType, headerLen, err := readBoxHeader(r)
+		if err != nil {
+			return 0, err
+		}
+
+		if boxType == "mvhd" {
+			var version [4]byte
+			if _, err := io.ReadFull(r, version[:]); err != nil {
+				return 0, err
+			}
+
+			var timescale, duration uint64
+			if version[0] == 1 {
+				var b [28]byte
+				if _, err := io.ReadFull(r, b[:]); err != nil {
+					return 0, err
+				}
+				timescale = uint64(binary.BigEndian.Uint32(b[16:20]))
+				duration = binary.BigEndian.Uint64(b[20:28])
+			} else {
+				var b [16]byte
+				if _, err := io.ReadFull(r, b[:]); err != nil {
+					return 0, err
+				}
+				timescale = uint64(binary.BigEndian.Uint32(b[8:12]))
+				duration = uint64(binary.BigEndian.Uint32(b[12:16]))
+			}
+
+			if timescale == 0 || duration == 0 {
+				return 0, ErrUnknownDuration
+			}
+			return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+		}
+
+		if size < headerLen {
+			break
+		}
+		offset += size
+		if _, err := r.Seek(start+offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	return 0, ErrUnknownDuration
+}
+
+// readBoxHeader returns the total size, type and header length of the box at
+// the current position. A size of zero means the box extends to EOF.
+func readBoxHeader(r io.Reader) (int64, string, int64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, "", 0, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(b[:4]))
+	boxType := string(b[4:8])
+	if size != 1 {
+		return size, boxType, 8, nil
+	}
+
+	var large [8]byte
+	if _, err := io.ReadFull(r, large[:]); err != nil {
+		return 0, "", 0, err
+	}
+	return int64(binary.BigEndian.Uint64(large[:])), boxType, 16, nil
+}
+
+// Matroska element ids used by the duration probe.
+const (
+	ebmlHeaderID    = 0x1A45DFA3
+	segmentID       = 0x18538067
+	infoID          = 0x1549A966
+	timecodeScaleID = 0x2AD7B1
+	durationID      = 0x4489
+	clusterID       = 0x1F43B675
+)
+
+// matroskaDuration reads Segment/Info/Duration scaled by TimecodeScale.
+func matroskaDuration(r io.ReadSeeker) (time.Duration, error) {
+	// EBML header
+	if _, size, _, err := mkv.ReadElementHeader(r); err != nil {
+		return 0, err
+	} else if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+		return 0, err
+	}
+
+	id, _, _, err := mkv.ReadElementHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	if id != segmentID {
+		return 0, fmt.Errorf("expected Segment, found element 0x%X", id)
+	}
+
+	for {
+		id, size, _, err := mkv.ReadElementHeader(r)
+		if err != nil {
+			return 0, err
+		}
+
+		switch id {
+		case infoID:
+			return infoDuration(r, size)
+		case clusterID:
+			// Info always precedes the first cluster
+			return 0, ErrUnknownDuration
+		}
+
+		if size == mkv.UnknownSize {
+			return 0, ErrUnknownDuration
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func infoDuration(r io.ReadSeeker, infoLen int64) (time.Duration, error) {
+	scale := uint64(1000000)
+	duration := -1.0
+
+	var read int64
+	for read < infoLen {
+		start, _ := r.Seek(0, io.SeekCurrent)
+		id, size, _, err := mkv.ReadElementHeader(r)
+		if err != nil {
+			return 0, err
+		}
+		if size < 0 || size > 8 && (id == timecodeScaleID || id == durationID) {
+			return 0, fmt.Errorf("invalid size for element 0x%X", id)
+		}
+
+		switch id {
+		case timecodeScaleID:
+			b := make([]byte, size)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return 0, err
+			}
+			scale = 0
+			for _, c := range b {
+				scale = scale<<8 | uint64(c)
+			}
+		case durationID:
+			b := make([]byte, size)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return 0, err
+			}
+			switch size {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(b))
+			}
+		default:
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		}
+
+		end, _ := r.Seek(0, io.SeekCurrent)
+		read += end - start
+	}
+
+	if duration <= 0 {
+		return 0, ErrUnknownDuration
+	}
+	return time.Duration(duration * float64(scale)), nil
+}
@@ -0,0 +1,121 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// box encodes an MP4 box.
+func box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, boxType...), body...)
+}
+
+// mvhd encodes a movie header box of the given version.
+func mvhd(version byte, timescale uint32, duration uint64) []byte {
+	b := []byte{version, 0, 0, 0}
+	if version == 1 {
+		b = append(b, make([]byte, 16)...) // Creation and modification times
+		b = binary.BigEndian.AppendUint32(b, timescale)
+		b = binary.BigEndian.AppendUint64(b, duration)
+	} else {
+		b = append(b, make([]byte, 8)...)
+		b = binary.BigEndian.AppendUint32(b, timescale)
+		b = binary.BigEndian.AppendUint32(b, uint32(duration))
+	}
+	return box("mvhd", b)
+}
+
+// el encodes a Matroska element with an 8 byte size.
+func el(id uint32, data ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	body := bytes.Join(data, nil)
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	size[0] = 0x01
+	return append(append(b, size...), body...)
+}
+
+// matroska encodes a Matroska file whose Segment holds a SeekHead and info.
+func matroska(info ...[]byte) []byte {
+	return append(el(ebmlHeaderID, el(0x4282, []byte("matroska"))),
+		el(segmentID, el(0x114D9B74, make([]byte, 12)), el(infoID, info...))...)
+}
+
+func TestDuration(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	float32Duration := binary.BigEndian.AppendUint32(nil, math.Float32bits(90500))
+	float64Duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(7200000.5))
+
+	tests := []struct {
+		name string
+		file []byte
+		want time.Duration
+	}{
+		{"mp4 v0", slices.Concat(ftyp, box("moov", mvhd(0, 1000, 90500))), 90500 * time.Millisecond},
+		{"mp4 v1", slices.Concat(ftyp, box("moov", box("udta"), mvhd(1, 600, 600*3*3600+300))), 3*time.Hour + 500*time.Millisecond},
+		{"moov after mdat", slices.Concat(ftyp, box("mdat", make([]byte, 4096)), box("moov", mvhd(0, 25, 250))), 10 * time.Second},
+		{"mkv float32", matroska(el(durationID, float32Duration)), 90500 * time.Millisecond},
+		{"mkv float64", matroska(el(timecodeScaleID, []byte{0x0F, 0x42, 0x40}), el(durationID, float64Duration)), 7200000500 * time.Microsecond},
+		{"mkv timecode scale", matroska(el(timecodeScaleID, []byte{0x07, 0xA1, 0x20}), el(durationID, float32Duration)), 45250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Duration(bytes.NewReader(tt.file))
+			if err != nil || got != tt.want {
+				t.Errorf("got %s, %v; want %s", got, err, tt.want)
+			}
+
+			// Every truncation fails instead of panicking or guessing
+			for n := range len(tt.file) {
+				if got, err := Duration(bytes.NewReader(tt.file[:n])); err == nil {
+					t.Fatalf("truncated to %d bytes: got %s, want an error", n, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDurationInvalid(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	hugeBox := append(binary.BigEndian.AppendUint32(nil, 1), "free"...)
+	hugeBox = binary.BigEndian.AppendUint64(hugeBox, math.MaxUint64)
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"text", bytes.Repeat([]byte("not a video "), 10)},
+		{"mp4 without moov", slices.Concat(ftyp, box("mdat", make([]byte, 64)))},
+		{"mp4 with zero timescale", slices.Concat(ftyp, box("moov", mvhd(0, 0, 1000)))},
+		{"mp4 with negative box size", slices.Concat(ftyp, hugeBox)},
+		{"mp4 with tiny boxes", slices.Concat(ftyp, bytes.Repeat([]byte("\x00\x00\x00\x02free"), 100))},
+		{"mkv without duration", matroska(el(timecodeScaleID, []byte{0x0F, 0x42, 0x40}))},
+		{"mkv with oversized duration", matroska(el(durationID, make([]byte, 16)))},
+		{"mkv without segment", el(ebmlHeaderID, el(0x4282, []byte("webm")))},
+		{"mkv with cluster before info", slices.Concat(el(ebmlHeaderID), el(segmentID, el(clusterID, make([]byte, 8))))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Duration(bytes.NewReader(tt.file))
+			if err == nil {
+				t.Errorf("got %s, want an error", got)
+			}
+		})
+	}
+
+	if _, err := Duration(bytes.NewReader(slices.Concat(ftyp, box("moov", box("trak"))))); !errors.Is(err, ErrUnknownDuration) {
+		t.Errorf("moov without mvhd: got %v, want ErrUnknownDuration", err)
+	}
+}
@@ -0,0 +1,52 @@
+package media
+
+import (
+	"mime"
+	"strings"
+)
+
+// types maps the extensions of the media and subtitle files we serve to their
+// MIME type. It is compiled in because minimal images such as scratch have no
+// mime.types, which leaves the mime package blind to most video formats.
+var types = map[string]string{
+	".3g2":  "video/3gpp2",
+	".3gp":  "video/3gpp",
+	".asf":  "video/x-ms-asf",
+	".avi":  "video/x-msvideo",
+	".divx": "video/x-msvideo",
+	".f4v":  "video/mp4",
+	".flv":  "video/x-flv",
+	".m2t":  "video/mp2t",
+	".m2ts": "video/mp2t",
+	".m2v":  "video/mpeg",
+	".m4v":  "video/x-m4v",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpeg",
+	".mts":  "video/mp2t",
+	".ogm":  "video/ogg",
+	".ogv":  "video/ogg",
+	".qt":   "video/quicktime",
+	".ts":   "video/mp2t",
+	".vob":  "video/mpeg",
+	".webm": "video/webm",
+	".wmv":  "video/x-ms-wmv",
+
+	".ass": "text/x-ssa",
+	".srt": "application/x-subrip",
+	".ssa": "text/x-ssa",
+	".vtt": "text/vtt",
+}
+
+// TypeByExtension returns the MIME type of a file extension such as ".mkv",
+// ignoring case. Extensions missing from the built-in table are looked up in
+// the system's, and "" is returned when neither knows them.
+func TypeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if t, ok := types[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
@@ -0,0 +1,135 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// SniffLen is how many leading bytes Sniff needs to tell every container it
+// knows apart. MPEG transport streams need the most, three sync bytes one
+// packet apart.
+const SniffLen = 1024
+
+// Container is a media container recognized from its leading bytes.
+type Container struct {
+	Name     string // e.g. "matroska"
+	MimeType string // e.g. "video/x-matroska"
+	Video    bool   // False for containers that only carry audio
+}
+
+// mpegTSPacket and m2tsPacket are the packet sizes of MPEG transport streams
+// as broadcast and as stored on Blu-ray, where each packet is prefixed by a
+// four byte timestamp.
+const (
+	mpegTSPacket = 188
+	m2tsPacket   = 192
+)
+
+var asfHeaderGUID = []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6, 0xD9, 0x00, 0xAA, 0x00, 0x62, 0xCE, 0x6C}
+
+// Sniff recognizes the container of a stream from its first bytes, ideally
+// SniffLen of them: MP4/QuickTime and the rest of the ISO-BMFF family,
+// Matroska/WebM, MPEG transport and program streams, AVI, Ogg, FLV and ASF.
+func Sniff(head []byte) (Container, bool) {
+	switch {
+	case len(head) >= 4 && binary.BigEndian.Uint32(head) == ebmlHeaderID:
+		if ebmlDocType(head) == "webm" {
+			return Container{Name: "webm", MimeType: "video/webm", Video: true}, true
+		}
+		return Container{Name: "matroska", MimeType: "video/x-matroska", Video: true}, true
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return isoContainer(head), true
+	case len(head) >= 8 && (string(head[4:8]) == "moov" || string(head[4:8]) == "mdat" || string(head[4:8]) == "wide" || string(head[4:8]) == "free"):
+		// QuickTime files that predate ftyp
+		return Container{Name: "quicktime", MimeType: "video/quicktime", Video: true}, true
+	case syncedEvery(head, 0, mpegTSPacket):
+		return Container{Name: "mpegts", MimeType: "video/mp2t", Video: true}, true
+	case syncedEvery(head, 4, m2tsPacket):
+		return Container{Name: "m2ts", MimeType: "video/mp2t", Video: true}, true
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xBA}):
+		return Container{Name: "mpegps", MimeType: "video/mpeg", Video: true}, true
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return Container{Name: "avi", MimeType: "video/x-msvideo", Video: true}, true
+	case bytes.HasPrefix(head, []byte("OggS")):
+		// Only the first page is checked, later streams would need a full parse
+		if bytes.Contains(head, []byte("\x80theora")) || bytes.Contains(head, []byte("OVP80")) {
+			return Container{Name: "ogg", MimeType: "video/ogg", Video: true}, true
+		}
+		return Container{Name: "ogg", MimeType: "audio/ogg"}, true
+	case len(head) >= 5 && string(head[:3]) == "FLV" && head[3] == 1:
+		return Container{Name: "flv", MimeType: "video/x-flv", Video: head[4]&0x01 != 0}, true
+	case bytes.HasPrefix(head, asfHeaderGUID):
+		return Container{Name: "asf", MimeType: "video/x-ms-asf", Video: true}, true
+	}
+	return Container{}, false
+}
+
+// SniffReader reads the first SniffLen bytes of r and recognizes their
+// container. Streams shorter than that are sniffed whole.
+func SniffReader(r io.Reader) (Container, bool, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Container{}, false, err
+	}
+	c, ok := Sniff(head[:n])
+	return c, ok, nil
+}
+
+// isoContainer tells the members of the ISO-BMFF family apart by the major
+// brand of the ftyp box at the start of head.
+func isoContainer(head []byte) Container {
+	var brand string
+	if len(head) >= 12 {
+		brand = string(head[8:12])
+	}
+
+	switch {
+	case brand == "qt  ":
+		return Container{Name: "quicktime", MimeType: "video/quicktime", Video: true}
+	case brand == "M4V " || brand == "M4VH" || brand == "M4VP":
+		return Container{Name: "m4v", MimeType: "video/x-m4v", Video: true}
+	case brand == "M4A " || brand == "M4B " || brand == "M4P ":
+		return Container{Name: "m4a", MimeType: "audio/mp4"}
+	case len(brand) == 4 && brand[:3] == "3gp":
+		return Container{Name: "3gp", MimeType: "video/3gpp", Video: true}
+	case len(brand) == 4 && brand[:3] == "3g2":
+		return Container{Name: "3g2", MimeType: "video/3gpp2", Video: true}
+	}
+	return Container{Name: "mp4", MimeType: "video/mp4", Video: true}
+}
+
+// syncedEvery reports whether head has an MPEG-TS sync byte at offset in
+// three consecutive packets of the given size. One sync byte alone is too
+// likely to be a coincidence.
+func syncedEvery(head []byte, offset, packet int) bool {
+	for i := range 3 {
+		pos := offset + i*packet
+		if pos >= len(head) || head[pos] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// ebmlDocType returns the DocType of the EBML header at the start of head, or
+// "" if it is not within head.
+func ebmlDocType(head []byte) string {
+	const docTypeID = "\x42\x82"
+
+	i := bytes.Index(head, []byte(docTypeID))
+	if i < 0 || i+3 > len(head) {
+		return ""
+	}
+	size := head[i+2]
+	if size&0x80 == 0 {
+		// Only one byte sizes, which is all a DocType ever needs
+		return ""
+	}
+	start, end := i+3, i+3+int(size&0x7F)
+	if end > len(head) {
+		return ""
+	}
+	return string(bytes.TrimRight(head[start:end], "\x00"))
+}
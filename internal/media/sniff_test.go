@@ -0,0 +1,50 @@
+package media
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	ts := make([]byte, SniffLen)
+	for i := 0; i < len(ts); i += mpegTSPacket {
+		ts[i] = 0x47
+	}
+	m2ts := make([]byte, SniffLen)
+	for i := 4; i < len(m2ts); i += m2tsPacket {
+		m2ts[i] = 0x47
+	}
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), "video/mp4"},
+		{"m4v", []byte("\x00\x00\x00\x20ftypM4V \x00\x00\x02\x00"), "video/x-m4v"},
+		{"quicktime", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"), "video/quicktime"},
+		{"matroska", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska"), "video/x-matroska"},
+		{"webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), "video/webm"},
+		{"mpegts", ts, "video/mp2t"},
+		{"m2ts", m2ts, "video/mp2t"},
+		{"avi", []byte("RIFF\x00\x10\x00\x00AVI LIST"), "video/x-msvideo"},
+		{"ogg video", append([]byte("OggS\x00\x02"), []byte("\x80theora")...), "video/ogg"},
+		{"ogg audio", append([]byte("OggS\x00\x02"), []byte("\x01vorbis")...), "audio/ogg"},
+		{"flv", []byte("FLV\x01\x05\x00\x00\x00\x09"), "video/x-flv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Sniff(tt.head)
+			if !ok || got.MimeType != tt.want {
+				t.Errorf("got %+v, %v; want %s", got, ok, tt.want)
+			}
+		})
+	}
+
+	if got, ok := Sniff(bytes.Repeat([]byte("not a video "), 100)); ok {
+		t.Errorf("recognized text as %+v", got)
+	}
+	if TypeByExtension(".MKV") != "video/x-matroska" || TypeByExtension(".m2ts") != "video/mp2t" {
+		t.Error("built-in MIME table missing video types")
+	}
+}
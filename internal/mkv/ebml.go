@@ -0,0 +1,197 @@
+// Package mkv reads the parts of Matroska/WebM files needed to serve their
+// subtitle tracks. It works over any io.ReadSeeker and seeks over payloads it
+// does not need, so a torrent backed reader only fetches the pieces that hold
+// the elements actually read.
+package mkv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Element ids used by the reader.
+const (
+	ebmlHeaderID = 0x1A45DFA3
+	segmentID    = 0x18538067
+
+	seekHeadID     = 0x114D9B74
+	seekID         = 0x4DBB
+	seekElementID  = 0x53AB
+	seekPositionID = 0x53AC
+
+	infoID          = 0x1549A966
+	timecodeScaleID = 0x2AD7B1
+
+	tracksID       = 0x1654AE6B
+	trackEntryID   = 0xAE
+	trackNumberID  = 0xD7
+	trackTypeID    = 0x83
+	codecIDID      = 0x86
+	nameID         = 0x536E
+	languageID     = 0x22B59C
+	languageIETFID = 0x22B59D
+	flagDefaultID  = 0x88
+	flagForcedID   = 0x55AA
+
+	clusterID       = 0x1F43B675
+	timestampID     = 0xE7
+	simpleBlockID   = 0xA3
+	blockGroupID    = 0xA0
+	blockID         = 0xA1
+	blockDurationID = 0x9B
+
+	cuesID               = 0x1C53BB6B
+	cuePointID           = 0xBB
+	cueTimeID            = 0xB3
+	cueTrackPositionsID  = 0xB7
+	cueTrackID           = 0xF7
+	cueClusterPositionID = 0xF1
+	cueRelativePosID     = 0xF0
+	cueDurationID        = 0xB2
+)
+
+// maxPayload bounds the size of string and binary elements read into memory.
+const maxPayload = 16 << 20
+
+// UnknownSize is the size ReadElementHeader reports for elements whose size
+// is not coded, such as clusters of a live stream.
+const UnknownSize = -1
+
+// ReadElementHeader reads an EBML element id and data size, and returns the
+// number of header bytes read. The size is UnknownSize when not coded.
+func ReadElementHeader(r io.Reader) (id uint32, size int64, headerLen int, err error) {
+	rawID, idLen, _, err := readVint(r, true)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	rawSize, sizeLen, unknown, err := readVint(r, false)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if unknown {
+		return uint32(rawID), UnknownSize, idLen + sizeLen, nil
+	}
+	if rawSize > math.MaxInt64 {
+		return 0, 0, 0, fmt.Errorf("element 0x%X is too large", rawID)
+	}
+	return uint32(rawID), int64(rawSize), idLen + sizeLen, nil
+}
+
+// readVint reads an EBML variable length integer. Ids keep their length
+// marker bits; sizes have them stripped and report whether every value bit is
+// set, which encodes an unknown size.
+func readVint(r io.Reader, keepMarker bool) (uint64, int, bool, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return 0, 0, false, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, false, fmt.Errorf("invalid EBML variable length integer")
+	}
+
+	value := uint64(first[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	allOnes := value == uint64(0xFF>>length)
+
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, 0, false, err
+	}
+	for _, b := range rest {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+
+	return value, length, !keepMarker && allOnes, nil
+}
+
+func readUint(r io.Reader, size int64) (uint64, error) {
+	if size < 0 || size > 8 {
+		return 0, fmt.Errorf("invalid unsigned integer size %d", size)
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func readBytes(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 || size > maxPayload {
+		return nil, fmt.Errorf("invalid element size %d", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readString(r io.Reader, size int64) (string, error) {
+	b, err := readBytes(r, size)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\x00"), nil
+}
+
+func skip(r io.Seeker, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("cannot skip an element of unknown size")
+	}
+	_, err := r.Seek(size, io.SeekCurrent)
+	return err
+}
+
+func position(r io.Seeker) (int64, error) {
+	return r.Seek(0, io.SeekCurrent)
+}
+
+// children calls fn for each child element of the master element whose data
+// starts at the current position and spans size bytes. fn may read as much of
+// the child's data as it needs, the next child is found by its size.
+func children(r io.ReadSeeker, size int64, fn func(id uint32, size int64) error) error {
+	if size < 0 {
+		return fmt.Errorf("master element of unknown size")
+	}
+
+	start, err := position(r)
+	if err != nil {
+		return err
+	}
+	end := start + size
+
+	for pos := start; pos < end; {
+		id, childSize, _, err := ReadElementHeader(r)
+		if err != nil {
+			return err
+		}
+		if childSize < 0 {
+			return fmt.Errorf("element 0x%X of unknown size inside a sized element", id)
+		}
+		dataStart, err := position(r)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(id, childSize); err != nil {
+			return err
+		}
+
+		pos = dataStart + childSize
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package mkv
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scythe504/webtorrent/internal/subtitles"
+)
+
+// defaultCueDuration is used for subtitle blocks that carry no duration and
+// are not followed by another cue.
+const defaultCueDuration = 4 * time.Second
+
+// cueTarget is where the Cues index says a block of a track is stored.
+type cueTarget struct {
+	cluster  int64 // Absolute offset of the cluster
+	relative int64 // Offset of the block within the cluster data, -1 if unknown
+	time     uint64
+	duration uint64
+}
+
+// ReadSubtitles returns the cues of the text subtitle track with the given
+// number. When the file's Cues index lists the track's blocks, only those
+// blocks (or their clusters) are read. Otherwise every cluster is walked,
+// reading block headers and seeking over the data of other tracks.
+func ReadSubtitles(r io.ReadSeeker, number uint64) ([]subtitles.Cue, error) {
+	s, err := openSegment(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := s.readTracks(r)
+	if err != nil {
+		return nil, err
+	}
+	var track *Track
+	for i := range tracks {
+		if tracks[i].Number == number {
+			track = &tracks[i]
+		}
+	}
+	if track == nil || !track.IsTextSubtitle() {
+		return nil, fmt.Errorf("track %d is not a text subtitle track", number)
+	}
+
+	var blocks []subtitleBlock
+	targets, err := s.readCueTargets(r, number)
+	if err != nil || len(targets) == 0 {
+		blocks, err = s.walkClusters(r, number)
+	} else {
+		blocks, err = s.readTargets(r, number, targets)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.timedCues(track, blocks), nil
+}
+
+// subtitleBlock is a block of the wanted track, with times in track ticks.
+type subtitleBlock struct {
+	time     int64
+	duration int64 // -1 when unknown
+	data     []byte
+}
+
+// timedCues turns blocks into timed text, sorted and with missing durations
+// filled in from the next cue.
+func (s *segment) timedCues(track *Track, blocks []subtitleBlock) []subtitles.Cue {
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].time < blocks[j].time })
+
+	scale := time.Duration(s.timecodeScale)
+	cues := make([]subtitles.Cue, 0, len(blocks))
+	for i, b := range blocks {
+		start := time.Duration(b.time) * scale
+		end := start + defaultCueDuration
+		switch {
+		case b.duration >= 0:
+			end = start + time.Duration(b.duration)*scale
+		case i+1 < len(blocks):
+			end = min(end, time.Duration(blocks[i+1].time)*scale)
+		}
+
+		cues = append(cues, subtitles.Cue{Start: start, End: end, Text: blockText(track.CodecID, b.data)})
+	}
+	return cues
+}
+
+// blockText extracts the text of a subtitle block. ASS and SSA blocks hold
+// the fields of a Dialogue line after its timing, the text being the last.
+func blockText(codec string, data []byte) string {
+	text := strings.TrimRight(string(data), "\x00")
+	switch codec {
+	case CodecASS, CodecSSA, "S_ASS", "S_SSA":
+		fields := strings.SplitN(text, ",", 9)
+		return subtitles.ASSText(fields[len(fields)-1])
+	}
+	return text
+}
+
+// readCueTargets lists where the Cues index puts the blocks of a track.
+func (s *segment) readCueTargets(r io.ReadSeeker, number uint64) ([]cueTarget, error) {
+	if s.cues < 0 {
+		return nil, nil
+	}
+	size, err := enter(r, s.cues, cuesID)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []cueTarget
+	err = children(r, size, func(id uint32, size int64) error {
+		if id != cuePointID {
+			return nil
+		}
+
+		var cueTime uint64
+		return children(r, size, func(id uint32, size int64) error {
+			switch id {
+			case cueTimeID:
+				var err error
+				cueTime, err = readUint(r, size)
+				return err
+			case cueTrackPositionsID:
+			default:
+				return nil
+			}
+
+			t := cueTarget{cluster: -1, relative: -1, time: cueTime}
+			var track uint64
+			err := children(r, size, func(id uint32, size int64) error {
+				var err error
+				var v uint64
+				switch id {
+				case cueTrackID:
+					track, err = readUint(r, size)
+				case cueClusterPositionID:
+					v, err = readUint(r, size)
+					t.cluster = s.dataStart + int64(v)
+				case cueRelativePosID:
+					v, err = readUint(r, size)
+					t.relative = int64(v)
+				case cueDurationID:
+					t.duration, err = readUint(r, size)
+				}
+				return err
+			})
+			if err == nil && track == number && t.cluster >= 0 {
+				targets = append(targets, t)
+			}
+			return err
+		})
+	})
+	return targets, err
+}
+
+// readTargets reads the blocks the Cues index points at. Targets without a
+// relative position cost a scan of their whole cluster, once per cluster.
+func (s *segment) readTargets(r io.ReadSeeker, number uint64, targets []cueTarget) ([]subtitleBlock, error) {
+	var blocks []subtitleBlock
+	seen := make(map[[2]int64]bool)
+	scanned := make(map[int64]bool)
+
+	for _, t := range targets {
+		if seen[[2]int64{t.cluster, t.relative}] || scanned[t.cluster] {
+			continue
+		}
+		seen[[2]int64{t.cluster, t.relative}] = true
+
+		size, err := enter(r, t.cluster, clusterID)
+		if err != nil {
+			return nil, err
+		}
+		dataStart, err := position(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.relative < 0 {
+			if size < 0 {
+				return nil, fmt.Errorf("cluster at %d has unknown size", t.cluster)
+			}
+			scanned[t.cluster] = true
+			found, err := s.scanCluster(r, number, size)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, found...)
+			continue
+		}
+
+		// The cluster timestamp is its first child
+		clusterTime := int64(-1)
+		if id, size, _, err := ReadElementHeader(r); err == nil && id == timestampID {
+			if v, err := readUint(r, size); err == nil {
+				clusterTime = int64(v)
+			}
+		}
+
+		if _, err := r.Seek(dataStart+t.relative, io.SeekStart); err != nil {
+			return nil, err
+		}
+		id, blockSize, _, err := ReadElementHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		b, ok, err := readBlockElement(r, id, blockSize, number)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if clusterTime >= 0 {
+			b.time += clusterTime
+		} else {
+			b.time = int64(t.time)
+		}
+		if b.duration < 0 && t.duration > 0 {
+			b.duration = int64(t.duration)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// scanCluster collects the blocks of a track from the cluster whose data
+// starts at the current position.
+func (s *segment) scanCluster(r io.ReadSeeker, number uint64, size int64) ([]subtitleBlock, error) {
+	var blocks []subtitleBlock
+	var clusterTime int64
+	err := children(r, size, func(id uint32, size int64) error {
+		if id == timestampID {
+			v, err := readUint(r, size)
+			clusterTime = int64(v)
+			return err
+		}
+
+		b, ok, err := readBlockElement(r, id, size, number)
+		if ok {
+			b.time += clusterTime
+			blocks = append(blocks, b)
+		}
+		return err
+	})
+	return blocks, err
+}
+
+// walkClusters reads the blocks of a track by walking every cluster from the
+// first one. Unknown-size clusters are handled by treating their children as
+// if they were siblings of the clusters.
+func (s *segment) walkClusters(r io.ReadSeeker, number uint64) ([]subtitleBlock, error) {
+	if s.firstCluster < 0 {
+		return nil, nil
+	}
+	if _, err := r.Seek(s.firstCluster, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var blocks []subtitleBlock
+	var clusterTime int64
+	for {
+		pos, err := position(r)
+		if err != nil {
+			return nil, err
+		}
+		if s.end >= 0 && pos >= s.end {
+			break
+		}
+
+		id, size, _, err := ReadElementHeader(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		dataStart, err := position(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case clusterID:
+			// Descend into the cluster
+			continue
+		case timestampID:
+			v, err := readUint(r, size)
+			if err != nil {
+				return nil, err
+			}
+			clusterTime = int64(v)
+		default:
+			b, ok, err := readBlockElement(r, id, size, number)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				b.time += clusterTime
+				blocks = append(blocks, b)
+			}
+		}
+
+		if size < 0 {
+			return nil, fmt.Errorf("element 0x%X of unknown size at %d", id, pos)
+		}
+		if _, err := r.Seek(dataStart+size, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// readBlockElement reads a SimpleBlock or BlockGroup whose data starts at the
+// current position, and reports whether it belongs to the track. Blocks of
+// other tracks are identified from their header alone.
+func readBlockElement(r io.ReadSeeker, id uint32, size int64, number uint64) (subtitleBlock, bool, error) {
+	switch id {
+	case simpleBlockID:
+		return readBlock(r, size, number)
+	case blockGroupID:
+		var (
+			b        subtitleBlock
+			ok       bool
+			duration = int64(-1)
+		)
+		err := children(r, size, func(id uint32, size int64) error {
+			var err error
+			switch id {
+			case blockID:
+				b, ok, err = readBlock(r, size, number)
+			case blockDurationID:
+				var v uint64
+				v, err = readUint(r, size)
+				duration = int64(v)
+			}
+			return err
+		})
+		b.duration = duration
+		return b, ok, err
+	}
+	return subtitleBlock{}, false, nil
+}
+
+// readBlock reads a block whose data starts at the current position. Laced
+// blocks are skipped, subtitle tracks do not use lacing.
+func readBlock(r io.Reader, size int64, number uint64) (subtitleBlock, bool, error) {
+	track, n, _, err := readVint(r, false)
+	if err != nil {
+		return subtitleBlock{}, false, err
+	}
+	if track != number {
+		return subtitleBlock{}, false, nil
+	}
+
+	var head [3]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return subtitleBlock{}, false, err
+	}
+	if head[2]&0x06 != 0 {
+		return subtitleBlock{}, false, nil
+	}
+
+	data, err := readBytes(r, size-int64(n)-3)
+	if err != nil {
+		return subtitleBlock{}, false, err
+	}
+	return subtitleBlock{
+		time:     int64(int16(uint16(head[0])<<8 | uint16(head[1]))),
+		duration: -1,
+		data:     data,
+	}, true, nil
+}
@@ -0,0 +1,167 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scythe504/webtorrent/internal/subtitles"
+)
+
+// el encodes an element with an 8 byte size, so offsets are easy to compute.
+func el(id uint32, data ...[]byte) []byte {
+	var b bytes.Buffer
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || b.Len() > 0 {
+			b.WriteByte(c)
+		}
+	}
+	body := bytes.Join(data, nil)
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(body)))
+	size[0] = 0x01
+	b.Write(size[:])
+	b.Write(body)
+	return b.Bytes()
+}
+
+func uintEl(id uint32, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return el(id, b[:])
+}
+
+func block(id uint32, track byte, timecode int16, data string) []byte {
+	head := []byte{0x80 | track, byte(uint16(timecode) >> 8), byte(timecode), 0}
+	return el(id, head, []byte(data))
+}
+
+// testFile builds a Matroska file with a video track, an SRT track indexed by
+// Cues and an ASS track that is not, behind large video blocks.
+func testFile() []byte {
+	video := bytes.Repeat([]byte{0xAB}, 1<<20)
+
+	info := el(infoID, uintEl(timecodeScaleID, 1000000))
+	tracks := el(tracksID,
+		el(trackEntryID, uintEl(trackNumberID, 1), uintEl(trackTypeID, 1), el(codecIDID, []byte("V_MPEG4/ISO/AVC"))),
+		el(trackEntryID, uintEl(trackNumberID, 2), uintEl(trackTypeID, trackTypeSubtitle), el(codecIDID, []byte(CodecSRT)),
+			el(nameID, []byte("English")), el(languageID, []byte("eng"))),
+		el(trackEntryID, uintEl(trackNumberID, 3), uintEl(trackTypeID, trackTypeSubtitle), el(codecIDID, []byte(CodecASS)),
+			el(languageID, []byte("ger")), uintEl(flagDefaultID, 0)),
+	)
+
+	group1 := el(blockGroupID, block(blockID, 2, 1000, "Hello"), uintEl(blockDurationID, 2000))
+	cluster1Head := bytes.Join([][]byte{uintEl(timestampID, 0), el(simpleBlockID, []byte{0x81, 0, 0, 0x80}, video)}, nil)
+	cluster1 := el(clusterID, cluster1Head, group1, block(simpleBlockID, 3, 500, `0,0,Default,,0,0,0,,{\i1}Hallo\NWelt`))
+	cluster2 := el(clusterID, uintEl(timestampID, 10000), el(simpleBlockID, []byte{0x81, 0, 0, 0x80}, video),
+		el(blockGroupID, block(blockID, 2, 0, "Second line"), uintEl(blockDurationID, 1500)))
+
+	seekHead := func(cuesPos uint64) []byte {
+		seek := func(id uint32, pos uint64) []byte {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], id)
+			return el(seekID, el(seekElementID, b[:]), uintEl(seekPositionID, pos))
+		}
+		return el(seekHeadID, seek(cuesID, cuesPos))
+	}
+
+	// Positions are relative to the segment data
+	cluster1Pos := uint64(len(seekHead(0)) + len(info) + len(tracks))
+	cluster2Pos := cluster1Pos + uint64(len(cluster1))
+	cuesPos := cluster2Pos + uint64(len(cluster2))
+
+	cues := el(cuesID,
+		el(cuePointID, uintEl(cueTimeID, 1000), el(cueTrackPositionsID,
+			uintEl(cueTrackID, 2), uintEl(cueClusterPositionID, cluster1Pos), uintEl(cueRelativePosID, uint64(len(cluster1Head))))),
+		el(cuePointID, uintEl(cueTimeID, 10000), el(cueTrackPositionsID,
+			uintEl(cueTrackID, 2), uintEl(cueClusterPositionID, cluster2Pos))),
+	)
+
+	header := el(ebmlHeaderID, el(0x4282, []byte("matroska")))
+	segment := el(segmentID, seekHead(cuesPos), info, tracks, cluster1, cluster2, cues)
+	return append(header, segment...)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadSeeker
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestSubtitleTracks(t *testing.T) {
+	tracks, err := SubtitleTracks(bytes.NewReader(testFile()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Track{
+		{Number: 2, Type: trackTypeSubtitle, CodecID: CodecSRT, Name: "English", Language: "eng", Default: true},
+		{Number: 3, Type: trackTypeSubtitle, CodecID: CodecASS, Language: "ger"},
+	}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Fatalf("got tracks %+v, want %+v", tracks, want)
+	}
+}
+
+func TestReadSubtitles(t *testing.T) {
+	data := testFile()
+	path := filepath.Join(t.TempDir(), "test.mkv")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		track uint64
+		want  []subtitles.Cue
+	}{
+		{"cues", 2, []subtitles.Cue{
+			{Start: time.Second, End: 3 * time.Second, Text: "Hello"},
+			{Start: 10 * time.Second, End: 11500 * time.Millisecond, Text: "Second line"},
+		}},
+		{"cluster walk", 3, []subtitles.Cue{
+			{Start: 500 * time.Millisecond, End: 500*time.Millisecond + defaultCueDuration, Text: "Hallo\nWelt"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &countingReader{ReadSeeker: bytes.NewReader(data)}
+			cues, err := ReadSubtitles(r, tt.track)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cues, tt.want) {
+				t.Fatalf("got cues %+v, want %+v", cues, tt.want)
+			}
+			// The video blocks are seeked over, not read
+			if r.n > 4096 {
+				t.Errorf("read %d bytes of a %d byte file", r.n, len(data))
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if cues, err := ReadSubtitles(f, tt.track); err != nil || !reflect.DeepEqual(cues, tt.want) {
+				t.Fatalf("from file: got cues %+v, %v", cues, err)
+			}
+		})
+	}
+
+	if _, err := ReadSubtitles(bytes.NewReader(data), 1); err == nil {
+		t.Error("expected an error for the video track")
+	}
+}
@@ -0,0 +1,303 @@
+package mkv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotMatroska is returned for streams that do not start with an EBML
+// header.
+var ErrNotMatroska = errors.New("not a Matroska file")
+
+// trackTypeSubtitle is the TrackType of subtitle tracks.
+const trackTypeSubtitle = 0x11
+
+// Codec ids of the text subtitle formats that can be turned into WebVTT.
+const (
+	CodecSRT    = "S_TEXT/UTF8"
+	CodecASS    = "S_TEXT/ASS"
+	CodecSSA    = "S_TEXT/SSA"
+	CodecWebVTT = "S_TEXT/WEBVTT"
+)
+
+// Track is a track entry of a Matroska file.
+type Track struct {
+	Number   uint64
+	Type     uint64
+	CodecID  string
+	Name     string
+	Language string // BCP 47 when the file has it, ISO 639-2 otherwise
+	Default  bool
+	Forced   bool
+}
+
+// IsTextSubtitle reports whether t is a subtitle track in one of the text
+// formats this package can extract.
+func (t Track) IsTextSubtitle() bool {
+	if t.Type != trackTypeSubtitle {
+		return false
+	}
+	switch t.CodecID {
+	case CodecSRT, CodecASS, CodecSSA, CodecWebVTT, "S_ASS", "S_SSA":
+		return true
+	}
+	return false
+}
+
+// segment holds what the reader learned from the segment's top-level
+// elements. Positions are absolute offsets of element headers, or -1 when the
+// element was not found.
+type segment struct {
+	dataStart     int64
+	end           int64 // -1 when the segment size is unknown
+	timecodeScale uint64
+	tracks        int64
+	cues          int64
+	firstCluster  int64
+}
+
+// openSegment walks the top-level elements up to the first cluster, using the
+// SeekHead to locate elements stored after the clusters such as the Cues.
+func openSegment(r io.ReadSeeker) (*segment, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	id, size, _, err := ReadElementHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != ebmlHeaderID {
+		return nil, ErrNotMatroska
+	}
+	if err := skip(r, size); err != nil {
+		return nil, err
+	}
+
+	id, size, _, err = ReadElementHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != segmentID {
+		return nil, fmt.Errorf("expected Segment, found element 0x%X", id)
+	}
+
+	s := &segment{timecodeScale: 1000000, tracks: -1, cues: -1, firstCluster: -1, end: -1}
+	if s.dataStart, err = position(r); err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		s.end = s.dataStart + size
+	}
+
+	info := int64(-1)
+	for {
+		start, err := position(r)
+		if err != nil {
+			return nil, err
+		}
+		if s.end >= 0 && start >= s.end {
+			break
+		}
+
+		id, size, _, err := ReadElementHeader(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id == clusterID {
+			s.firstCluster = start
+			break
+		}
+
+		dataStart, err := position(r)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case seekHeadID:
+			if err := s.readSeekHead(r, size, &info); err != nil {
+				return nil, err
+			}
+		case infoID:
+			info = start
+		case tracksID:
+			s.tracks = start
+		case cuesID:
+			s.cues = start
+		}
+
+		if size < 0 {
+			break
+		}
+		if _, err := r.Seek(dataStart+size, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if info >= 0 {
+		if err := s.readInfo(r, info); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// readSeekHead records the positions of the elements listed in a SeekHead.
+func (s *segment) readSeekHead(r io.ReadSeeker, size int64, info *int64) error {
+	return children(r, size, func(id uint32, size int64) error {
+		if id != seekID {
+			return nil
+		}
+
+		var target uint64
+		pos := int64(-1)
+		err := children(r, size, func(id uint32, size int64) error {
+			var err error
+			switch id {
+			case seekElementID:
+				var b []byte
+				if b, err = readBytes(r, size); err == nil {
+					for _, c := range b {
+						target = target<<8 | uint64(c)
+					}
+				}
+			case seekPositionID:
+				var v uint64
+				if v, err = readUint(r, size); err == nil {
+					pos = s.dataStart + int64(v)
+				}
+			}
+			return err
+		})
+		if err != nil || pos < 0 {
+			return err
+		}
+
+		switch target {
+		case infoID:
+			*info = pos
+		case tracksID:
+			s.tracks = pos
+		case cuesID:
+			s.cues = pos
+		}
+		return nil
+	})
+}
+
+func (s *segment) readInfo(r io.ReadSeeker, at int64) error {
+	size, err := enter(r, at, infoID)
+	if err != nil {
+		return err
+	}
+	return children(r, size, func(id uint32, size int64) error {
+		if id != timecodeScaleID {
+			return nil
+		}
+		scale, err := readUint(r, size)
+		if err == nil && scale > 0 {
+			s.timecodeScale = scale
+		}
+		return err
+	})
+}
+
+// enter seeks to the element at pos, checks it is want and returns its size,
+// leaving r at the start of its data.
+func enter(r io.ReadSeeker, pos int64, want uint32) (int64, error) {
+	if _, err := r.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	id, size, _, err := ReadElementHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	if id != want {
+		return 0, fmt.Errorf("expected element 0x%X at %d, found 0x%X", want, pos, id)
+	}
+	return size, nil
+}
+
+// ReadTracks returns every track of the Matroska file in r.
+func ReadTracks(r io.ReadSeeker) ([]Track, error) {
+	s, err := openSegment(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.readTracks(r)
+}
+
+// SubtitleTracks returns the text subtitle tracks of the Matroska file in r.
+func SubtitleTracks(r io.ReadSeeker) ([]Track, error) {
+	tracks, err := ReadTracks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []Track
+	for _, t := range tracks {
+		if t.IsTextSubtitle() {
+			subs = append(subs, t)
+		}
+	}
+	return subs, nil
+}
+
+func (s *segment) readTracks(r io.ReadSeeker) ([]Track, error) {
+	if s.tracks < 0 {
+		return nil, fmt.Errorf("no Tracks element found")
+	}
+	size, err := enter(r, s.tracks, tracksID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	err = children(r, size, func(id uint32, size int64) error {
+		if id != trackEntryID {
+			return nil
+		}
+
+		t := Track{Default: true, Language: "eng"}
+		var ietf string
+		err := children(r, size, func(id uint32, size int64) error {
+			var err error
+			var v uint64
+			switch id {
+			case trackNumberID:
+				t.Number, err = readUint(r, size)
+			case trackTypeID:
+				t.Type, err = readUint(r, size)
+			case codecIDID:
+				t.CodecID, err = readString(r, size)
+			case nameID:
+				t.Name, err = readString(r, size)
+			case languageID:
+				t.Language, err = readString(r, size)
+			case languageIETFID:
+				ietf, err = readString(r, size)
+			case flagDefaultID:
+				v, err = readUint(r, size)
+				t.Default = v != 0
+			case flagForcedID:
+				v, err = readUint(r, size)
+				t.Forced = v != 0
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if ietf != "" {
+			t.Language = ietf
+		}
+		tracks = append(tracks, t)
+		return nil
+	})
+	return tracks, err
+}
@@ -20,6 +20,11 @@ type Service interface {
 	CreateVideo(video Video) error
 	GetAllVideos() ([]Video, error)
 	UpdateStatus(status STATUS, videoId string, filePath *string) error
+	AddUploaded(videoId string, bytes, length int64) error
+	// TokenMethods
+	CreateToken(token APIToken) error
+	GetTokenByHash(hash string) (APIToken, error)
+	RevokeToken(tokenId string) error
 	// Health returns a map of health status information.
 	// The keys and values in the map are service-specific.
 	Health() map[string]string
@@ -73,7 +78,7 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf("db down: %v", err) // Log the error and terminate the program
+		log.Printf("db down: %v", err)
 		return stats
 	}
 
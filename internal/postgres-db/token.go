@@ -0,0 +1,87 @@
+package postgresdb
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// APIToken is a token clients authenticate with. Only the hash of the token
+// is stored.
+type APIToken struct {
+	Id        string `db:"id" json:"id"`
+	Name      string `db:"name" json:"name"`
+	TokenHash string `db:"token_hash" json:"-"`
+	// Scopes are stored comma separated, e.g. "read,stream".
+	Scopes []string `db:"scopes" json:"scopes"`
+	// MaxActiveTorrents bounds how many torrents added with the token may be
+	// active at once, 0 for no limit.
+	MaxActiveTorrents int       `db:"max_active_torrents" json:"max_active_torrents"`
+	CreatedAt         time.Time `db:"created_at" json:"created_at"`
+	Revoked           bool      `db:"revoked" json:"revoked"`
+}
+
+func (s *service) CreateToken(token APIToken) error {
+	stmt := `
+		INSERT INTO api_tokens (
+			id,
+			name,
+			token_hash,
+			scopes,
+			max_active_torrents,
+			created_at,
+			revoked
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := s.db.Exec(stmt,
+		token.Id,
+		token.Name,
+		token.TokenHash,
+		strings.Join(token.Scopes, ","),
+		token.MaxActiveTorrents,
+		token.CreatedAt,
+		token.Revoked,
+	)
+
+	return err
+}
+
+// GetTokenByHash returns the token stored under hash, revoked or not.
+func (s *service) GetTokenByHash(hash string) (APIToken, error) {
+	var token APIToken
+	var scopes string
+
+	stmt := `
+		SELECT
+			id,
+			name,
+			token_hash,
+			scopes,
+			max_active_torrents,
+			created_at,
+			revoked
+		FROM api_tokens
+		WHERE token_hash = $1
+	`
+
+	row := s.db.QueryRow(stmt, hash)
+
+	err := row.Scan(&token.Id, &token.Name, &token.TokenHash, &scopes, &token.MaxActiveTorrents, &token.CreatedAt, &token.Revoked)
+	token.Scopes = strings.Split(scopes, ",")
+
+	return token, err
+}
+
+// RevokeToken revokes the token with id. It returns sql.ErrNoRows when there
+// is no such token.
+func (s *service) RevokeToken(tokenId string) error {
+	res, err := s.db.Exec(`UPDATE api_tokens SET revoked = TRUE WHERE id = $1`, tokenId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
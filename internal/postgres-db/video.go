@@ -21,6 +21,10 @@ type Video struct {
 	FilePath   string    `db:"file_path" json:"file_path"`
 	CreatedAt  time.Time `db:"created_at" json:"created_at"`
 	Deleted    bool      `db:"deleted" json:"deleted"`
+	// UploadedBytes is how much of the saved file was seeded back, and Ratio
+	// that amount as a multiple of the file's size.
+	UploadedBytes int64   `db:"uploaded_bytes" json:"uploaded_bytes"`
+	Ratio         float64 `db:"ratio" json:"ratio"`
 }
 
 func (s *service) CreateVideo(video Video) error {
@@ -57,14 +61,16 @@ func (s *service) GetVideo(videoId string) (Video, error) {
 			status, 
 			file_path, 
 			created_at, 
-			deleted
+			deleted,
+			uploaded_bytes,
+			ratio
 		FROM videos
 		WHERE id = $1
 	`
 
 	row := s.db.QueryRow(stmt, videoId)
 
-	err := row.Scan(&video.Id, &video.MagnetLink, &video.Status, &video.FilePath, &video.CreatedAt, &video.Deleted)
+	err := row.Scan(&video.Id, &video.MagnetLink, &video.Status, &video.FilePath, &video.CreatedAt, &video.Deleted, &video.UploadedBytes, &video.Ratio)
 
 	return video, err
 }
@@ -77,7 +83,9 @@ func (s *service) GetAllVideos() ([]Video, error) {
 			status, 
 			file_path, 
 			created_at, 
-			deleted
+			deleted,
+			uploaded_bytes,
+			ratio
 		FROM videos
 		WHERE deleted = FALSE
 		ORDER BY created_at DESC
@@ -93,7 +101,7 @@ func (s *service) GetAllVideos() ([]Video, error) {
 
 	for rows.Next() {
 		var v Video
-		err := rows.Scan(&v.Id, &v.MagnetLink, &v.Status, &v.FilePath, &v.CreatedAt, &v.Deleted)
+		err := rows.Scan(&v.Id, &v.MagnetLink, &v.Status, &v.FilePath, &v.CreatedAt, &v.Deleted, &v.UploadedBytes, &v.Ratio)
 		if err != nil {
 			return nil, err
 		}
@@ -131,3 +139,17 @@ func (s *service) UpdateStatus(status STATUS, videoId string, filePath *string)
 	_, err := s.db.Exec(stmt, args...)
 	return err
 }
+
+// AddUploaded adds bytes to the upload count of a video and updates its ratio
+// for a saved file of length bytes.
+func (s *service) AddUploaded(videoId string, bytes, length int64) error {
+	stmt := `
+		UPDATE videos
+		SET uploaded_bytes = uploaded_bytes + $2::BIGINT,
+			ratio = CASE WHEN $3::BIGINT > 0 THEN (uploaded_bytes + $2::BIGINT)::DOUBLE PRECISION / $3::BIGINT ELSE 0 END
+		WHERE id = $1
+	`
+
+	_, err := s.db.Exec(stmt, videoId, bytes, length)
+	return err
+}
@@ -19,6 +19,15 @@ type Service interface {
 
 	PublishJob(ctx context.Context, job Job) error
 	ConsumeJob(ctx context.Context, consumerName string) (*Job, error)
+
+	SaveBandwidth(ctx context.Context, settings []byte) error
+	WatchBandwidth(ctx context.Context, apply func(settings []byte))
+
+	PublishStatus(ctx context.Context, change StatusChange) error
+	WatchStatus(ctx context.Context, apply func(change StatusChange))
+
+	EnsureStreamKey(ctx context.Context, candidate []byte) ([]byte, error)
+	SaveStreamKey(ctx context.Context, key []byte) error
 }
 
 type service struct {
@@ -34,9 +43,12 @@ var (
 )
 
 func New(ctx context.Context) Service {
-	num, err := strconv.Atoi(database)
-	if err != nil {
-		log.Fatalf("database incorrect %v", err)
+	num := 0
+	if database != "" {
+		var err error
+		if num, err = strconv.Atoi(database); err != nil {
+			log.Fatalf("database incorrect %v", err)
+		}
 	}
 
 	fullAddress := fmt.Sprintf("%s:%s", address, port)
@@ -75,10 +87,11 @@ func (s *service) Health() map[string]string {
 func (s *service) checkRedisHealth(ctx context.Context, stats map[string]string) map[string]string {
 	// Ping the Redis server to check its availability.
 	pong, err := s.db.Ping(ctx).Result()
-	// Note: By extracting and simplifying like this, `log.Fatalf("db down: %v", err)`
-	// can be changed into a standard error instead of a fatal error.
 	if err != nil {
-		log.Fatalf("db down: %v", err)
+		stats["redis_status"] = "down"
+		stats["redis_message"] = fmt.Sprintf("redis down: %v", err)
+		log.Printf("redis down: %v", err)
+		return stats
 	}
 
 	// Redis is up
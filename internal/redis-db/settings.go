@@ -0,0 +1,53 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// bandwidthKey holds the bandwidth settings set through the API, and
+// bandwidthChannel announces changes to them.
+const (
+	bandwidthKey     = "settings:bandwidth"
+	bandwidthChannel = "settings:bandwidth"
+)
+
+// SaveBandwidth stores the bandwidth settings and pushes them to watchers.
+func (s *service) SaveBandwidth(ctx context.Context, settings []byte) error {
+	if err := s.db.Set(ctx, bandwidthKey, settings, 0).Err(); err != nil {
+		return err
+	}
+	return s.db.Publish(ctx, bandwidthChannel, settings).Err()
+}
+
+// WatchBandwidth calls apply with the stored bandwidth settings, if any, and
+// again every time they are saved, until ctx is done.
+func (s *service) WatchBandwidth(ctx context.Context, apply func(settings []byte)) {
+	sub := s.db.Subscribe(ctx, bandwidthChannel)
+	defer sub.Close()
+
+	// Subscribe first so a change saved in between is not missed
+	settings, err := s.db.Get(ctx, bandwidthKey).Bytes()
+	switch {
+	case err == nil:
+		apply(settings)
+	case !errors.Is(err, redis.Nil):
+		log.Println("[Settings] failed to load bandwidth settings", err)
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			apply([]byte(msg.Payload))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
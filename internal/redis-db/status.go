@@ -0,0 +1,51 @@
+package redisdb
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// videoStatusChannel announces changes to the status of videos, so the API
+// can drop what it cached about their saved files.
+const videoStatusChannel = "videos:status"
+
+// StatusChange is a video moving to a new status, e.g. "downloaded".
+type StatusChange struct {
+	VideoId string `json:"video_id"`
+	Status  string `json:"status"`
+}
+
+// PublishStatus announces that the status of a video changed.
+func (s *service) PublishStatus(ctx context.Context, change StatusChange) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return s.db.Publish(ctx, videoStatusChannel, payload).Err()
+}
+
+// WatchStatus calls apply for every status change published until ctx is
+// done. Changes published while nobody watches are not replayed.
+func (s *service) WatchStatus(ctx context.Context, apply func(change StatusChange)) {
+	sub := s.db.Subscribe(ctx, videoStatusChannel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var change StatusChange
+			if err := json.Unmarshal([]byte(msg.Payload), &change); err != nil {
+				log.Println("[Status] invalid status change", err)
+				continue
+			}
+			apply(change)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package redisdb
+
+import (
+	"context"
+)
+
+// streamKeyKey holds the key stream links are signed with.
+const streamKeyKey = "settings:stream-key"
+
+// EnsureStreamKey stores candidate as the stream signing key unless one is
+// stored already, and returns the stored key.
+func (s *service) EnsureStreamKey(ctx context.Context, candidate []byte) ([]byte, error) {
+	if err := s.db.SetNX(ctx, streamKeyKey, candidate, 0).Err(); err != nil {
+		return nil, err
+	}
+	return s.db.Get(ctx, streamKeyKey).Bytes()
+}
+
+// SaveStreamKey replaces the stream signing key, so links signed with the
+// previous one stop working.
+func (s *service) SaveStreamKey(ctx context.Context, key []byte) error {
+	return s.db.Set(ctx, streamKeyKey, key, 0).Err()
+}
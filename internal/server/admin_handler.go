@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+func (s *Server) listCachedMetainfo(w http.ResponseWriter, r *http.Request) {
+	list, err := s.client.CachedMetainfo()
+	if err != nil {
+		log.Println("[MetainfoCache] failed to list cache", err)
+		http.Error(w, "failed to list cached metainfo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
+
+// pruneCachedMetainfo deletes cached metainfo unused for the older_than query
+// duration, e.g. "720h". Clearing the whole cache takes an explicit all=true
+// instead, so a bare DELETE never wipes it.
+func (s *Server) pruneCachedMetainfo(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	all, _ := strconv.ParseBool(query.Get("all"))
+	raw := query.Get("older_than")
+	if all == (raw != "") {
+		http.Error(w, "give either older_than, a duration such as 720h, or all=true", http.StatusBadRequest)
+		return
+	}
+
+	var olderThan time.Duration
+	if raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			http.Error(w, "older_than must be a duration such as 720h", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	}
+
+	removed, err := s.client.PruneCachedMetainfo(olderThan)
+	if err != nil {
+		log.Println("[MetainfoCache] failed to prune cache", err)
+		http.Error(w, "failed to prune cached metainfo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
+
+func (s *Server) deleteCachedMetainfo(w http.ResponseWriter, r *http.Request) {
+	infoHash := mux.Vars(r)["infoHash"]
+
+	if err := s.client.DeleteCachedMetainfo(infoHash); err != nil {
+		if errors.Is(err, tor.ErrNotFound) {
+			http.Error(w, "no cached metainfo for this info-hash", http.StatusNotFound)
+			return
+		}
+		log.Println("[MetainfoCache] failed to delete entry", err)
+		http.Error(w, "failed to delete cached metainfo", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBlocklist reports the loaded IP blocklist and how many peers it kept out.
+func (s *Server) getBlocklist(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.client.Blocklist()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// reloadBlocklist reads the blocklist file again without waiting for the
+// change to be noticed.
+func (s *Server) reloadBlocklist(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.client.ReloadBlocklist()
+	if err != nil {
+		log.Println("[Blocklist] failed to reload", err)
+		if errors.Is(err, tor.ErrNoBlocklist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to reload the blocklist", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
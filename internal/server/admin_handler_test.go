@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+func TestPruneCachedMetainfo(t *testing.T) {
+	dataDir := t.TempDir()
+	path := filepath.Join(dataDir, "video.mp4")
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 16 * 1024}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding the torrent caches its metainfo
+	client := tor.New(tor.Config{DataDir: dataDir})
+	if _, err := client.AddMetainfo("vid", &metainfo.MetaInfo{InfoBytes: infoBytes}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, token := adminDB()
+	s := &Server{db: db, t: client, client: client, idempotency: newIdempotencyCache(), tokens: newTokenCache()}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	prune := func(query string) (int, int) {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/admin/metainfo"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct{ Removed int }
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Removed
+	}
+
+	for _, query := range []string{"", "?all=false", "?all=true&older_than=1h", "?older_than=soon"} {
+		if status, _ := prune(query); status != http.StatusBadRequest {
+			t.Errorf("prune %q: got %d, want 400", query, status)
+		}
+	}
+	if status, removed := prune("?older_than=720h"); status != http.StatusOK || removed != 0 {
+		t.Errorf("prune of old entries: got %d, %d removed; want the recent entry kept", status, removed)
+	}
+	if status, removed := prune("?all=true"); status != http.StatusOK || removed != 1 {
+		t.Errorf("prune of all entries: got %d, %d removed; want 1", status, removed)
+	}
+}
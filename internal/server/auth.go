@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/scythe504/webtorrent/internal/auth"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// tokenCacheTTL is how long a token lookup is reused before asking the
+// database again, and so how long a revoked token keeps working.
+const tokenCacheTTL = time.Minute
+
+// cachedToken is a token lookup. token is nil for hashes that match no usable
+// token, so guessing does not hit the database on every request.
+type cachedToken struct {
+	token   *postgresdb.APIToken
+	expires time.Time
+}
+
+// tokenCache remembers token lookups by hash, saving a query on every range
+// request of a stream.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedToken
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{entries: make(map[string]cachedToken)}
+}
+
+// lookup returns the usable token stored under hash, nil if there is none.
+func (c *tokenCache) lookup(db postgresdb.Service, hash string) (*postgresdb.APIToken, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[hash]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.token, nil
+	}
+
+	token, err := db.GetTokenByHash(hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	entry = cachedToken{expires: time.Now().Add(tokenCacheTTL)}
+	if err == nil && !token.Revoked {
+		entry.token = &token
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[hash] = entry
+	return entry.token, nil
+}
+
+type tokenContextKey struct{}
+
+// requestToken returns the token r was authenticated with, nil when
+// authentication is not required.
+func requestToken(r *http.Request) *postgresdb.APIToken {
+	token, _ := r.Context().Value(tokenContextKey{}).(*postgresdb.APIToken)
+	return token
+}
+
+// authorize lets requests through to next only with a bearer token granting
+// scope, when authentication is required. The admin scope is required even
+// when it is not, so the /admin routes are never open.
+func (s *Server) authorize(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authRequired && scope != auth.ScopeAdmin {
+			next(w, r)
+			return
+		}
+
+		raw := auth.FromRequest(r)
+		if raw == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fluxstream"`)
+			http.Error(w, "missing API token", http.StatusUnauthorized)
+			return
+		}
+
+		token, err := s.tokens.lookup(s.db, auth.Hash(raw))
+		if err != nil {
+			log.Println("[Auth] failed to look up token", err)
+			http.Error(w, "failed to check API token", http.StatusServiceUnavailable)
+			return
+		}
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fluxstream", error="invalid_token"`)
+			http.Error(w, "invalid or revoked API token", http.StatusUnauthorized)
+			return
+		}
+		if !auth.Allows(token.Scopes, scope) {
+			http.Error(w, "API token lacks the "+string(scope)+" scope", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+	}
+}
+
+// torrentQuotas tracks the torrents each token added, to hold tokens to their
+// MaxActiveTorrents. Torrents count until the engine drops them, and are
+// forgotten on restart.
+type torrentQuotas struct {
+	mu      sync.Mutex
+	held    map[string]map[string]bool // Token id to the video ids it added
+	pending map[string]int             // Token id to adds in progress
+}
+
+func newTorrentQuotas() *torrentQuotas {
+	return &torrentQuotas{
+		held:    make(map[string]map[string]bool),
+		pending: make(map[string]int),
+	}
+}
+
+// reserve claims a slot for a torrent token is about to add, and reports
+// false when token already holds as many as it may. A claimed slot is given
+// back with commit.
+func (q *torrentQuotas) reserve(token *postgresdb.APIToken, engine tor.Engine) bool {
+	if token == nil || token.MaxActiveTorrents == 0 {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	held := q.held[token.Id]
+	for id := range held {
+		if !engine.Has(id) {
+			delete(held, id)
+		}
+	}
+	if len(held)+q.pending[token.Id] >= token.MaxActiveTorrents {
+		return false
+	}
+	q.pending[token.Id]++
+	return true
+}
+
+// commit gives back a slot claimed with reserve, counting videoId against
+// token from now on if its torrent was newly added.
+func (q *torrentQuotas) commit(token *postgresdb.APIToken, videoId string, added bool) {
+	if token == nil || token.MaxActiveTorrents == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[token.Id]--
+	if !added {
+		return
+	}
+	if q.held[token.Id] == nil {
+		q.held[token.Id] = make(map[string]bool)
+	}
+	q.held[token.Id][videoId] = true
+}
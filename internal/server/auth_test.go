@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scythe504/webtorrent/internal/auth"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+	"github.com/scythe504/webtorrent/internal/tor/tortest"
+)
+
+// tokenDB holds API tokens by hash.
+type tokenDB struct {
+	emptyDB
+	tokens map[string]postgresdb.APIToken
+}
+
+func (db tokenDB) GetTokenByHash(hash string) (postgresdb.APIToken, error) {
+	token, ok := db.tokens[hash]
+	if !ok {
+		return postgresdb.APIToken{}, sql.ErrNoRows
+	}
+	return token, nil
+}
+
+// adminDB holds a single admin token, returned with it.
+func adminDB() (tokenDB, string) {
+	token, hash := auth.NewToken()
+	return tokenDB{tokens: map[string]postgresdb.APIToken{hash: {Id: "admin", Scopes: []string{"admin"}}}}, token
+}
+
+func TestAuthorize(t *testing.T) {
+	reader, readerHash := auth.NewToken()
+	adder, adderHash := auth.NewToken()
+	revoked, revokedHash := auth.NewToken()
+	db := tokenDB{tokens: map[string]postgresdb.APIToken{
+		readerHash:  {Id: "reader", Scopes: []string{"read", "stream"}},
+		adderHash:   {Id: "adder", Scopes: []string{"add"}, MaxActiveTorrents: 1},
+		revokedHash: {Id: "revoked", Scopes: []string{"admin"}, Revoked: true},
+	}}
+
+	engine := tortest.New()
+	first := engine.Add("a.mkv", tortest.File{Path: "a.mkv", Data: []byte("first")})
+	second := engine.Add("b.mkv", tortest.File{Path: "b.mkv", Data: []byte("second")})
+
+	s := &Server{
+		db:             db,
+		t:              engine,
+		streamResolver: newStreamResolver(db, nil),
+		idempotency:    newIdempotencyCache(),
+		authRequired:   true,
+		tokens:         newTokenCache(),
+		quotas:         newTorrentQuotas(),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	do := func(method, path, token string, body any) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, srv.URL+path, &buf)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	cases := []struct {
+		name         string
+		method, path string
+		token        string
+		body         any
+		want         int
+	}{
+		{"probe", "GET", "/livez", "", nil, http.StatusOK},
+		{"no token", "GET", "/videos/x/files", "", nil, http.StatusUnauthorized},
+		{"unknown token", "GET", "/videos/x/files", "fs_nope", nil, http.StatusUnauthorized},
+		{"revoked token", "GET", "/admin/bandwidth", revoked, nil, http.StatusUnauthorized},
+		{"missing scope", "POST", "/videos", reader, map[string]string{"magnet_link": first.Magnet}, http.StatusForbidden},
+		{"scope granted", "GET", "/videos/x/files", reader, nil, http.StatusNotFound},
+		{"add", "POST", "/videos", adder, map[string]string{"magnet_link": first.Magnet}, http.StatusOK},
+		{"over quota", "POST", "/videos", adder, map[string]string{"magnet_link": second.Magnet}, http.StatusTooManyRequests},
+	}
+	for _, c := range cases {
+		if got := do(c.method, c.path, c.token, c.body); got != c.want {
+			t.Errorf("%s: %s %s got %d, want %d", c.name, c.method, c.path, got, c.want)
+		}
+	}
+}
+
+func TestAdminRoutesNeedTokenWithoutAuth(t *testing.T) {
+	reader, readerHash := auth.NewToken()
+	admin, adminHash := auth.NewToken()
+	db := tokenDB{tokens: map[string]postgresdb.APIToken{
+		readerHash: {Id: "reader", Scopes: []string{"read"}},
+		adminHash:  {Id: "admin", Scopes: []string{"admin"}},
+	}}
+
+	s := &Server{
+		db:             db,
+		t:              tortest.New(),
+		streamResolver: newStreamResolver(db, nil),
+		idempotency:    newIdempotencyCache(),
+		tokens:         newTokenCache(),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	cases := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"open route", "/videos/x/files", "", http.StatusNotFound},
+		{"no token", "/admin/bandwidth", "", http.StatusUnauthorized},
+		{"missing scope", "/admin/bandwidth", reader, http.StatusForbidden},
+		// No torrent client behind the engine
+		{"admin", "/admin/bandwidth", admin, http.StatusNotImplemented},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", srv.URL+c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s: GET %s got %d, want %d", c.name, c.path, resp.StatusCode, c.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+func (s *Server) getBandwidth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.client.Bandwidth())
+}
+
+// setBandwidth changes the global limits, in bytes per second with 0 for no
+// limit, e.g. { "download": 5242880, "upload": 1048576 }.
+func (s *Server) setBandwidth(w http.ResponseWriter, r *http.Request) {
+	var limits tor.Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.client.SetLimits(limits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.shareBandwidth(r.Context())
+	s.getBandwidth(w, r)
+}
+
+// setVideoBandwidth limits a single video on top of the global limits. The
+// body is the same as for setBandwidth.
+func (s *Server) setVideoBandwidth(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	var limits tor.Limits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.client.SetVideoLimits(videoId, limits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.shareBandwidth(r.Context())
+	s.getBandwidth(w, r)
+}
+
+func (s *Server) deleteVideoBandwidth(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	s.client.SetVideoLimits(videoId, tor.Limits{})
+	s.shareBandwidth(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// shareBandwidth stores the limits in Redis, where the worker and later runs
+// of the API pick them up. The limits already apply here when this fails.
+func (s *Server) shareBandwidth(ctx context.Context) {
+	settings, err := json.Marshal(s.client.Bandwidth())
+	if err != nil {
+		log.Println("[Bandwidth] failed to encode limits", err)
+		return
+	}
+	if err := s.rdb.SaveBandwidth(ctx, settings); err != nil {
+		log.Println("[Bandwidth] failed to share limits", err)
+	}
+}
+
+// applyBandwidth applies limits shared through Redis.
+func (s *Server) applyBandwidth(settings []byte) {
+	var bw tor.Bandwidth
+	if err := json.Unmarshal(settings, &bw); err != nil {
+		log.Println("[Bandwidth] invalid shared limits", err)
+		return
+	}
+	if err := s.client.SetBandwidth(bw); err != nil {
+		log.Println("[Bandwidth] invalid shared limits", err)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// minFreeSpace is the free space on the download volume below which the API
+// stops reporting itself ready, as downloads are about to fail.
+const minFreeSpace = 512 << 20
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+)
+
+// check is the outcome of probing one dependency.
+type check struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Detail  any    `json:"detail,omitempty"`
+}
+
+// healthReport is the body of /readyz and /health. Status is down when any
+// check is.
+type healthReport struct {
+	Status string           `json:"status"`
+	Checks map[string]check `json:"checks"`
+}
+
+// livez reports that the process is serving requests. It never looks at the
+// dependencies, so an outage of one of them does not get the API restarted.
+func (s *Server) livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": statusUp})
+}
+
+// readyz probes Postgres, Redis, the torrent client and the download volume,
+// and answers 503 when one of them is down.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	report := s.checkHealth()
+
+	status := http.StatusOK
+	if report.Status != statusUp {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// checkHealth runs the probes concurrently. Dependencies the server was built
+// without, as in tests, are left out.
+func (s *Server) checkHealth() healthReport {
+	probes := make(map[string]func() check)
+	if s.db != nil {
+		probes["postgres"] = s.checkPostgres
+	}
+	if s.rdb != nil {
+		probes["redis"] = s.checkRedis
+	}
+	if s.client != nil {
+		probes["torrent"] = s.checkTorrent
+		probes["disk"] = s.checkDisk
+	}
+
+	report := healthReport{Status: statusUp, Checks: make(map[string]check, len(probes))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, probe := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := probe()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = c
+			if c.Status != statusUp {
+				report.Status = statusDown
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func (s *Server) checkPostgres() check {
+	stats := s.db.Health()
+	if stats["status"] != statusUp {
+		return check{Status: statusDown, Message: stats["error"]}
+	}
+	return check{Status: statusUp, Message: stats["message"], Detail: stats}
+}
+
+func (s *Server) checkRedis() check {
+	stats := s.rdb.Health()
+	if stats["redis_status"] != statusUp {
+		return check{Status: statusDown, Message: stats["redis_message"]}
+	}
+	return check{Status: statusUp, Message: stats["redis_message"], Detail: stats}
+}
+
+// checkTorrent fails when the client has no listen port, as peers then cannot
+// connect to it. An empty DHT routing table is only reported, since it stays
+// empty for a while after starting.
+func (s *Server) checkTorrent() check {
+	h := s.client.Health()
+	switch {
+	case !h.Listening():
+		return check{Status: statusDown, Message: "not listening for peers", Detail: h}
+	case h.DHT && h.DHTNodes == 0:
+		return check{Status: statusUp, Message: "no DHT nodes yet", Detail: h}
+	}
+	return check{Status: statusUp, Detail: h}
+}
+
+func (s *Server) checkDisk() check {
+	space, err := s.client.DiskSpace()
+	switch {
+	case errors.Is(err, tor.ErrDiskSpaceUnsupported):
+		return check{Status: statusUp, Message: err.Error(), Detail: space}
+	case err != nil:
+		return check{Status: statusDown, Message: err.Error(), Detail: space}
+	case space.Free < minFreeSpace:
+		msg := fmt.Sprintf("%d MiB free, need at least %d MiB", space.Free>>20, minFreeSpace>>20)
+		return check{Status: statusDown, Message: msg, Detail: space}
+	}
+	return check{Status: statusUp, Detail: space}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	redisdb "github.com/scythe504/webtorrent/internal/redis-db"
+	"github.com/scythe504/webtorrent/internal/tor/tortest"
+)
+
+// downRedis is a Redis that cannot be reached.
+type downRedis struct{ redisdb.Service }
+
+func (downRedis) Health() map[string]string {
+	return map[string]string{"redis_status": "down", "redis_message": "redis down: connection refused"}
+}
+
+// upDB is a database that answers pings.
+type upDB struct{ emptyDB }
+
+func (upDB) Health() map[string]string {
+	return map[string]string{"status": "up", "message": "It's healthy"}
+}
+
+func TestReadyzReportsDependencyDown(t *testing.T) {
+	s := &Server{db: upDB{}, rdb: downRedis{}, t: tortest.New()}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("livez: expected 200 while redis is down, got %s", resp.Status)
+	}
+
+	for _, path := range []string{"/readyz", "/health"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report healthReport
+		json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected 503, got %s", path, resp.Status)
+		}
+		if report.Status != statusDown {
+			t.Errorf("%s: expected status down, got %q", path, report.Status)
+		}
+		if c := report.Checks["postgres"]; c.Status != statusUp {
+			t.Errorf("%s: expected postgres up, got %+v", path, c)
+		}
+		if c := report.Checks["redis"]; c.Status != statusDown || c.Message == "" {
+			t.Errorf("%s: expected redis down with a message, got %+v", path, c)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is how long a response is replayed for a repeated
+// Idempotency-Key.
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotentBody bounds the request bodies read to fingerprint them, which
+// fits an upload of the largest torrent file.
+const maxIdempotentBody = maxTorrentFileSize + 1<<20
+
+// errKeyReused is returned for a key that comes back with another request.
+var errKeyReused = errors.New("idempotency key was already used for a different request")
+
+// idempotentResponse is a recorded response. done is closed once the first
+// request carrying the key has finished, so retries that arrive while it is
+// still running wait for its outcome instead of adding the torrent again.
+type idempotentResponse struct {
+	// request fingerprints the request that claimed the key.
+	request [sha256.Size]byte
+	done    chan struct{}
+	ok      bool
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// idempotencyCache remembers successful responses by Idempotency-Key. Failed
+// requests are forgotten so the client can retry them with the same key.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]*idempotentResponse)}
+}
+
+// claim returns the response recorded for key. When there is none, it
+// reserves key for request and reports true: the caller must then call
+// finish. It fails with errKeyReused when key was claimed for a different
+// request.
+func (c *idempotencyCache) claim(key string, request [sha256.Size]byte) (*idempotentResponse, bool, error) {
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		for k, res := range c.entries {
+			if res.ok && now.After(res.expires) {
+				delete(c.entries, k)
+			}
+		}
+
+		res, ok := c.entries[key]
+		if !ok {
+			res = &idempotentResponse{request: request, done: make(chan struct{})}
+			c.entries[key] = res
+			c.mu.Unlock()
+			return res, true, nil
+		}
+		c.mu.Unlock()
+
+		if res.request != request {
+			return nil, false, errKeyReused
+		}
+		<-res.done
+		if res.ok {
+			return res, false, nil
+		}
+		// The first attempt failed and released the key, try again
+	}
+}
+
+// finish records the outcome of a claimed key and wakes up waiting retries.
+// Only 2xx responses are kept.
+func (c *idempotencyCache) finish(key string, res *idempotentResponse, rec *recordingWriter) {
+	c.mu.Lock()
+	if rec.status >= 200 && rec.status < 300 {
+		res.ok = true
+		res.status = rec.status
+		res.header = rec.Header().Clone()
+		res.body = rec.body.Bytes()
+		res.expires = time.Now().Add(idempotencyTTL)
+	} else {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+
+	close(res.done)
+}
+
+// replay writes a recorded response.
+func (res *idempotentResponse) replay(w http.ResponseWriter) {
+	for k, v := range res.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
+
+// recordingWriter passes a response through while keeping a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// idempotent honors the Idempotency-Key header: a request that repeats the
+// key of an earlier successful one gets the recorded response back, and a
+// different request reusing the key gets 422 Unprocessable Entity.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		// Keys of different tokens never replay each other's responses
+		if token := requestToken(r); token != nil {
+			key = token.Id + ":" + key
+		}
+
+		request, err := fingerprint(w, r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		res, owner, err := s.idempotency.claim(key, request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if !owner {
+			res.replay(w)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		defer func() { s.idempotency.finish(key, res, rec) }()
+		next(rec, r)
+	}
+}
+
+// fingerprint hashes the method, URL and body of r, and puts the body back for
+// the handler. Multipart boundaries are left out, since clients pick a new one
+// every time they send the same form.
+func fingerprint(w http.ResponseWriter, r *http.Request) ([sha256.Size]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && params["boundary"] != "" {
+		body = bytes.ReplaceAll(body, []byte(params["boundary"]), nil)
+	}
+	h.Write(body)
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
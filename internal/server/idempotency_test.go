@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+)
+
+// addRequest builds a request to add magnet under the Idempotency-Key key,
+// made with token when it is not nil.
+func addRequest(key, magnet string, token *postgresdb.APIToken) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/videos", strings.NewReader(`{"magnet_link": "`+magnet+`"}`))
+	r.Header.Set("Idempotency-Key", key)
+	if token != nil {
+		r = r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token))
+	}
+	return r
+}
+
+// countingHandler answers with the number of times it was called, failing
+// with status the first time when status is set.
+func countingHandler(calls *atomic.Int32, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 && status != 0 {
+			http.Error(w, "failed", status)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "call %d", n)
+	}
+}
+
+func TestIdempotentReplaysSuccess(t *testing.T) {
+	s := &Server{idempotency: newIdempotencyCache()}
+	var calls atomic.Int32
+	h := s.idempotent(countingHandler(&calls, 0))
+
+	first := httptest.NewRecorder()
+	h(first, addRequest("k", "magnet:a", nil))
+	again := httptest.NewRecorder()
+	h(again, addRequest("k", "magnet:a", nil))
+
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want once", calls.Load())
+	}
+	if again.Code != http.StatusCreated || again.Body.String() != "call 1" || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry got %d %q, want the first response replayed", again.Code, again.Body)
+	}
+
+	// Another request under the same key is refused
+	other := httptest.NewRecorder()
+	h(other, addRequest("k", "magnet:b", nil))
+	if other.Code != http.StatusUnprocessableEntity || calls.Load() != 1 {
+		t.Errorf("reused key got %d after %d calls, want 422 without running the handler", other.Code, calls.Load())
+	}
+}
+
+func TestIdempotentRetryWaitsForFirstAttempt(t *testing.T) {
+	s := &Server{idempotency: newIdempotencyCache()}
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		countingHandler(&calls, 0)(w, r)
+	})
+
+	recs := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h(recs[0], addRequest("k", "magnet:a", nil))
+	}()
+	<-entered
+
+	retried := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h(recs[1], addRequest("k", "magnet:a", nil))
+		close(retried)
+	}()
+	select {
+	case <-retried:
+		t.Fatal("retry finished while the first attempt was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want once", calls.Load())
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusCreated || rec.Body.String() != "call 1" {
+			t.Errorf("request %d got %d %q, want the first response", i, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestIdempotentFailureFreesKey(t *testing.T) {
+	s := &Server{idempotency: newIdempotencyCache()}
+	var calls atomic.Int32
+	h := s.idempotent(countingHandler(&calls, http.StatusBadGateway))
+
+	first := httptest.NewRecorder()
+	h(first, addRequest("k", "magnet:a", nil))
+	retry := httptest.NewRecorder()
+	h(retry, addRequest("k", "magnet:a", nil))
+
+	if first.Code != http.StatusBadGateway {
+		t.Errorf("first attempt got %d", first.Code)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != "call 2" || retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry got %d %q, want the handler to run again", retry.Code, retry.Body)
+	}
+}
+
+func TestIdempotencyKeysPerToken(t *testing.T) {
+	s := &Server{idempotency: newIdempotencyCache()}
+	var calls atomic.Int32
+	h := s.idempotent(countingHandler(&calls, 0))
+
+	alice, bob := &postgresdb.APIToken{Id: "alice"}, &postgresdb.APIToken{Id: "bob"}
+	for _, token := range []*postgresdb.APIToken{alice, bob, nil} {
+		rec := httptest.NewRecorder()
+		h(rec, addRequest("k", "magnet:a", token))
+		if rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("token %v got another token's response", token)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Errorf("handler ran %d times, want once per token", calls.Load())
+	}
+
+	// Another token's key does not make the request a reuse either
+	rec := httptest.NewRecorder()
+	h(rec, addRequest("k", "magnet:b", &postgresdb.APIToken{Id: "carol"}))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("key of another token got %d", rec.Code)
+	}
+}
+
+func TestFingerprintIgnoresMultipartBoundary(t *testing.T) {
+	form := func(boundary string) *http.Request {
+		body := "--" + boundary + "\r\nContent-Disposition: form-data; name=\"torrent\"; filename=\"a.torrent\"\r\n\r\nd4:infoe\r\n--" + boundary + "--\r\n"
+		r := httptest.NewRequest(http.MethodPost, "/videos", strings.NewReader(body))
+		r.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		return r
+	}
+
+	a, err := fingerprint(httptest.NewRecorder(), form("aaaa1111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := fingerprint(httptest.NewRecorder(), form("bbbb2222"))
+	if a != b {
+		t.Error("the same form with another boundary has another fingerprint")
+	}
+}
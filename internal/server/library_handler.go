@@ -0,0 +1,112 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// shareVideo builds a torrent from a saved video and seeds the file in place.
+// The optional body sets the piece size in bytes and extra trackers, e.g.
+// { "piece_length": 262144, "trackers": ["udp://tracker.example:1337"] }.
+func (s *Server) shareVideo(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	var opts struct {
+		PieceLength int64    `json:"piece_length"`
+		Trackers    []string `json:"trackers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+		http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	video, err := s.db.GetVideo(videoId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "video not found", http.StatusNotFound)
+			return
+		}
+		log.Println("[Share] failed to get video", err)
+		http.Error(w, "failed to get video", http.StatusInternalServerError)
+		return
+	}
+	if video.Deleted || video.Status != postgresdb.DOWNLOADED || video.FilePath == "" {
+		http.Error(w, "video is not saved to the library yet", http.StatusConflict)
+		return
+	}
+	if _, err := os.Stat(video.FilePath); err != nil {
+		log.Println("[Share] saved file is missing", err)
+		http.Error(w, "saved video file is missing", http.StatusNotFound)
+		return
+	}
+
+	// Hashing a large file outlasts the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Println("[Share] failed to lift write deadline", err)
+	}
+
+	share, err := s.client.ShareFile(videoId, video.FilePath, tor.ShareOptions{
+		PieceLength: opts.PieceLength,
+		Trackers:    opts.Trackers,
+	})
+	if err != nil {
+		log.Println("[Share] failed to share video", err)
+		if errors.Is(err, tor.ErrInvalidPieceLength) || errors.Is(err, tor.ErrInvalidTracker) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "failed to share video", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		VideoId     string `json:"video_id"`
+		InfoHash    string `json:"info_hash"`
+		Name        string `json:"name"`
+		PieceLength int64  `json:"piece_length"`
+		MagnetLink  string `json:"magnet_link"`
+		TorrentURL  string `json:"torrent_url"`
+	}{
+		VideoId:     videoId,
+		InfoHash:    share.MetaInfo.HashInfoBytes().HexString(),
+		Name:        filepath.Base(share.Path),
+		PieceLength: share.PieceLength(),
+		MagnetLink:  share.Magnet(),
+		TorrentURL:  fmt.Sprintf("/library/%s/share.torrent", videoId),
+	})
+}
+
+// getShareTorrent serves the .torrent file of a shared video.
+func (s *Server) getShareTorrent(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	share, ok := s.client.Shared(videoId)
+	if !ok {
+		http.Error(w, "video is not shared", http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimSuffix(filepath.Base(share.Path), filepath.Ext(share.Path)) + ".torrent"
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.WriteHeader(http.StatusOK)
+	if err := share.MetaInfo.Write(w); err != nil {
+		log.Println("[Share] failed to write torrent", err)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// createStreamLink issues signed URLs to the stream and playlist of a video,
+// for players that cannot send an Authorization header. The optional body sets
+// how long they last and the one client IP they work from, e.g.
+// { "ttl": "2h", "client_ip": "192.168.1.20" }.
+func (s *Server) createStreamLink(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	var opts struct {
+		TTL      string `json:"ttl"`
+		ClientIP string `json:"client_ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+		http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultLinkTTL
+	if opts.TTL != "" {
+		d, err := time.ParseDuration(opts.TTL)
+		if err != nil || d <= 0 || d > maxLinkTTL {
+			http.Error(w, fmt.Sprintf("ttl must be a duration such as 2h, at most %s", maxLinkTTL), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+
+	link := streamLink{videoId: videoId, expires: time.Now().Add(ttl).Truncate(time.Second)}
+	if opts.ClientIP != "" {
+		ip, ok := normalizeIP(opts.ClientIP)
+		if !ok {
+			http.Error(w, "client_ip must be an IP address", http.StatusBadRequest)
+			return
+		}
+		link.ip = ip
+	}
+
+	if !s.t.Has(videoId) {
+		if _, ok := s.savedMetadata(videoId, tor.MainFile); !ok {
+			http.Error(w, "video not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	q, err := s.signer.sign(r.Context(), link)
+	if err != nil {
+		log.Println("[Links] failed to sign link", err)
+		http.Error(w, "failed to sign link", http.StatusServiceUnavailable)
+		return
+	}
+
+	base := requestBaseURL(r) + "/videos/" + url.PathEscape(videoId)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		VideoId     string    `json:"video_id"`
+		StreamURL   string    `json:"stream_url"`
+		PlaylistURL string    `json:"playlist_url"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{
+		VideoId:     videoId,
+		StreamURL:   base + "/stream?" + q.Encode(),
+		PlaylistURL: base + "/playlist.m3u?" + q.Encode(),
+		ExpiresAt:   link.expires.UTC(),
+	})
+}
+
+// getPlaylist answers an M3U playlist of the videos of a torrent, in torrent
+// order, or of the saved file once the torrent is gone. Entries carry what
+// authorizes them, so a player can open them without a token.
+func (s *Server) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+	base := requestBaseURL(r) + "/videos/" + url.PathEscape(videoId)
+
+	type entry struct{ title, url string }
+	var entries []entry
+	if files, err := s.t.GetFiles(videoId); err == nil {
+		for _, f := range files {
+			if f.IsVideo && f.Selected {
+				entries = append(entries, entry{f.Name, fmt.Sprintf("%s/files/%d/stream", base, f.Index)})
+			}
+		}
+		if len(entries) == 0 {
+			http.Error(w, "torrent holds no video files", http.StatusUnprocessableEntity)
+			return
+		}
+	} else if meta, ok := s.savedMetadata(videoId, tor.MainFile); ok {
+		entries = []entry{{meta.Name, base + "/stream"}}
+	} else {
+		s.writeTorrentMissing(w, videoId)
+		return
+	}
+
+	query, err := s.linkQuery(r, videoId)
+	if err != nil {
+		log.Println("[Links] failed to sign playlist", err)
+		http.Error(w, "failed to sign playlist", http.StatusServiceUnavailable)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		title := strings.NewReplacer("\r", " ", "\n", " ").Replace(e.title)
+		fmt.Fprintf(&b, "#EXTINF:-1,%s\n%s%s\n", title, e.url, query)
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", videoId+".m3u"))
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, b.String())
+}
+
+// linkQuery returns the query string authorizing the entries of a playlist:
+// the signature the playlist was opened with, or a fresh one when it was
+// opened with a token. Nothing is needed when authentication is off.
+func (s *Server) linkQuery(r *http.Request, videoId string) (string, error) {
+	if !s.authRequired {
+		return "", nil
+	}
+
+	q := r.URL.Query()
+	if q.Has("sig") {
+		signed := url.Values{}
+		for _, k := range []string{"expires", "ip", "kid", "sig"} {
+			if v := q.Get(k); v != "" {
+				signed.Set(k, v)
+			}
+		}
+		return "?" + signed.Encode(), nil
+	}
+
+	signed, err := s.signer.sign(r.Context(), streamLink{
+		videoId: videoId,
+		expires: time.Now().Add(defaultLinkTTL).Truncate(time.Second),
+	})
+	if err != nil {
+		return "", err
+	}
+	return "?" + signed.Encode(), nil
+}
+
+// rotateStreamKey replaces the key links are signed with, revoking every link
+// handed out so far.
+func (s *Server) rotateStreamKey(w http.ResponseWriter, r *http.Request) {
+	key, err := s.signer.rotate(r.Context())
+	if err != nil {
+		log.Println("[Links] failed to rotate stream key", err)
+		http.Error(w, "failed to rotate stream key", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"key_id": key.Id})
+}
+
+// requestBaseURL returns the URL r was sent to, without its path. Links are
+// built from it rather than a configured URL, as the player that opens them
+// reaches the server the same way the client that asked for them did.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
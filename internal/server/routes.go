@@ -6,29 +6,78 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal/auth"
 )
+
 func (s *Server) RegisterRoutes() http.Handler {
 	r := mux.NewRouter()
 
 	r.Use(s.corsMiddleware)
 
 	r.HandleFunc("/", s.HelloWorldHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/livez", s.livez).Methods("GET", "OPTIONS")
+	r.HandleFunc("/readyz", s.readyz).Methods("GET", "OPTIONS")
+	r.HandleFunc("/health", s.readyz).Methods("GET", "OPTIONS")
 
 	video := r.PathPrefix("/videos").Subrouter()
-	video.HandleFunc("", s.createVideo).Methods("POST", "OPTIONS")
-	video.HandleFunc("", s.listVideos).Methods("GET", "OPTIONS")
-	video.HandleFunc("/{videoId}/metadata", s.getVideoMetadata).Methods("GET", "OPTIONS")
-	video.HandleFunc("/{videoId}/stream", s.streamVideo).Methods("GET", "HEAD", "OPTIONS")
-	video.HandleFunc("/{videoId}/save", s.saveVideo).Methods("POST", "OPTIONS")
+	video.HandleFunc("", s.authorize(auth.ScopeAdd, s.idempotent(s.createVideo))).Methods("POST", "OPTIONS")
+	video.HandleFunc("", s.authorize(auth.ScopeRead, s.listVideos)).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/metadata", s.authorize(auth.ScopeRead, s.getVideoMetadata)).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/stream", s.authorizeLink(auth.ScopeStream, s.streamVideo)).Methods("GET", "HEAD", "OPTIONS")
+	video.HandleFunc("/{videoId}/files", s.authorize(auth.ScopeRead, s.listVideoFiles)).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/files/{index:[0-9]+}/metadata", s.authorize(auth.ScopeRead, s.getVideoMetadata)).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/files/{index:[0-9]+}/stream", s.authorizeLink(auth.ScopeStream, s.streamVideo)).Methods("GET", "HEAD", "OPTIONS")
+	video.HandleFunc("/{videoId}/stats", s.authorize(auth.ScopeRead, s.withClient(s.getVideoStats))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/stats/events", s.authorize(auth.ScopeRead, s.withClient(s.streamVideoStats))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/files/{index:[0-9]+}/stats", s.authorize(auth.ScopeRead, s.withClient(s.getVideoStats))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/files/{index:[0-9]+}/stats/events", s.authorize(auth.ScopeRead, s.withClient(s.streamVideoStats))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/subtitles", s.authorize(auth.ScopeRead, s.withClient(s.listSubtitles))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/files/{index:[0-9]+}/subtitles", s.authorize(auth.ScopeRead, s.withClient(s.listSubtitles))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/subtitles/{trackId}.vtt", s.authorizeLink(auth.ScopeStream, s.withClient(s.getSubtitle))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/trackers", s.authorize(auth.ScopeRead, s.withClient(s.getTrackers))).Methods("GET", "OPTIONS")
+	video.HandleFunc("/{videoId}/trackers", s.authorize(auth.ScopeAdd, s.withClient(s.setTrackers))).Methods("PUT", "OPTIONS")
+	video.HandleFunc("/{videoId}/save", s.authorize(auth.ScopeAdd, s.saveVideo)).Methods("POST", "OPTIONS")
+	video.HandleFunc("/{videoId}/links", s.authorize(auth.ScopeStream, s.createStreamLink)).Methods("POST", "OPTIONS")
+	video.HandleFunc("/{videoId}/playlist.m3u", s.authorizeLink(auth.ScopeStream, s.getPlaylist)).Methods("GET", "OPTIONS")
+
+	library := r.PathPrefix("/library").Subrouter()
+	library.HandleFunc("/{videoId}/share", s.authorize(auth.ScopeAdd, s.withClient(s.shareVideo))).Methods("POST", "OPTIONS")
+	library.HandleFunc("/{videoId}/share.torrent", s.authorize(auth.ScopeRead, s.withClient(s.getShareTorrent))).Methods("GET", "OPTIONS")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/metainfo", s.authorize(auth.ScopeAdmin, s.withClient(s.listCachedMetainfo))).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/metainfo", s.authorize(auth.ScopeAdmin, s.withClient(s.pruneCachedMetainfo))).Methods("DELETE", "OPTIONS")
+	admin.HandleFunc("/metainfo/{infoHash:[0-9a-fA-F]{40}}", s.authorize(auth.ScopeAdmin, s.withClient(s.deleteCachedMetainfo))).Methods("DELETE", "OPTIONS")
+	admin.HandleFunc("/blocklist", s.authorize(auth.ScopeAdmin, s.withClient(s.getBlocklist))).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/blocklist/reload", s.authorize(auth.ScopeAdmin, s.withClient(s.reloadBlocklist))).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/bandwidth", s.authorize(auth.ScopeAdmin, s.withClient(s.getBandwidth))).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/bandwidth", s.authorize(auth.ScopeAdmin, s.withClient(s.setBandwidth))).Methods("PUT", "OPTIONS")
+	admin.HandleFunc("/bandwidth/videos/{videoId}", s.authorize(auth.ScopeAdmin, s.withClient(s.setVideoBandwidth))).Methods("PUT", "OPTIONS")
+	admin.HandleFunc("/bandwidth/videos/{videoId}", s.authorize(auth.ScopeAdmin, s.withClient(s.deleteVideoBandwidth))).Methods("DELETE", "OPTIONS")
+	admin.HandleFunc("/stream-keys/rotate", s.authorize(auth.ScopeAdmin, s.rotateStreamKey)).Methods("POST", "OPTIONS")
 
 	return r
 }
+
+// withClient answers 501 Not Implemented instead of calling next when the
+// server runs on an engine other than the anacrolix client, which is the only
+// one next works with.
+func (s *Server) withClient(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.client == nil {
+			http.Error(w, "not supported by this torrent engine", http.StatusNotImplemented)
+			return
+		}
+		next(w, r)
+	}
+}
+
 // CORS middleware
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Wildcard allows all origins
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Idempotency-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "false") // Credentials not allowed with wildcard origins
 
 		// Handle preflight OPTIONS
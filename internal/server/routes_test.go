@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/scythe504/webtorrent/internal/tor/tortest"
 )
 
 func TestHandler(t *testing.T) {
@@ -29,3 +31,29 @@ func TestHandler(t *testing.T) {
 		t.Errorf("expected response body to be %v; got %v", expected, string(body))
 	}
 }
+
+func TestClientRoutesWithoutClient(t *testing.T) {
+	db, token := adminDB()
+	s := &Server{
+		db:             db,
+		t:              tortest.New(),
+		streamResolver: newStreamResolver(db, nil),
+		idempotency:    newIdempotencyCache(),
+		tokens:         newTokenCache(),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	for _, path := range []string{"/videos/any/stats", "/videos/any/subtitles", "/videos/any/trackers", "/library/any/share.torrent", "/admin/bandwidth", "/admin/blocklist", "/admin/metainfo"} {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotImplemented {
+			t.Errorf("%s: got %d, want 501", path, resp.StatusCode)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
-	_ "context"
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -12,32 +14,89 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
 	redisdb "github.com/scythe504/webtorrent/internal/redis-db"
+	"github.com/scythe504/webtorrent/internal/storage"
 	"github.com/scythe504/webtorrent/internal/tor"
 )
 
+// StreamResolver finds what to stream for a video: its active torrent, or
+// else the file the worker saved to the library.
 type StreamResolver struct {
+	// cache holds a *savedVideo per video saved to the library, dropped when
+	// the worker announces a change to its status.
 	cache sync.Map
+	db    postgresdb.Service
+	st    storage.Service
+}
+
+func newStreamResolver(db postgresdb.Service, st storage.Service) *StreamResolver {
+	return &StreamResolver{db: db, st: st}
 }
 
 type Server struct {
-	port           int
-	rdb            redisdb.Service
-	db             postgresdb.Service
-	t              tor.Torrent
+	port int
+	rdb  redisdb.Service
+	db   postgresdb.Service
+	st   storage.Service
+	t    tor.Engine
+	// client is the anacrolix engine behind t, for what only it offers:
+	// stats, bandwidth, trackers, sharing, the blocklist, the metainfo cache
+	// and subtitles. It is nil when t is another engine, as in tests, and
+	// the routes that need it answer 501, see withClient.
+	client         *tor.Torrent
 	streamResolver *StreamResolver
+	idempotency    *idempotencyCache
+	// authRequired makes every route but the probes ask for an API token,
+	// see authorize.
+	authRequired bool
+	tokens       *tokenCache
+	quotas       *torrentQuotas
+	// signer signs the links external players stream with, see
+	// authorizeLink.
+	signer *streamSigner
 }
 
 func NewServer() *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	// ctx := context.Background()
+	ctx := context.Background()
+
+	// Keep handing out the same video ids across restarts
+	torrentConfig := tor.DefaultConfig(42069)
+	torrentConfig.SessionFile = filepath.Join(torrentConfig.DataDir, ".sessions.json")
+	torrentConfig.ShareFile = filepath.Join(torrentConfig.DataDir, ".shares.json")
+
+	// Off by default, so the bundled web app keeps working until it sends
+	// tokens. The /admin routes take an admin token either way.
+	authRequired, _ := strconv.ParseBool(os.Getenv("AUTH_REQUIRED"))
+	if !authRequired {
+		log.Println("[Auth] WARNING: AUTH_REQUIRED is not set, so every route but /admin is open to anyone who can reach this server. Create tokens with `fluxstream token create` and set AUTH_REQUIRED=true")
+	}
+
+	client := tor.New(torrentConfig)
+	db := postgresdb.New()
+	st := storage.New()
+	rdb := redisdb.New(ctx)
 	NewServer := &Server{
-		port: port,
-		// rdb:  redisdb.New(ctx),
-		// db:   postgresdb.New(),
-		t:              tor.New(42069),
-		streamResolver: &StreamResolver{cache: sync.Map{}},
+		port:           port,
+		rdb:            rdb,
+		db:             db,
+		st:             st,
+		t:              client,
+		client:         client,
+		streamResolver: newStreamResolver(db, st),
+		idempotency:    newIdempotencyCache(),
+		authRequired:   authRequired,
+		tokens:         newTokenCache(),
+		quotas:         newTorrentQuotas(),
+		signer:         newStreamSigner(rdb),
 	}
 
+	// Pick up the bandwidth limits last set through the API
+	go NewServer.rdb.WatchBandwidth(ctx, NewServer.applyBandwidth)
+	// Stop serving saved files the worker replaces or fails to save
+	go NewServer.rdb.WatchStatus(ctx, func(change redisdb.StatusChange) {
+		NewServer.streamResolver.Invalidate(change.VideoId)
+	})
+
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
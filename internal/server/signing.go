@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/auth"
+	redisdb "github.com/scythe504/webtorrent/internal/redis-db"
+)
+
+const (
+	// defaultLinkTTL is how long a signed link lasts when no ttl is asked for,
+	// about a movie night.
+	defaultLinkTTL = 6 * time.Hour
+	// maxLinkTTL bounds how long a signed link may last.
+	maxLinkTTL = 7 * 24 * time.Hour
+)
+
+var (
+	errLinkInvalid     = errors.New("invalid link signature")
+	errLinkExpired     = errors.New("link has expired")
+	errLinkRevoked     = errors.New("link was revoked")
+	errLinkWrongClient = errors.New("link is bound to another client")
+)
+
+// signingKey signs stream links. Its id goes along with every signature, so
+// links signed before a rotation are told apart from forged ones.
+type signingKey struct {
+	Id     string `json:"id"`
+	Secret []byte `json:"secret"`
+}
+
+func newSigningKey() signingKey {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return signingKey{Id: internal.RandomId(), Secret: secret}
+}
+
+// streamLink is what a signature grants: streaming one video until expires,
+// from ip only when it is set.
+type streamLink struct {
+	videoId string
+	expires time.Time
+	ip      string
+}
+
+func (l streamLink) mac(key signingKey) []byte {
+	h := hmac.New(sha256.New, key.Secret)
+	fmt.Fprintf(h, "%s\n%d\n%s", l.videoId, l.expires.Unix(), l.ip)
+	return h.Sum(nil)
+}
+
+// streamSigner signs and checks stream links with a key kept in Redis, so
+// links survive restarts until the key is rotated.
+type streamSigner struct {
+	rdb redisdb.Service
+
+	mu  sync.Mutex
+	key *signingKey // Loaded on first use
+}
+
+func newStreamSigner(rdb redisdb.Service) *streamSigner {
+	return &streamSigner{rdb: rdb}
+}
+
+// current returns the signing key, creating it the first time links are
+// signed.
+func (sg *streamSigner) current(ctx context.Context) (signingKey, error) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	if sg.key != nil {
+		return *sg.key, nil
+	}
+
+	candidate, err := json.Marshal(newSigningKey())
+	if err != nil {
+		return signingKey{}, err
+	}
+	stored, err := sg.rdb.EnsureStreamKey(ctx, candidate)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("failed to load stream key: %w", err)
+	}
+
+	var key signingKey
+	if err := json.Unmarshal(stored, &key); err != nil || key.Id == "" || len(key.Secret) == 0 {
+		return signingKey{}, fmt.Errorf("stored stream key is invalid, rotate it")
+	}
+	sg.key = &key
+	return key, nil
+}
+
+// rotate replaces the signing key, revoking every link signed so far.
+func (sg *streamSigner) rotate(ctx context.Context) (signingKey, error) {
+	key := newSigningKey()
+	payload, err := json.Marshal(key)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	if err := sg.rdb.SaveStreamKey(ctx, payload); err != nil {
+		return signingKey{}, fmt.Errorf("failed to save stream key: %w", err)
+	}
+	sg.key = &key
+	return key, nil
+}
+
+// sign returns the query parameters that grant link.
+func (sg *streamSigner) sign(ctx context.Context, link streamLink) (url.Values, error) {
+	key, err := sg.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(link.expires.Unix(), 10))
+	if link.ip != "" {
+		q.Set("ip", link.ip)
+	}
+	q.Set("kid", key.Id)
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(link.mac(key)))
+	return q, nil
+}
+
+// verify checks that q holds a signature granting videoId to a client at
+// remoteIP.
+func (sg *streamSigner) verify(ctx context.Context, videoId string, q url.Values, remoteIP string) error {
+	key, err := sg.current(ctx)
+	if err != nil {
+		return err
+	}
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return errLinkInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil {
+		return errLinkInvalid
+	}
+	if q.Get("kid") != key.Id {
+		return errLinkRevoked
+	}
+
+	link := streamLink{videoId: videoId, expires: time.Unix(expires, 0), ip: q.Get("ip")}
+	if !hmac.Equal(sig, link.mac(key)) {
+		return errLinkInvalid
+	}
+	if time.Now().After(link.expires) {
+		return errLinkExpired
+	}
+	if link.ip != "" && link.ip != remoteIP {
+		return errLinkWrongClient
+	}
+	return nil
+}
+
+// normalizeIP returns ip in the form clientIP reports addresses in, and
+// false if it is not an IP address.
+func normalizeIP(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	return addr.Unmap().String(), true
+}
+
+// clientIP returns the address r came from. Proxy headers are ignored, as
+// anyone could set them to match a link's IP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip, ok := normalizeIP(host); ok {
+		return ip
+	}
+	return host
+}
+
+// authorizeLink is authorize for the routes external players open: a link
+// signed for the video stands in for a token.
+func (s *Server) authorizeLink(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	withToken := s.authorize(scope, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authRequired || !r.URL.Query().Has("sig") {
+			withToken(w, r)
+			return
+		}
+
+		err := s.signer.verify(r.Context(), mux.Vars(r)["videoId"], r.URL.Query(), clientIP(r))
+		switch {
+		case errors.Is(err, errLinkInvalid), errors.Is(err, errLinkExpired),
+			errors.Is(err, errLinkRevoked), errors.Is(err, errLinkWrongClient):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			log.Println("[Links] failed to check link", err)
+			http.Error(w, "failed to check link", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
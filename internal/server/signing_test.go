@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scythe504/webtorrent/internal/auth"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+	redisdb "github.com/scythe504/webtorrent/internal/redis-db"
+	"github.com/scythe504/webtorrent/internal/tor/tortest"
+)
+
+// keyRedis keeps the stream signing key in memory.
+type keyRedis struct {
+	redisdb.Service
+	key []byte
+}
+
+func (kr *keyRedis) EnsureStreamKey(_ context.Context, candidate []byte) ([]byte, error) {
+	if kr.key == nil {
+		kr.key = candidate
+	}
+	return kr.key, nil
+}
+
+func (kr *keyRedis) SaveStreamKey(_ context.Context, key []byte) error {
+	kr.key = key
+	return nil
+}
+
+func TestSignedStreamLinks(t *testing.T) {
+	token, hash := auth.NewToken()
+	db := tokenDB{tokens: map[string]postgresdb.APIToken{
+		hash: {Id: "phone", Scopes: []string{"read", "stream", "add"}},
+	}}
+
+	engine := tortest.New()
+	video := bytes.Repeat([]byte("frame"), 2_000)
+	added := engine.Add("Show",
+		tortest.File{Path: "Show/e01.mkv", Data: video},
+		tortest.File{Path: "Show/e02.mkv", Data: video},
+		tortest.File{Path: "Show/notes.txt", Data: []byte("notes")})
+
+	s := &Server{
+		db:             db,
+		t:              engine,
+		streamResolver: newStreamResolver(db, nil),
+		idempotency:    newIdempotencyCache(),
+		authRequired:   true,
+		tokens:         newTokenCache(),
+		quotas:         newTorrentQuotas(),
+		signer:         newStreamSigner(&keyRedis{}),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	withToken := func(method, path string, body any) *http.Response {
+		t.Helper()
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req, _ := http.NewRequest(method, srv.URL+path, &buf)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	get := func(rawURL string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	resp := withToken("POST", "/videos", map[string]string{"magnet_link": added.Magnet})
+	var created struct {
+		VideoId string `json:"video_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	resp = withToken("POST", "/videos/"+created.VideoId+"/links", map[string]string{"ttl": "1h", "client_ip": "127.0.0.1"})
+	var link struct {
+		StreamURL   string `json:"stream_url"`
+		PlaylistURL string `json:"playlist_url"`
+	}
+	json.NewDecoder(resp.Body).Decode(&link)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || link.StreamURL == "" {
+		t.Fatalf("links: got %d %+v", resp.StatusCode, link)
+	}
+
+	if status, body := get(link.StreamURL); status != http.StatusOK || body != string(video) {
+		t.Errorf("signed stream: got %d", status)
+	}
+
+	status, playlist := get(link.PlaylistURL)
+	if status != http.StatusOK || strings.Count(playlist, "#EXTINF") != 2 || !strings.Contains(playlist, "sig=") {
+		t.Errorf("playlist: got %d %q", status, playlist)
+	}
+	entry := strings.Split(strings.TrimSpace(playlist), "\n")[2]
+	if status, _ := get(entry); status != http.StatusOK {
+		t.Errorf("playlist entry %s: got %d", entry, status)
+	}
+
+	// The signature is bound to one video
+	other := strings.Replace(link.StreamURL, "/videos/"+created.VideoId+"/", "/videos/other/", 1)
+	if status, _ := get(other); status != http.StatusForbidden {
+		t.Errorf("link used for another video: got %d", status)
+	}
+
+	// and to its client IP
+	u, _ := url.Parse(link.StreamURL)
+	q := u.Query()
+	expires := time.Now().Add(time.Hour)
+	moved, _ := s.signer.sign(context.Background(), streamLink{videoId: created.VideoId, expires: expires, ip: "10.0.0.9"})
+	u.RawQuery = moved.Encode()
+	if status, body := get(u.String()); status != http.StatusForbidden || !strings.Contains(body, "another client") {
+		t.Errorf("link bound to another IP: got %d %q", status, body)
+	}
+
+	expired, _ := s.signer.sign(context.Background(), streamLink{videoId: created.VideoId, expires: time.Now().Add(-time.Minute)})
+	u.RawQuery = expired.Encode()
+	if status, body := get(u.String()); status != http.StatusForbidden || !strings.Contains(body, "expired") {
+		t.Errorf("expired link: got %d %q", status, body)
+	}
+
+	// Rotating the key revokes every link
+	u.RawQuery = q.Encode()
+	resp = withToken("POST", "/admin/stream-keys/rotate", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("rotate without admin scope: got %d", resp.StatusCode)
+	}
+	if _, err := s.signer.rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := get(u.String()); status != http.StatusForbidden || !strings.Contains(body, "revoked") {
+		t.Errorf("link after rotation: got %d %q", status, body)
+	}
+}
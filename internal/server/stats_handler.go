@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statsInterval is how often the stats event stream pushes an update.
+const statsInterval = time.Second
+
+func (s *Server) getVideoStats(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	stats, err := s.client.GetStats(videoId, fileIndex(r))
+	if err != nil {
+		log.Println("[VideoStats] failed to get stats", err)
+		s.writeTorrentMissing(w, videoId)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// streamVideoStats pushes the video stats as server-sent events every
+// statsInterval until the client goes away or the torrent is dropped.
+func (s *Server) streamVideoStats(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+	index := fileIndex(r)
+
+	// Fail with a normal HTTP error if there is nothing to report
+	stats, err := s.client.GetStats(videoId, index)
+	if err != nil {
+		log.Println("[VideoStatsEvents] failed to get stats", err)
+		s.writeTorrentMissing(w, videoId)
+		return
+	}
+
+	// The event stream outlives the server's WriteTimeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Println("[VideoStatsEvents] failed to clear write deadline", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(stats)
+		if err != nil {
+			log.Println("[VideoStatsEvents] failed to encode stats", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats, err = s.client.GetStats(videoId, index)
+		if err != nil {
+			fmt.Fprintf(w, "event: gone\ndata: {\"video_id\": %q}\n\n", videoId)
+			rc.Flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+func TestStreamVideoStatsEvents(t *testing.T) {
+	dataDir := t.TempDir()
+	path := filepath.Join(dataDir, "video.mp4")
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 16 * 1024}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := tor.New(tor.Config{DataDir: dataDir})
+	if _, err := client.AddMetainfo("vid", &metainfo.MetaInfo{InfoBytes: infoBytes}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		db:             emptyDB{},
+		t:              client,
+		client:         client,
+		streamResolver: newStreamResolver(emptyDB{}, nil),
+		idempotency:    newIdempotencyCache(),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/videos/vid/stats/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != "event: stats" {
+		t.Fatalf("got first line %q, want a stats event: %v", lines.Text(), lines.Err())
+	}
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "data: ") {
+		t.Fatalf("got %q, want the event data: %v", lines.Text(), lines.Err())
+	}
+
+	var stats tor.Stats
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines.Text(), "data: ")), &stats); err != nil {
+		t.Fatalf("decode event data: %v", err)
+	}
+	if stats.Length != int64(len(data)) || stats.Pieces != 4 {
+		t.Errorf("got stats of %d bytes in %d pieces, want %d bytes in 4", stats.Length, stats.Pieces, len(data))
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// listSubtitles lists the sidecar and embedded subtitle tracks of a video.
+func (s *Server) listSubtitles(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	tracks, err := s.client.GetSubtitles(r.Context(), videoId, fileIndex(r))
+	if err != nil {
+		log.Println("[Subtitle] failed to list subtitle tracks", err)
+		switch {
+		case errors.Is(err, tor.ErrNotFound), errors.Is(err, tor.ErrEvicted), errors.Is(err, tor.ErrResolving):
+			s.writeTorrentMissing(w, videoId)
+		default:
+			http.Error(w, "video file not found", http.StatusNotFound)
+		}
+		return
+	}
+	if tracks == nil {
+		tracks = []tor.SubtitleTrack{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tracks)
+}
+
+// getSubtitle serves a subtitle track as WebVTT for use in a <track> element.
+func (s *Server) getSubtitle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	videoId, trackId := vars["videoId"], vars["trackId"]
+
+	vtt, err := s.client.Subtitle(r.Context(), videoId, trackId)
+	if err != nil {
+		log.Println("[Subtitle] failed to get subtitle track", err)
+		switch {
+		case errors.Is(err, tor.ErrSubtitleNotFound):
+			http.Error(w, "subtitle track not found", http.StatusNotFound)
+		case errors.Is(err, tor.ErrNotFound), errors.Is(err, tor.ErrEvicted), errors.Is(err, tor.ErrResolving):
+			s.writeTorrentMissing(w, videoId)
+		default:
+			http.Error(w, "failed to read subtitle track", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(vtt)
+}
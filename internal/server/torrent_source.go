@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// maxTorrentFileSize bounds uploaded and fetched .torrent files.
+	maxTorrentFileSize = 10 << 20
+	// torrentFetchTimeout bounds how long fetching a torrent_url may take.
+	torrentFetchTimeout = 15 * time.Second
+)
+
+var torrentFetchClient = &http.Client{Timeout: torrentFetchTimeout}
+
+// isMultipart reports whether r carries a multipart/form-data body.
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// torrentFromUpload loads the metainfo uploaded in the "torrent" form field.
+func torrentFromUpload(r *http.Request) (*metainfo.MetaInfo, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxTorrentFileSize+1<<20)
+	if err := r.ParseMultipartForm(maxTorrentFileSize); err != nil {
+		return nil, fmt.Errorf("failed to parse upload: %w", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("torrent")
+	if err != nil {
+		return nil, fmt.Errorf("missing torrent file field: %w", err)
+	}
+	defer file.Close()
+
+	return loadMetainfo(file)
+}
+
+// fetchTorrent downloads and parses the .torrent file at rawURL.
+func fetchTorrent(ctx context.Context, rawURL string) (*metainfo.MetaInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("torrent_url must be an http or https URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/x-bittorrent")
+
+	resp, err := torrentFetchClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch torrent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch torrent: %s", resp.Status)
+	}
+
+	return loadMetainfo(resp.Body)
+}
+
+// loadMetainfo parses at most maxTorrentFileSize bytes of a .torrent file.
+func loadMetainfo(r io.Reader) (*metainfo.MetaInfo, error) {
+	mi, err := metainfo.Load(io.LimitReader(r, maxTorrentFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	if _, err := mi.UnmarshalInfo(); err != nil {
+		return nil, fmt.Errorf("invalid torrent info: %w", err)
+	}
+	return mi, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// getTrackers lists the trackers of an active video with their announce
+// status.
+func (s *Server) getTrackers(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	trackers, err := s.client.Trackers(videoId)
+	if err != nil {
+		log.Println("[Trackers] failed to list trackers", err)
+		s.writeTorrentMissing(w, videoId)
+		return
+	}
+	writeTrackers(w, trackers)
+}
+
+// setTrackers replaces the trackers of an active video. The body is either a
+// list of announce URLs or { "trackers": [...] }.
+func (s *Server) setTrackers(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+		return
+	}
+	var list []string
+	if err := json.Unmarshal(body, &list); err != nil {
+		var wrapped struct {
+			Trackers []string `json:"trackers"`
+		}
+		if err := json.Unmarshal(body, &wrapped); err != nil {
+			http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+			return
+		}
+		list = wrapped.Trackers
+	}
+
+	trackers, err := s.client.SetTrackers(videoId, list)
+	if err != nil {
+		log.Println("[Trackers] failed to set trackers", err)
+		if errors.Is(err, tor.ErrInvalidTracker) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeTorrentMissing(w, videoId)
+		return
+	}
+	writeTrackers(w, trackers)
+}
+
+func writeTrackers(w http.ResponseWriter, trackers []tor.TrackerStatus) {
+	if trackers == nil {
+		trackers = []tor.TrackerStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trackers)
+}
+
+// formTrackers returns the "trackers" fields of a parsed multipart form. Each
+// field may hold several URLs separated by commas or new lines.
+func formTrackers(r *http.Request) []string {
+	if r.MultipartForm == nil {
+		return nil
+	}
+
+	var trackers []string
+	for _, value := range r.MultipartForm.Value["trackers"] {
+		for _, u := range strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == '\n' || c == '\r' }) {
+			if u = strings.TrimSpace(u); u != "" {
+				trackers = append(trackers, u)
+			}
+		}
+	}
+	return trackers
+}
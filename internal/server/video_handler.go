@@ -1,18 +1,22 @@
 package server
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"mime"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
-	"github.com/anacrolix/torrent"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/media"
 	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
 	redisdb "github.com/scythe504/webtorrent/internal/redis-db"
 	"github.com/scythe504/webtorrent/internal/tor"
@@ -40,6 +44,10 @@ func (s *Server) saveVideo(w http.ResponseWriter, r *http.Request) {
 
 	if magnetLink == nil {
 		log.Println("[StartVideo] Could not get magnet link", err)
+		if state, err := s.t.State(link.VideoId); errors.Is(err, tor.ErrEvicted) || err == nil && state != tor.StateReady {
+			s.writeTorrentMissing(w, link.VideoId)
+			return
+		}
 		http.Error(w, "Failed to get magnet link, please renter the magnet link to get the video", http.StatusNotFound)
 		return
 	}
@@ -97,167 +105,378 @@ func (s *Server) listVideos(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// createVideo adds a torrent from a JSON magnet_link or torrent_url, or from
+// a .torrent file uploaded as multipart/form-data in the "torrent" field.
+// Extra trackers come in a "trackers" list or form field. A torrent that is
+// already active answers with its existing video_id.
 func (s *Server) createVideo(w http.ResponseWriter, r *http.Request) {
-	// 1. Get magnet link from request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println("[StartVideo] Invalid Request body", err)
-		http.Error(w, "Invalid json body", http.StatusBadRequest)
+	// Hold the token to its limit of active torrents
+	token := requestToken(r)
+	if !s.quotas.reserve(token, s.t) {
+		http.Error(w, fmt.Sprintf("API token already has %d active torrents", token.MaxActiveTorrents), http.StatusTooManyRequests)
 		return
 	}
-	defer r.Body.Close()
-	var link struct {
-		MagnetLink string `json:"magnet_link"`
+
+	requestedId := s.newVideoId()
+	videoId := requestedId
+	added := false
+	defer func() { s.quotas.commit(token, videoId, added) }()
+
+	var err error
+	if isMultipart(r) {
+		// 1a. Get torrent file from upload
+		mi, uploadErr := torrentFromUpload(r)
+		if uploadErr != nil {
+			log.Println("[StartVideo] Invalid torrent upload", uploadErr)
+			http.Error(w, uploadErr.Error(), http.StatusBadRequest)
+			return
+		}
+		videoId, err = s.t.AddMetainfo(videoId, mi, formTrackers(r)...)
+	} else {
+		// 1b. Get magnet link or torrent URL from request body
+		body, readErr := io.ReadAll(r.Body)
+		if readErr != nil {
+			log.Println("[StartVideo] Invalid Request body", readErr)
+			http.Error(w, "Invalid json body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		var link struct {
+			MagnetLink string   `json:"magnet_link"`
+			TorrentURL string   `json:"torrent_url"`
+			Trackers   []string `json:"trackers"`
+		}
+
+		if err = json.Unmarshal(body, &link); err != nil {
+			log.Println("[StartVideo] Invalid Json Body", err)
+			http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+			return
+		}
+
+		if link.TorrentURL != "" {
+			mi, fetchErr := fetchTorrent(r.Context(), link.TorrentURL)
+			if fetchErr != nil {
+				log.Println("[StartVideo] failed to fetch torrent url", fetchErr)
+				http.Error(w, fetchErr.Error(), http.StatusBadRequest)
+				return
+			}
+			videoId, err = s.t.AddMetainfo(videoId, mi, link.Trackers...)
+		} else {
+			videoId, err = s.t.AddMagnet(videoId, link.MagnetLink, link.Trackers...)
+		}
 	}
 
-	if err = json.Unmarshal(body, &link); err != nil {
-		log.Println("[StartVideo] Invalid Json Body", err)
-		http.Error(w, "Failed to Parse JSON", http.StatusBadRequest)
+	if err != nil {
+		log.Println("[StartVideo] failed to add the torrent", err)
+		if errors.Is(err, tor.ErrTooManyTorrents) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "too many active videos, try again later", http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, tor.ErrNoVideoFiles) {
+			http.Error(w, "torrent holds no video files", http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, tor.ErrSeeding) {
+			http.Error(w, "torrent is being seeded from the library, stream the saved video instead", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, tor.ErrInvalidTracker) || errors.Is(err, tor.ErrInvalidSelection) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "failed to get video", http.StatusBadRequest)
 		return
 	}
+	// An active torrent keeps counting against whoever added it first
+	added = videoId == requestedId
 
-	videoId := internal.RandomId()
-
-	if err = s.t.AddMagnet(videoId, link.MagnetLink); err != nil {
-		log.Println("[StartVideo] failed to get the magnet link")
-		http.Error(w, "failed to get video", http.StatusBadRequest)
+	state, err := s.t.State(videoId)
+	if err != nil {
+		// Evicted again before we could answer
+		s.writeTorrentMissing(w, videoId)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("{ \"video_id\": \"%s\" }", videoId)))
+	status := http.StatusOK
+	if state == tor.StateResolving {
+		status = http.StatusAccepted
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(fmt.Sprintf("{ \"video_id\": \"%s\", \"state\": \"%s\" }", videoId, state)))
 }
 
-// Resolve returns a video reader + metadata by checking torrent, cache, and disk.
+// maxIdAttempts bounds how many random ids newVideoId draws before giving up
+// on finding an unused one.
+const maxIdAttempts = 8
+
+// newVideoId returns a random id that is neither held by an active torrent nor
+// stored in the videos table. If the database cannot be reached, only active
+// torrents are checked.
+func (s *Server) newVideoId() string {
+	var id string
+	for range maxIdAttempts {
+		id = internal.RandomId()
+		if s.t.Has(id) {
+			continue
+		}
+
+		_, err := s.db.GetVideo(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return id
+		}
+		if err != nil {
+			log.Println("[NewVideoId] could not check id against the database", err)
+			return id
+		}
+	}
+
+	log.Printf("[NewVideoId] no unused id after %d attempts, using %s", maxIdAttempts, id)
+	return id
+}
+
+// errNotSaved is returned for videos without a file in the library.
+var errNotSaved = errors.New("video is not saved to the library")
+
+// savedVideo is what the resolver caches about a video in the library.
+type savedVideo struct {
+	path string
+	meta *tor.FileMetadata
+}
+
+// Resolve returns a video reader + metadata for the file at index.
 // Order of preference:
 // 1. Active torrent stream
-// 2. Cached metadata or DB record + on-disk file
-func (r *StreamResolver) Resolve(
-	videoId string,
-	getReader func(string) *torrent.Reader,
-	// getVideo func(string) (postgresdb.Video, error),
-	getMetadata func(string) (*tor.FileMetadata, error),
-) (io.ReadSeeker, *tor.FileMetadata, error) {
+// 2. Cached metadata or DB record + on-disk file, for the main video only
+// A reader over a torrent whose metadata is still resolving would block until
+// it resolves, whether or not the client is still there, so such a torrent is
+// skipped: its saved file is served instead, or the caller answers with the
+// resolution state.
+func (r *StreamResolver) Resolve(engine tor.Engine, videoId string, index int) (io.ReadSeeker, *tor.FileMetadata, error) {
+	state, stateErr := engine.State(videoId)
 
 	// Try torrent stream directly
-	if reader := getReader(videoId); reader != nil {
-		// For torrent, return basic metadata
-		meta, metaErr := getMetadata(videoId)
-		if metaErr != nil {
-			meta = &tor.FileMetadata{
-				Name:      "unknown_video",
-				Path:      "",
-				Length:    0,
-				Extension: ".mp4",
-				IsVideo:   true,
+	if stateErr == nil && state != tor.StateResolving {
+		if reader := engine.GetFileReader(videoId, index); reader != nil {
+			meta, metaErr := engine.GetFileMetadata(videoId, index)
+			if metaErr != nil {
+				meta = &tor.FileMetadata{
+					Name:      "unknown_video",
+					Path:      "",
+					Length:    0,
+					Extension: ".mp4",
+					IsVideo:   true,
+				}
 			}
+			return *reader, meta, nil
 		}
-		return *reader, meta, nil
-	}
-
-	return nil, &tor.FileMetadata{
-		Name:      "unknown_video",
-		Path:      "",
-		Length:    0,
-		Extension: ".mp4",
-		IsVideo:   true,
-	}, fmt.Errorf("couldn't get reader")
-
-	// Try cache or database
-	// var video postgresdb.Video
-	// if val, ok := r.cache.Load(videoId); ok {
-	// 	video = val.(postgresdb.Video)
-	// } else {
-	// 	v, err := getVideo(videoId)
-	// 	if err != nil {
-	// 		return nil, nil, fmt.Errorf("failed to get video from DB: %w", err)
-	// 	}
-	// 	video = v
-	// 	r.cache.Store(videoId, v)
-	// }
-
-	// // Try to open local file if path exists
-	// if video.FilePath != "" && internal.FileExists(video.FilePath) {
-	// 	f, err := os.Open(video.FilePath)
-	// 	if err != nil {
-	// 		return nil, nil, fmt.Errorf("failed to open file: %w", err)
-	// 	}
-
-	// 	info, err := os.Stat(video.FilePath)
-	// 	if err != nil {
-	// 		f.Close()
-	// 		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
-	// 	}
-
-	// 	meta := &tor.FileMetadata{
-	// 		Name:      filepath.Base(video.FilePath),
-	// 		Path:      video.FilePath,
-	// 		Length:    info.Size(),
-	// 		Extension: filepath.Ext(video.FilePath),
-	// 		IsVideo:   internal.IsVideoFile(filepath.Ext(video.FilePath)),
-	// 	}
-
-	// 	return f, meta, nil
-	// }
-
-	// Nothing found
-	// return nil, nil, os.ErrNotExist
+	}
+
+	if index != tor.MainFile {
+		return nil, nil, fmt.Errorf("%w for videoId: %s", errNotSaved, videoId)
+	}
+	return r.openSaved(videoId)
+}
+
+// Metadata describes the file saved to the library for videoId.
+func (r *StreamResolver) Metadata(videoId string) (*tor.FileMetadata, error) {
+	saved, err := r.saved(videoId)
+	if err != nil {
+		return nil, err
+	}
+	meta := *saved.meta
+	return &meta, nil
+}
+
+// Invalidate forgets what is cached about videoId, so its record and file are
+// looked up again on the next request.
+func (r *StreamResolver) Invalidate(videoId string) {
+	r.cache.Delete(videoId)
+}
+
+// openSaved opens the file saved to the library for videoId.
+func (r *StreamResolver) openSaved(videoId string) (*os.File, *tor.FileMetadata, error) {
+	saved, err := r.saved(videoId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := r.st.Open(saved.path)
+	if err != nil {
+		// Removed or replaced behind our back
+		r.Invalidate(videoId)
+		return nil, nil, err
+	}
+	meta := *saved.meta
+	return f, &meta, nil
+}
+
+// saved returns the library entry of videoId from the cache, or from the
+// database and disk on a miss. Only videos the worker finished saving are
+// cached, so a video that is saved later is found on its next request.
+func (r *StreamResolver) saved(videoId string) (*savedVideo, error) {
+	if val, ok := r.cache.Load(videoId); ok {
+		return val.(*savedVideo), nil
+	}
+
+	video, err := r.db.GetVideo(videoId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w for videoId: %s", errNotSaved, videoId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video from DB: %w", err)
+	}
+	if video.Deleted || video.Status != postgresdb.DOWNLOADED || video.FilePath == "" {
+		return nil, fmt.Errorf("%w for videoId: %s", errNotSaved, videoId)
+	}
+
+	meta, err := r.st.Describe(video.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	saved := &savedVideo{path: video.FilePath, meta: meta}
+	r.cache.Store(videoId, saved)
+	return saved, nil
+}
+
+// writeTorrentMissing answers for a video whose torrent cannot be used. Videos
+// dropped by the lifecycle manager get 410 Gone so clients know to add the
+// magnet link again instead of treating the id as unknown, and videos whose
+// metadata has not resolved get the status of their resolution state.
+func (s *Server) writeTorrentMissing(w http.ResponseWriter, videoId string) {
+	state, err := s.t.State(videoId)
+	switch {
+	case errors.Is(err, tor.ErrEvicted):
+		http.Error(w, "video was evicted from the server, add the magnet link again", http.StatusGone)
+	case err != nil, state == tor.StateReady:
+		http.Error(w, "video not found", http.StatusNotFound)
+	default:
+		status, message := stateStatus(state)
+		if state == tor.StateResolving {
+			w.Header().Set("Retry-After", "5")
+		}
+		http.Error(w, message, status)
+	}
+}
+
+// stateStatus maps a torrent resolution state to an HTTP status and a short
+// explanation for clients.
+func stateStatus(state tor.State) (int, string) {
+	switch state {
+	case tor.StateResolving:
+		return http.StatusAccepted, "video metadata is still resolving"
+	case tor.StateNoVideoFiles:
+		return http.StatusUnprocessableEntity, "torrent holds no video files"
+	case tor.StateTimedOut:
+		return http.StatusGatewayTimeout, "timed out resolving the torrent metadata, add the magnet link again"
+	}
+	return http.StatusOK, "video is ready"
+}
+
+// fileIndex returns the {index} route variable, or tor.MainFile when the route
+// does not select a file.
+func fileIndex(r *http.Request) int {
+	raw, ok := mux.Vars(r)["index"]
+	if !ok {
+		return tor.MainFile
+	}
+
+	index, err := strconv.Atoi(raw)
+	if err != nil {
+		return tor.MainFile
+	}
+
+	return index
+}
+
+func (s *Server) listVideoFiles(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["videoId"]
+
+	files, err := s.t.GetFiles(videoId)
+	if err != nil {
+		log.Println("[ListVideoFiles] failed to list files", err)
+		s.writeTorrentMissing(w, videoId)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(files)
 }
 
 func (s *Server) getVideoMetadata(w http.ResponseWriter, r *http.Request) {
 	videoId := mux.Vars(r)["videoId"]
+	index := fileIndex(r)
+
+	// Report resolution progress until the metadata is known, unless the
+	// video is already saved to the library
+	state, stateErr := s.t.State(videoId)
+	if stateErr == nil && state != tor.StateReady {
+		if meta, ok := s.savedMetadata(videoId, index); ok {
+			writeMetadata(w, meta)
+			return
+		}
+		status, _ := stateStatus(state)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"video_id": videoId, "state": string(state)})
+		return
+	}
 
 	// Try torrent first (if active)
-	meta, err := s.t.GetMetadata(videoId)
+	meta, err := s.t.GetFileMetadata(videoId, index)
 	if err == nil && meta != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(meta)
+		writeMetadata(w, meta)
+		return
+	}
+
+	// Fallback: get from DB and disk
+	if meta, ok := s.savedMetadata(videoId, index); ok {
+		writeMetadata(w, meta)
 		return
 	}
 
-	// // Fallback: get from DB and disk
-	// video, err := s.db.GetVideo(videoId)
-	// if err != nil {
-	// 	http.Error(w, "video not found", http.StatusNotFound)
-	// 	return
-	// }
-
-	// if video.FilePath == "" || !internal.FileExists(video.FilePath) {
-	// 	http.Error(w, "metadata unavailable", http.StatusNotFound)
-	// 	return
-	// }
-
-	// info, err := os.Stat(video.FilePath)
-	// if err != nil {
-	// 	http.Error(w, "failed to read file metadata", http.StatusInternalServerError)
-	// 	return
-	// }
-
-	// meta = &tor.FileMetadata{
-	// 	Name:      filepath.Base(video.FilePath),
-	// 	Path:      video.FilePath,
-	// 	Length:    info.Size(),
-	// 	Extension: filepath.Ext(video.FilePath),
-	// 	IsVideo:   internal.IsVideoFile(filepath.Ext(video.FilePath)),
-	// }
+	s.writeTorrentMissing(w, videoId)
+}
+
+// savedMetadata describes the file saved to the library for videoId. Saved
+// files hold the main video only, so other indexes are never found.
+func (s *Server) savedMetadata(videoId string, index int) (*tor.FileMetadata, bool) {
+	if index != tor.MainFile {
+		return nil, false
+	}
+
+	meta, err := s.streamResolver.Metadata(videoId)
+	if err != nil {
+		if !errors.Is(err, errNotSaved) {
+			log.Println("[VideoMetadata] failed to describe saved file", err)
+		}
+		return nil, false
+	}
+	return meta, true
+}
 
+func writeMetadata(w http.ResponseWriter, meta *tor.FileMetadata) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(meta)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		*tor.FileMetadata
+		State tor.State `json:"state"`
+	}{meta, tor.StateReady})
 }
 
 func (s *Server) streamVideo(w http.ResponseWriter, r *http.Request) {
 	videoId := mux.Vars(r)["videoId"]
+	index := fileIndex(r)
 
 	// Resolve reader + metadata
-	reader, meta, err := s.streamResolver.Resolve(
-		videoId,
-		s.t.GetReader, // Torrent getter
-		// s.db.GetVideo, // DB getter
-		s.t.GetMetadata,
-	)
+	reader, meta, err := s.streamResolver.Resolve(s.t, videoId, index)
 	if err != nil {
-		http.Error(w, "video not found", http.StatusNotFound)
+		if !errors.Is(err, errNotSaved) {
+			log.Println("[StreamVideo] failed to open saved file", err)
+		}
+		s.writeTorrentMissing(w, videoId)
 		return
 	}
 	defer func() {
@@ -266,14 +485,30 @@ func (s *Server) streamVideo(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	// Stop waiting on pieces as soon as the player abandons this range
+	if rc, ok := reader.(interface{ SetContext(context.Context) }); ok {
+		rc.SetContext(r.Context())
+	}
+
 	// Set response headers
-	contentType := mime.TypeByExtension(meta.Extension)
+	contentType := meta.MimeType
+	if contentType == "" {
+		contentType = media.TypeByExtension(meta.Extension)
+	}
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Accept-Ranges", "bytes")
 
+	// Saved files can be revalidated by clients
+	modTime := time.Now()
+	if f, ok := reader.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+
 	// Stream with actual filename
-	http.ServeContent(w, r, meta.Name, time.Now(), reader)
+	http.ServeContent(w, r, meta.Name, modTime, reader)
 }
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+	"github.com/scythe504/webtorrent/internal/storage"
+	"github.com/scythe504/webtorrent/internal/tor"
+	"github.com/scythe504/webtorrent/internal/tor/tortest"
+)
+
+// emptyDB is a database without any videos.
+type emptyDB struct{ postgresdb.Service }
+
+func (emptyDB) GetVideo(string) (postgresdb.Video, error) {
+	return postgresdb.Video{}, sql.ErrNoRows
+}
+
+func newTestServer(t *testing.T, engine tor.Engine) *httptest.Server {
+	t.Helper()
+
+	s := &Server{
+		db:             emptyDB{},
+		t:              engine,
+		streamResolver: newStreamResolver(emptyDB{}, nil),
+		idempotency:    newIdempotencyCache(),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func addVideo(t *testing.T, srv *httptest.Server, magnet string) (id string, status int) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"magnet_link": magnet})
+	resp, err := http.Post(srv.URL+"/videos", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		VideoId string `json:"video_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	return created.VideoId, resp.StatusCode
+}
+
+func TestStreamVideoServesRanges(t *testing.T) {
+	engine := tortest.New()
+	video := bytes.Repeat([]byte("0123456789"), 10_000)
+	added := engine.Add("Show", tortest.File{Path: "Show/episode.mkv", Data: video},
+		tortest.File{Path: "Show/episode.en.srt", Data: []byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n")})
+	srv := newTestServer(t, engine)
+
+	id, status := addVideo(t, srv, added.Magnet)
+	if status != http.StatusOK || id == "" {
+		t.Fatalf("add: got %d, id %q", status, id)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/videos/"+id+"/stream", nil)
+	req.Header.Set("Range", "bytes=10-29")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(got, video[10:30]) {
+		t.Errorf("range: got %d %q", resp.StatusCode, got)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "video/x-matroska" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	if n := engine.OpenReaders(); n != 0 {
+		t.Errorf("%d reader(s) left open after streaming", n)
+	}
+
+	resp, err = http.Get(srv.URL + "/videos/" + id + "/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []tor.FileMetadata
+	json.NewDecoder(resp.Body).Decode(&files)
+	resp.Body.Close()
+	if len(files) != 2 || files[0].Type != tor.FileTypeVideo || files[1].Type != tor.FileTypeSubtitle {
+		t.Errorf("files = %+v", files)
+	}
+}
+
+func TestVideoFileRoutes(t *testing.T) {
+	engine := tortest.New()
+	subtitle := []byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n")
+	// The largest file is not a video
+	added := engine.Add("Pack",
+		tortest.File{Path: "Pack/a.mkv", Data: make([]byte, 1000)},
+		tortest.File{Path: "Pack/b.mp4", Data: make([]byte, 2000)},
+		tortest.File{Path: "Pack/c.nfo", Data: make([]byte, 4000)},
+		tortest.File{Path: "Pack/d.srt", Data: subtitle})
+	srv := newTestServer(t, engine)
+
+	id, status := addVideo(t, srv, added.Magnet)
+	if status != http.StatusOK {
+		t.Fatalf("add: got %d", status)
+	}
+
+	metadata := func(path string) (int, tor.FileMetadata) {
+		resp, err := http.Get(srv.URL + "/videos/" + id + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var meta tor.FileMetadata
+		json.NewDecoder(resp.Body).Decode(&meta)
+		return resp.StatusCode, meta
+	}
+
+	if status, meta := metadata("/metadata"); status != http.StatusOK || meta.Index != 1 || meta.Name != "b.mp4" {
+		t.Errorf("main file metadata: got %d %+v, want the largest video", status, meta)
+	}
+	if status, meta := metadata("/files/3/metadata"); status != http.StatusOK || meta.Index != 3 || meta.IsVideo || meta.Type != tor.FileTypeSubtitle {
+		t.Errorf("subtitle metadata: got %d %+v", status, meta)
+	}
+	if status, _ := metadata("/files/4/metadata"); status != http.StatusNotFound {
+		t.Errorf("metadata of an out of range index: got %d, want 404", status)
+	}
+
+	resp, err := http.Get(srv.URL + "/videos/" + id + "/files/3/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, subtitle) {
+		t.Errorf("stream of the subtitle: got %d %q", resp.StatusCode, got)
+	}
+
+	resp, err = http.Get(srv.URL + "/videos/" + id + "/files/4/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stream of an out of range index: got %d, want 404", resp.StatusCode)
+	}
+	if n := engine.OpenReaders(); n != 0 {
+		t.Errorf("%d reader(s) left open after streaming", n)
+	}
+}
+
+func TestStreamVideoWhileResolving(t *testing.T) {
+	srv := newTestServer(t, tortest.New())
+
+	// Nobody serves this info-hash
+	id, status := addVideo(t, srv, "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567")
+	if status != http.StatusAccepted {
+		t.Fatalf("add: got %d, want 202 while resolving", status)
+	}
+
+	resp, err := http.Get(srv.URL + "/videos/" + id + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || !strings.Contains(string(body), "resolving") {
+		t.Errorf("stream: got %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(srv.URL + "/videos/unknown/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stream of an unknown id: got %d", resp.StatusCode)
+	}
+}
+
+// stuckEngine holds readers of torrents that are still resolving until
+// release is closed, as a real torrent waiting on its metadata does.
+type stuckEngine struct {
+	*tortest.Engine
+	release chan struct{}
+	waiting atomic.Int32
+}
+
+func (e *stuckEngine) GetFileReader(id string, index int) *torrent.Reader {
+	if state, err := e.State(id); err == nil && state == tor.StateResolving {
+		e.waiting.Add(1)
+		defer e.waiting.Add(-1)
+		<-e.release
+	}
+	return e.Engine.GetFileReader(id, index)
+}
+
+func TestStreamVideoWhileResolvingDoesNotWait(t *testing.T) {
+	engine := &stuckEngine{Engine: tortest.New(), release: make(chan struct{})}
+	defer close(engine.release)
+	srv := newTestServer(t, engine)
+
+	// Nobody serves this info-hash
+	id, _ := addVideo(t, srv, "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/videos/"+id+"/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream of a resolving id was canceled before it answered: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("stream: got %d, want 202", resp.StatusCode)
+	}
+	if n := engine.waiting.Load(); n != 0 {
+		t.Errorf("%d request(s) still waiting on the metadata", n)
+	}
+}
+
+// fullEngine has reached its limit of active torrents.
+type fullEngine struct{ *tortest.Engine }
+
+func (fullEngine) AddMagnet(string, string, ...string) (string, error) {
+	return "", tor.ErrTooManyTorrents
+}
+
+// evictedEngine has dropped every id it was asked about.
+type evictedEngine struct{ *tortest.Engine }
+
+func (evictedEngine) State(id string) (tor.State, error) {
+	return "", fmt.Errorf("%w for videoId: %s", tor.ErrEvicted, id)
+}
+
+func TestLifecycleLimits(t *testing.T) {
+	added := tortest.New().Add("movie.mkv", tortest.File{Path: "movie.mkv", Data: []byte("video")})
+
+	srv := newTestServer(t, fullEngine{tortest.New()})
+	body, _ := json.Marshal(map[string]string{"magnet_link": added.Magnet})
+	resp, err := http.Post(srv.URL+"/videos", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("add while full: got %d, Retry-After %q; want 429 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	srv = newTestServer(t, evictedEngine{tortest.New()})
+	for _, path := range []string{"/stream", "/metadata", "/files/0/stream"} {
+		resp, err := http.Get(srv.URL + "/videos/evicted" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusGone {
+			t.Errorf("%s of an evicted id: got %d, want 410", path, resp.StatusCode)
+		}
+	}
+}
+
+// libraryDB holds videos saved to the library.
+type libraryDB struct {
+	emptyDB
+	videos map[string]postgresdb.Video
+}
+
+func (db libraryDB) GetVideo(id string) (postgresdb.Video, error) {
+	v, ok := db.videos[id]
+	if !ok {
+		return postgresdb.Video{}, sql.ErrNoRows
+	}
+	return v, nil
+}
+
+func TestStreamVideoFromLibrary(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOWNLOAD_PATH", dir)
+	video := append([]byte("\x1a\x45\xdf\xa3\x9f\x42\x82\x84webm"), bytes.Repeat([]byte("frame"), 1_000)...)
+	path := filepath.Join(dir, "movie.bin")
+	if err := os.WriteFile(path, video, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := libraryDB{videos: map[string]postgresdb.Video{
+		"saved": {Id: "saved", Status: postgresdb.DOWNLOADED, FilePath: path},
+	}}
+	s := &Server{
+		db:             db,
+		t:              tortest.New(),
+		streamResolver: newStreamResolver(db, storage.New()),
+		idempotency:    newIdempotencyCache(),
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/videos/saved/stream", nil)
+	req.Header.Set("Range", "bytes=4-99")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(got, video[4:100]) {
+		t.Errorf("range: got %d %q", resp.StatusCode, got)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "video/webm" {
+		t.Errorf("got sniffed Content-Type %q", ct)
+	}
+
+	resp, err = http.Get(srv.URL + "/videos/saved/metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta tor.FileMetadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || meta.Length != int64(len(video)) || !meta.IsVideo {
+		t.Errorf("metadata: got %d %+v", resp.StatusCode, meta)
+	}
+
+	// Cached until the status changes
+	db.videos["saved"] = postgresdb.Video{Id: "saved", Status: postgresdb.PROCESSING}
+	if _, err := s.streamResolver.Metadata("saved"); err != nil {
+		t.Errorf("expected the cached metadata, got %v", err)
+	}
+	s.streamResolver.Invalidate("saved")
+
+	resp, err = http.Get(srv.URL + "/videos/saved/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stream while saving again: got %d, want 404", resp.StatusCode)
+	}
+}
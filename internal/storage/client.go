@@ -10,6 +10,11 @@ import (
 
 type Service interface {
 	SaveForLater(videoId string, reader io.Reader, meta tor.FileMetadata) (string, error)
+	// Open opens a saved file for reading. The caller closes it.
+	Open(filePath string) (*os.File, error)
+	// Describe returns the metadata of a saved file, with its MIME type
+	// sniffed from its contents.
+	Describe(filePath string) (*tor.FileMetadata, error)
 }
 
 type service struct {
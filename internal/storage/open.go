@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/media"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// Open opens a file saved by SaveForLater. Serving the *os.File itself lets
+// net/http hand it to sendfile.
+func (s *service) Open(filePath string) (*os.File, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open saved file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat saved file %s: %w", filePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("saved file %s is not a regular file", filePath)
+	}
+	return f, nil
+}
+
+// Describe builds the metadata of a saved file. Saved files hold the main
+// video of their torrent, so they are described as its only file.
+func (s *service) Describe(filePath string) (*tor.FileMetadata, error) {
+	f, err := s.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat saved file %s: %w", filePath, err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filePath))
+	meta := &tor.FileMetadata{
+		Name:      filepath.Base(filePath),
+		Path:      filePath,
+		Length:    info.Size(),
+		Extension: ext,
+		Type:      tor.FileTypeOther,
+		IsVideo:   internal.IsVideoFile(ext),
+		MimeType:  media.TypeByExtension(ext),
+		Selected:  true,
+	}
+
+	container, ok, err := media.SniffReader(io.NewSectionReader(f, 0, media.SniffLen))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read head of %s: %w", filePath, err)
+	}
+	if ok {
+		meta.MimeType = container.MimeType
+		meta.IsVideo = meta.IsVideo || container.Video
+	}
+	if meta.IsVideo {
+		meta.Type = tor.FileTypeVideo
+	}
+	return meta, nil
+}
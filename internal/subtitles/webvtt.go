@@ -0,0 +1,269 @@
+// Package subtitles converts subtitle formats to WebVTT, the only format
+// browsers accept in <track> elements.
+package subtitles
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats understood by ToWebVTT.
+const (
+	FormatSRT    = "srt"
+	FormatASS    = "ass"
+	FormatWebVTT = "vtt"
+)
+
+// Cue is a single timed subtitle.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// FormatFromExtension returns the format of a subtitle file extension such as
+// ".srt", or "" when it is not a supported subtitle format.
+func FormatFromExtension(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".srt":
+		return FormatSRT
+	case ".ass", ".ssa":
+		return FormatASS
+	case ".vtt":
+		return FormatWebVTT
+	}
+	return ""
+}
+
+// ToWebVTT converts a subtitle file in format to WebVTT. Text that is not
+// valid UTF-8 is assumed to be Latin-1, which covers most older SRT files.
+func ToWebVTT(data []byte, format string) ([]byte, error) {
+	text := normalize(data)
+
+	switch format {
+	case FormatWebVTT:
+		if !strings.HasPrefix(text, "WEBVTT") {
+			text = "WEBVTT\n\n" + text
+		}
+		return []byte(text), nil
+	case FormatSRT:
+		return srtToWebVTT(text), nil
+	case FormatASS:
+		cues, err := parseASS(text)
+		if err != nil {
+			return nil, err
+		}
+		return WriteWebVTT(cues), nil
+	}
+	return nil, fmt.Errorf("unsupported subtitle format: %q", format)
+}
+
+// WriteWebVTT renders cues as a WebVTT file.
+func WriteWebVTT(cues []Cue) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for _, c := range cues {
+		text := strings.TrimSpace(c.Text)
+		if text == "" {
+			continue
+		}
+		// A blank line would end the cue early
+		text = blankLines.ReplaceAllString(text, "\n")
+		fmt.Fprintf(&buf, "\n%s --> %s\n%s\n", timestamp(c.Start), timestamp(c.End), text)
+	}
+	return buf.Bytes()
+}
+
+var blankLines = regexp.MustCompile(`\n\s*\n`)
+
+// timestamp formats d as a WebVTT timestamp, hh:mm:ss.mmm.
+func timestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// normalize decodes data to UTF-8 without a byte order mark and with \n line
+// endings.
+func normalize(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	var text string
+	if utf8.Valid(data) {
+		text = string(data)
+	} else {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+// srtTiming matches an SRT timing line. Some files use a dot instead of a
+// comma before the milliseconds.
+var srtTiming = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})[,.](\d{1,3})\s*-->\s*(\d+):(\d{2}):(\d{2})[,.](\d{1,3})`)
+
+// srtToWebVTT rewrites the timing lines of an SRT file and drops the cue
+// numbers. The cue text is kept as is: the basic SRT tags (<b>, <i>, <u>) are
+// valid WebVTT too.
+func srtToWebVTT(text string) []byte {
+	var cues []Cue
+	var cur *Cue
+
+	for _, line := range strings.Split(text, "\n") {
+		if m := srtTiming.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			if cur != nil {
+				// No blank line before this cue, so its number ended up as
+				// the last text line of the previous one
+				if i := strings.LastIndex(cur.Text, "\n"); i >= 0 {
+					if _, err := strconv.Atoi(strings.TrimSpace(cur.Text[i+1:])); err == nil {
+						cur.Text = cur.Text[:i]
+					}
+				}
+				cues = append(cues, *cur)
+			}
+			cur = &Cue{Start: clock(m[1], m[2], m[3], m[4]), End: clock(m[5], m[6], m[7], m[8])}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			cues = append(cues, *cur)
+			cur = nil
+			continue
+		}
+		if cur.Text != "" {
+			cur.Text += "\n"
+		}
+		cur.Text += line
+	}
+	if cur != nil {
+		cues = append(cues, *cur)
+	}
+
+	return WriteWebVTT(cues)
+}
+
+// clock builds a duration from hours, minutes, seconds and a fraction of a
+// second given with one to three digits.
+func clock(h, m, s, frac string) time.Duration {
+	hours, _ := strconv.Atoi(h)
+	minutes, _ := strconv.Atoi(m)
+	seconds, _ := strconv.Atoi(s)
+	fraction, _ := strconv.Atoi(frac)
+	for i := len(frac); i < 3; i++ {
+		fraction *= 10
+	}
+
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(fraction)*time.Millisecond
+}
+
+// assTime matches an ASS timestamp, h:mm:ss.cc.
+var assTime = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})[.:](\d{1,3})$`)
+
+// assOverride matches style override blocks such as {\an8} or {\i1}.
+var assOverride = regexp.MustCompile(`\{[^}]*\}`)
+
+// parseASS reads the Dialogue lines of the [Events] section of an ASS or SSA
+// file. Styling and positioning are dropped, only the text is kept.
+func parseASS(text string) ([]Cue, error) {
+	var (
+		cues    []Cue
+		inEvent bool
+		fields  []string
+	)
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvent = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvent {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "Format":
+			fields = strings.Split(value, ",")
+			for i := range fields {
+				fields[i] = strings.ToLower(strings.TrimSpace(fields[i]))
+			}
+		case "Dialogue":
+			if fields == nil {
+				return nil, fmt.Errorf("dialogue before the events format line")
+			}
+			if cue, ok := assCue(fields, value); ok {
+				cues = append(cues, cue)
+			}
+		}
+	}
+
+	if fields == nil {
+		return nil, fmt.Errorf("no [Events] section found")
+	}
+
+	// Events are not required to be in order, cues are
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return cues, nil
+}
+
+func assCue(fields []string, value string) (Cue, bool) {
+	// Text is always the last field and may contain commas itself
+	values := strings.SplitN(value, ",", len(fields))
+	if len(values) != len(fields) {
+		return Cue{}, false
+	}
+
+	var cue Cue
+	var okStart, okEnd bool
+	for i, field := range fields {
+		switch field {
+		case "start":
+			cue.Start, okStart = assTimestamp(values[i])
+		case "end":
+			cue.End, okEnd = assTimestamp(values[i])
+		case "text":
+			cue.Text = ASSText(values[i])
+		}
+	}
+	return cue, okStart && okEnd
+}
+
+// ASSText turns the text field of an ASS or SSA event into plain text,
+// dropping style overrides and expanding line breaks and hard spaces.
+func ASSText(text string) string {
+	text = assOverride.ReplaceAllString(text, "")
+	return strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+}
+
+func assTimestamp(raw string) (time.Duration, bool) {
+	m := assTime.FindStringSubmatch(strings.TrimSpace(raw))
+	if m == nil {
+		return 0, false
+	}
+	return clock(m[1], m[2], m[3], m[4]), true
+}
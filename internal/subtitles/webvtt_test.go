@@ -0,0 +1,43 @@
+package subtitles
+
+import "testing"
+
+func TestToWebVTT(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		in     string
+		want   string
+	}{
+		{
+			name:   "srt",
+			format: FormatSRT,
+			in:     "\xEF\xBB\xBF1\r\n00:00:01,500 --> 00:00:03,000\r\n<i>Hello</i>\r\nthere\r\n\r\n2\r\n00:01:02,05 --> 00:01:04,000\r\nAgain\r\n3\r\n01:00:00,000 --> 01:00:01,000\r\nNo blank line before me\r\n",
+			want:   "WEBVTT\n\n00:00:01.500 --> 00:00:03.000\n<i>Hello</i>\nthere\n\n00:01:02.050 --> 00:01:04.000\nAgain\n\n01:00:00.000 --> 01:00:01.000\nNo blank line before me\n",
+		},
+		{
+			name:   "ass",
+			format: FormatASS,
+			in:     "[Script Info]\nTitle: test\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\nDialogue: 0,0:00:05.00,0:00:06.50,Default,,0,0,0,,{\\an8}Second, with a comma\\Nline two\nDialogue: 0,0:00:01.25,0:00:02.00,Default,,0,0,0,,First\n",
+			want:   "WEBVTT\n\n00:00:01.250 --> 00:00:02.000\nFirst\n\n00:00:05.000 --> 00:00:06.500\nSecond, with a comma\nline two\n",
+		},
+		{
+			name:   "latin-1 vtt",
+			format: FormatWebVTT,
+			in:     "00:00:01.000 --> 00:00:02.000\nCaf\xe9\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nCafé\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToWebVTT([]byte(tt.in), tt.format)
+			if err != nil {
+				t.Fatalf("ToWebVTT: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package tor
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterBurst is the burst of the global limiters. It has to fit the
+	// largest chunk or read the client rate limits at once.
+	limiterBurst = 1 << 20
+	// throttleInterval is how often per-video limits are enforced.
+	throttleInterval = 250 * time.Millisecond
+)
+
+// ErrInvalidLimit is returned for negative bandwidth limits.
+var ErrInvalidLimit = errors.New("bandwidth limits must not be negative")
+
+// Limits caps transfer rates in bytes per second. Zero means unlimited.
+type Limits struct {
+	Download int64 `json:"download"`
+	Upload   int64 `json:"upload"`
+}
+
+func (l Limits) valid() bool {
+	return l.Download >= 0 && l.Upload >= 0
+}
+
+// Bandwidth holds the limits of the whole client and the overrides of single
+// videos, keyed by video id.
+type Bandwidth struct {
+	Limits
+	Videos map[string]Limits `json:"videos,omitempty"`
+}
+
+// bandwidth enforces the limits. The global limits are rate limiters shared
+// with the client, so changing them takes effect on the next transfer. The
+// client cannot limit a single torrent, so per-video limits are kept by
+// pausing a torrent's transfers whenever it has used up its byte budget.
+type bandwidth struct {
+	download *rate.Limiter
+	upload   *rate.Limiter
+
+	mu        sync.Mutex
+	global    Limits
+	videos    map[string]Limits
+	throttles map[*torrent.Torrent]*throttle
+}
+
+// throttle is the budget of one torrent. Counters are the cumulative data
+// bytes at the last check, budgets may go negative after a burst.
+type throttle struct {
+	at                   time.Time
+	read, written        int64
+	downBudget, upBudget float64
+	downPaused, upPaused bool
+}
+
+func newBandwidth(l Limits) *bandwidth {
+	return &bandwidth{
+		download:  rate.NewLimiter(rateLimit(l.Download), limiterBurst),
+		upload:    rate.NewLimiter(rateLimit(l.Upload), limiterBurst),
+		global:    l,
+		videos:    make(map[string]Limits),
+		throttles: make(map[*torrent.Torrent]*throttle),
+	}
+}
+
+func rateLimit(bytesPerSecond int64) rate.Limit {
+	if bytesPerSecond <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bytesPerSecond)
+}
+
+func (b *bandwidth) setGlobalLocked(l Limits) {
+	b.global = l
+	b.download.SetLimit(rateLimit(l.Download))
+	b.upload.SetLimit(rateLimit(l.Upload))
+}
+
+// Bandwidth returns the current limits.
+func (tr *Torrent) Bandwidth() Bandwidth {
+	b := tr.bandwidth
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Bandwidth{Limits: b.global, Videos: maps.Clone(b.videos)}
+}
+
+// SetBandwidth replaces the global limits and every per-video override.
+func (tr *Torrent) SetBandwidth(bw Bandwidth) error {
+	if !bw.valid() {
+		return ErrInvalidLimit
+	}
+	videos := make(map[string]Limits, len(bw.Videos))
+	for id, l := range bw.Videos {
+		if !l.valid() {
+			return ErrInvalidLimit
+		}
+		if l != (Limits{}) {
+			videos[id] = l
+		}
+	}
+
+	b := tr.bandwidth
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.setGlobalLocked(bw.Limits)
+	b.videos = videos
+	return nil
+}
+
+// SetLimits changes the limits of the whole client.
+func (tr *Torrent) SetLimits(l Limits) error {
+	if !l.valid() {
+		return ErrInvalidLimit
+	}
+
+	b := tr.bandwidth
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.setGlobalLocked(l)
+	return nil
+}
+
+// SetVideoLimits limits the torrent of videoId on top of the global limits.
+// Zero limits remove the override. Overrides are kept by id until removed, so
+// they can be set before the video is added and apply again if it is added
+// back.
+func (tr *Torrent) SetVideoLimits(videoId string, l Limits) error {
+	if !l.valid() {
+		return ErrInvalidLimit
+	}
+
+	b := tr.bandwidth
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if l == (Limits{}) {
+		delete(b.videos, videoId)
+	} else {
+		b.videos[videoId] = l
+	}
+	return nil
+}
+
+// enforceLimits checks the per-video limits until the client is closed.
+func (tr *Torrent) enforceLimits() {
+	ticker := time.NewTicker(throttleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tr.throttle(time.Now())
+		case <-tr.cl.Closed():
+			return
+		}
+	}
+}
+
+// throttle pauses the transfers of torrents that went over their video's
+// limits and resumes the ones that are back within budget.
+func (tr *Torrent) throttle(now time.Time) {
+	active := tr.tor.torrents()
+
+	b := tr.bandwidth
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seen := make(map[*torrent.Torrent]bool, len(active))
+	for id, t := range active {
+		seen[t] = true
+		limits := b.videos[id]
+
+		th, ok := b.throttles[t]
+		if !ok {
+			if limits == (Limits{}) {
+				continue
+			}
+			st := t.Stats()
+			th = &throttle{at: now, read: st.BytesReadData.Int64(), written: st.BytesWrittenData.Int64()}
+			b.throttles[t] = th
+		}
+
+		st := t.Stats()
+		read, written := st.BytesReadData.Int64(), st.BytesWrittenData.Int64()
+		elapsed := now.Sub(th.at).Seconds()
+
+		pause := spend(&th.downBudget, limits.Download, elapsed, read-th.read)
+		if pause != th.downPaused {
+			if pause {
+				t.DisallowDataDownload()
+			} else {
+				t.AllowDataDownload()
+			}
+			th.downPaused = pause
+		}
+
+		pause = spend(&th.upBudget, limits.Upload, elapsed, written-th.written)
+		if pause != th.upPaused {
+			if pause {
+				t.DisallowDataUpload()
+			} else {
+				t.AllowDataUpload()
+			}
+			th.upPaused = pause
+		}
+
+		th.at, th.read, th.written = now, read, written
+		if limits == (Limits{}) {
+			delete(b.throttles, t)
+		}
+	}
+
+	for t := range b.throttles {
+		if !seen[t] {
+			delete(b.throttles, t)
+		}
+	}
+}
+
+// spend refills budget at limit bytes per second, keeping at most a second's
+// worth, takes used bytes out of it and reports whether transfers have to
+// pause. A zero limit never pauses.
+func spend(budget *float64, limit int64, elapsed float64, used int64) bool {
+	if limit <= 0 {
+		*budget = 0
+		return false
+	}
+
+	*budget = min(*budget+float64(limit)*elapsed, float64(limit)) - float64(used)
+	return *budget < 0
+}
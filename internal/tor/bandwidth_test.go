@@ -0,0 +1,51 @@
+package tor
+
+import "testing"
+
+func TestSpendPausesOverBudget(t *testing.T) {
+	var budget float64
+
+	// 1000 B/s: a quarter second allows 250 bytes
+	if spend(&budget, 1000, 0.25, 200) {
+		t.Fatal("paused within budget")
+	}
+	if !spend(&budget, 1000, 0.25, 400) {
+		t.Fatal("not paused over budget")
+	}
+	// Nothing moves while paused until the budget has refilled
+	if !spend(&budget, 1000, 0.05, 0) {
+		t.Fatal("resumed before the budget refilled")
+	}
+	if spend(&budget, 1000, 0.5, 0) {
+		t.Fatal("still paused after the budget refilled")
+	}
+
+	// The budget never holds more than a second's worth
+	spend(&budget, 1000, 60, 0)
+	if !spend(&budget, 1000, 0, 1500) {
+		t.Fatal("idle time built up more than a second of budget")
+	}
+
+	if spend(&budget, 0, 1, 1<<30) {
+		t.Fatal("paused without a limit")
+	}
+}
+
+func TestSetBandwidthRejectsNegativeLimits(t *testing.T) {
+	tr := newTestTorrent(t, t.TempDir())
+
+	if err := tr.SetVideoLimits("abc", Limits{Download: -1}); err != ErrInvalidLimit {
+		t.Fatalf("got %v, want ErrInvalidLimit", err)
+	}
+	if err := tr.SetBandwidth(Bandwidth{Limits: Limits{Upload: 10}, Videos: map[string]Limits{"abc": {Download: 5}, "def": {}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	bw := tr.Bandwidth()
+	if bw.Upload != 10 || len(bw.Videos) != 1 || bw.Videos["abc"].Download != 5 {
+		t.Fatalf("unexpected limits %+v", bw)
+	}
+	if tr.bandwidth.upload.Limit() != 10 {
+		t.Fatalf("upload limiter not updated: %v", tr.bandwidth.upload.Limit())
+	}
+}
@@ -0,0 +1,315 @@
+package tor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// ErrNoBlocklist is returned when no blocklist is configured.
+var ErrNoBlocklist = errors.New("no blocklist configured")
+
+// blocklistCheckInterval is how often the blocklist file is checked for
+// changes.
+const blocklistCheckInterval = time.Minute
+
+// maxBlocklistHits bounds the number of ranges whose hits are counted one by
+// one. Hits on other ranges only count towards the total.
+const maxBlocklistHits = 1000
+
+// BlocklistStats describes the loaded blocklist and how often it kept peers
+// out since it was loaded.
+type BlocklistStats struct {
+	Path     string         `json:"path"`
+	Ranges   int            `json:"ranges"`
+	Skipped  int            `json:"skipped_lines"` // Lines that were not understood
+	LoadedAt time.Time      `json:"loaded_at"`
+	Hits     int64          `json:"hits"`
+	ByRange  map[string]int `json:"hits_by_range,omitempty"` // Keyed by range description
+}
+
+// blocklist is handed to the client once and swaps the list it looks up when
+// reloaded, since the client cannot be given a new one.
+type blocklist struct {
+	path string
+	list atomic.Pointer[iplist.IPList]
+	hits atomic.Int64
+
+	mu       sync.Mutex
+	byRange  map[string]int
+	skipped  int
+	loadedAt time.Time
+	modTime  time.Time
+}
+
+func newBlocklist(path string) *blocklist {
+	return &blocklist{path: path, byRange: make(map[string]int)}
+}
+
+// Lookup implements iplist.Ranger.
+func (b *blocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	list := b.list.Load()
+	if list == nil {
+		return iplist.Range{}, false
+	}
+	r, ok := list.Lookup(ip)
+	if !ok {
+		return r, false
+	}
+
+	b.hits.Add(1)
+	b.mu.Lock()
+	if _, counted := b.byRange[r.Description]; counted || len(b.byRange) < maxBlocklistHits {
+		b.byRange[r.Description]++
+	}
+	b.mu.Unlock()
+	return r, true
+}
+
+// NumRanges implements iplist.Ranger.
+func (b *blocklist) NumRanges() int {
+	list := b.list.Load()
+	if list == nil {
+		return 0
+	}
+	return list.NumRanges()
+}
+
+// load reads the blocklist file and swaps it in, resetting the hit counts.
+// The previous list stays in place when the file cannot be read.
+func (b *blocklist) load() error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	ranges, skipped, err := parseBlocklist(f)
+	if err != nil {
+		return fmt.Errorf("failed to read blocklist %s: %w", b.path, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.list.Store(iplist.New(ranges))
+	b.hits.Store(0)
+	b.byRange = make(map[string]int)
+	b.skipped = skipped
+	b.loadedAt = time.Now()
+	b.modTime = stat.ModTime()
+	return nil
+}
+
+// changed reports whether the file was modified since it was loaded.
+func (b *blocklist) changed() bool {
+	stat, err := os.Stat(b.path)
+	if err != nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !stat.ModTime().Equal(b.modTime)
+}
+
+func (b *blocklist) stats() BlocklistStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	byRange := make(map[string]int, len(b.byRange))
+	for desc, n := range b.byRange {
+		byRange[desc] = n
+	}
+	return BlocklistStats{
+		Path:     b.path,
+		Ranges:   b.NumRanges(),
+		Skipped:  b.skipped,
+		LoadedAt: b.loadedAt,
+		Hits:     b.hits.Load(),
+		ByRange:  byRange,
+	}
+}
+
+// Blocklist returns the stats of the IP blocklist.
+func (tr *Torrent) Blocklist() (BlocklistStats, error) {
+	if tr.blocklist == nil {
+		return BlocklistStats{}, ErrNoBlocklist
+	}
+	return tr.blocklist.stats(), nil
+}
+
+// ReloadBlocklist reads the blocklist file again. Connections to peers that
+// are already open are kept.
+func (tr *Torrent) ReloadBlocklist() (BlocklistStats, error) {
+	if tr.blocklist == nil {
+		return BlocklistStats{}, ErrNoBlocklist
+	}
+	if err := tr.blocklist.load(); err != nil {
+		return BlocklistStats{}, err
+	}
+	stats := tr.blocklist.stats()
+	log.Printf("[Blocklist] loaded %d range(s) from %s", stats.Ranges, stats.Path)
+	return stats, nil
+}
+
+// watchBlocklist reloads the blocklist whenever its file changes, until the
+// client is closed.
+func (tr *Torrent) watchBlocklist() {
+	ticker := time.NewTicker(blocklistCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !tr.blocklist.changed() {
+				continue
+			}
+			if _, err := tr.ReloadBlocklist(); err != nil {
+				log.Printf("[Blocklist] failed to reload: %v", err)
+			}
+		case <-tr.cl.Closed():
+			return
+		}
+	}
+}
+
+// parseBlocklist reads IP ranges in the P2P plaintext format
+// ("description:1.2.3.0-1.2.3.255") or the eMule DAT format
+// ("001.002.003.000 - 001.002.003.255 , 000 , description"), gzipped or not.
+// DAT ranges with an access level above 127 are allowed, not blocked. Lines
+// that fit neither format are counted and skipped, real world lists have a
+// few. The ranges are returned sorted with overlaps merged, as iplist needs.
+func parseBlocklist(r io.Reader) (ranges []iplist.Range, skipped int, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		rng, ok, blocked := parseDATLine(line)
+		if !ok {
+			var err error
+			rng, ok, err = iplist.ParseBlocklistP2PLine([]byte(line))
+			ok, blocked = ok && err == nil, true
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		if blocked {
+			ranges = append(ranges, rng)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return mergeRanges(ranges), skipped, nil
+}
+
+// parseDATLine parses a line of an eMule ipfilter.dat file and reports
+// whether its range is blocked.
+func parseDATLine(line string) (r iplist.Range, ok, blocked bool) {
+	fields := strings.SplitN(line, ",", 3)
+	if len(fields) < 2 {
+		return r, false, false
+	}
+	first, last, found := strings.Cut(fields[0], "-")
+	if !found {
+		return r, false, false
+	}
+
+	r.First, r.Last = parseIP(first), parseIP(last)
+	level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+	if r.First == nil || r.Last == nil || len(r.First) != len(r.Last) || err != nil {
+		return r, false, false
+	}
+	if len(fields) == 3 {
+		r.Description = strings.TrimSpace(fields[2])
+	}
+	return r, true, level <= 127
+}
+
+// parseIP parses an address that may be zero padded, such as 001.002.003.004,
+// which net.ParseIP rejects. IPv4 addresses come back in their 4 byte form.
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if parts := strings.Split(s, "."); len(parts) == 4 {
+		ip := make(net.IP, 4)
+		for i, p := range parts {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 0 || n > 255 {
+				return nil
+			}
+			ip[i] = byte(n)
+		}
+		return ip
+	}
+	ip := net.ParseIP(s)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// mergeRanges sorts ranges by their first address and merges the ones that
+// overlap, keeping the description of the first.
+func mergeRanges(ranges []iplist.Range) []iplist.Range {
+	for i := range ranges {
+		if ip4 := ranges[i].First.To4(); ip4 != nil && ranges[i].Last.To4() != nil {
+			ranges[i].First, ranges[i].Last = ip4, ranges[i].Last.To4()
+		}
+	}
+	slices.SortFunc(ranges, func(a, b iplist.Range) int {
+		if len(a.First) != len(b.First) {
+			return len(a.First) - len(b.First)
+		}
+		return bytes.Compare(a.First, b.First)
+	})
+
+	var merged []iplist.Range
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			prev := &merged[n-1]
+			if len(prev.Last) == len(r.First) && bytes.Compare(r.First, prev.Last) <= 0 {
+				if bytes.Compare(r.Last, prev.Last) > 0 {
+					prev.Last = r.Last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
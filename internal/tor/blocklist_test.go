@@ -0,0 +1,108 @@
+package tor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBlocklist = `# P2P format
+Bad Corp, Inc:10.0.0.0-10.0.0.255
+Overlapping:10.0.0.128-10.0.1.10
+// eMule DAT format
+192.168.001.000 - 192.168.001.255 , 000 , Some tracker spy
+172.016.000.000 - 172.016.000.255 , 200 , Allowed range
+not a range at all
+`
+
+func TestParseBlocklistFormats(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testBlocklist))
+	w.Close()
+
+	for name, data := range map[string][]byte{"plain": []byte(testBlocklist), "gzip": gz.Bytes()} {
+		ranges, skipped, err := parseBlocklist(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if skipped != 1 {
+			t.Errorf("%s: skipped %d lines, want 1", name, skipped)
+		}
+		// The overlapping ranges are merged and the allowed one left out
+		if len(ranges) != 2 || !ranges[0].Last.Equal(net.ParseIP("10.0.1.10")) || ranges[1].Description != "Some tracker spy" {
+			t.Errorf("%s: got ranges %v", name, ranges)
+		}
+	}
+}
+
+func TestBlocklistReloadCountsHits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.p2p")
+	if err := os.WriteFile(path, []byte(testBlocklist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBlocklist(path)
+	if err := b.load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"10.0.0.1", "10.0.1.5", "192.168.1.77", "172.16.0.1", "8.8.8.8"} {
+		b.Lookup(net.ParseIP(ip))
+	}
+	stats := b.stats()
+	if stats.Hits != 3 || stats.ByRange["Bad Corp, Inc"] != 2 || stats.ByRange["Some tracker spy"] != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	os.WriteFile(path, []byte("Other:8.8.8.0-8.8.8.255\n"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if !b.changed() {
+		t.Fatal("change to the blocklist file not noticed")
+	}
+	if err := b.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, blocked := b.Lookup(net.ParseIP("10.0.0.1")); blocked {
+		t.Error("range of the old list still blocked after a reload")
+	}
+	if r, blocked := b.Lookup(net.ParseIP("8.8.8.8")); !blocked || !strings.HasPrefix(r.Description, "Other") {
+		t.Error("range of the new list not blocked after a reload")
+	}
+	if stats := b.stats(); stats.Hits != 1 || stats.Ranges != 1 {
+		t.Errorf("got stats %+v after a reload", stats)
+	}
+}
+
+func TestGetStatsReportsBlocklistHits(t *testing.T) {
+	dataDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "blocklist.p2p")
+	if err := os.WriteFile(path, []byte(testBlocklist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTestTorrent(t, dataDir)
+	tr.blocklist = newBlocklist(path)
+	if err := tr.blocklist.load(); err != nil {
+		t.Fatal(err)
+	}
+	_, mi := writeTestVideo(t, dataDir, "video.mp4", 64*1024, 16*1024)
+	if _, err := tr.AddMetainfo("video", mi); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{"10.0.0.1", "192.168.1.77", "8.8.8.8"} {
+		tr.blocklist.Lookup(net.ParseIP(ip))
+	}
+	stats, err := tr.GetStats("video", MainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BlocklistHits != 2 {
+		t.Errorf("got %d blocklist hits, want 2", stats.BlocklistHits)
+	}
+}
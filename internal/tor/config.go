@@ -0,0 +1,207 @@
+package tor
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config controls the torrent client and how long torrents are kept around.
+type Config struct {
+	// Port is the peer listen port of the client.
+	Port int
+	// DataDir is where torrent data is stored while streaming. It is kept
+	// apart from the library so evictions never touch saved videos.
+	DataDir string
+	// Storage is where torrent data goes, StorageDisk (default) or
+	// StorageMemory. Metadata and sessions still live in DataDir either way.
+	Storage string
+	// MemoryCache caps the bytes of torrent data held by StorageMemory.
+	MemoryCache int64
+
+	// IdleTimeout drops torrents that have had no readers for this long.
+	// Zero keeps idle torrents forever.
+	IdleTimeout time.Duration
+	// MaxActive caps the number of active torrents. Zero means no cap.
+	MaxActive int
+	// EvictLRU makes a full client evict its least recently used idle torrent
+	// instead of rejecting the new one with ErrTooManyTorrents.
+	EvictLRU bool
+	// DiskBudget caps the bytes kept in DataDir. Zero means no cap.
+	DiskBudget int64
+
+	// MetadataTimeout is how long a magnet link may take to resolve its
+	// metadata before it is given up. Zero waits forever.
+	MetadataTimeout time.Duration
+
+	// DownloadLimit and UploadLimit cap the transfer rates of the whole
+	// client in bytes per second. Zero means unlimited. Both can be changed
+	// at runtime, see SetLimits.
+	DownloadLimit int64
+	UploadLimit   int64
+
+	// Encryption is the peer connection encryption policy, EncryptionPrefer
+	// (default) or EncryptionRequire.
+	Encryption string
+	// Proxy is a socks5:// URL that peer, tracker and web seed connections go
+	// through. Traffic that a SOCKS5 proxy cannot carry is turned off with it,
+	// see applyProxy.
+	Proxy string
+	// Blocklist is a file of IP ranges never to connect to, in the P2P or
+	// eMule DAT format and optionally gzipped. It is reloaded when it changes.
+	Blocklist string
+
+	// Trackers are announced to for every torrent that is not private, on
+	// top of its own trackers.
+	Trackers []string
+
+	// SessionFile is where the registered ids are saved so they can be
+	// restored on the next start. Empty disables persistence.
+	SessionFile string
+	// ShareFile is where shared files are saved so they are seeded again on
+	// the next start. Empty disables persistence.
+	ShareFile string
+}
+
+// DefaultConfig builds the client configuration from the environment:
+//
+//	DOWNLOAD_PATH            library directory, torrent data goes in DOWNLOAD_PATH/.torrents
+//	TORRENT_DATA_PATH        overrides the torrent data directory
+//	TORRENT_STORAGE          "disk" (default) or "memory" to never write torrent data to disk
+//	TORRENT_MEMORY_CACHE_MB  size of the piece cache of the memory storage, 256 by default
+//	TORRENT_IDLE_TIMEOUT     e.g. "30m", "0" disables idle eviction
+//	TORRENT_MAX_ACTIVE       maximum number of active torrents, 0 for no limit
+//	TORRENT_FULL_POLICY      "reject" (default) or "evict" when MaxActive is reached
+//	TORRENT_DISK_BUDGET_MB   maximum size of the torrent data directory
+//	TORRENT_METADATA_TIMEOUT e.g. "2m", "0" waits for metadata forever
+//	TORRENT_DOWNLOAD_LIMIT_KB download limit in KiB/s, 0 for no limit
+//	TORRENT_UPLOAD_LIMIT_KB   upload limit in KiB/s, 0 for no limit
+//	TORRENT_TRACKERS         comma separated default trackers, empty for none
+//	TORRENT_ENCRYPTION       "prefer" (default) or "require"
+//	TORRENT_PROXY            socks5://[user:pass@]host:port for all peer and tracker traffic
+//	TORRENT_BLOCKLIST        path of an IP blocklist file
+func DefaultConfig(port int) Config {
+	dataDir := os.Getenv("TORRENT_DATA_PATH")
+	if dataDir == "" {
+		dataDir = filepath.Join(downloadDir(), ".torrents")
+	}
+
+	return Config{
+		Port:        port,
+		DataDir:     dataDir,
+		Storage:     os.Getenv("TORRENT_STORAGE"),
+		MemoryCache: int64(envInt("TORRENT_MEMORY_CACHE_MB", 256)) * 1024 * 1024,
+		IdleTimeout: envDuration("TORRENT_IDLE_TIMEOUT", 30*time.Minute),
+		MaxActive:   envInt("TORRENT_MAX_ACTIVE", 0),
+		EvictLRU:    os.Getenv("TORRENT_FULL_POLICY") == "evict",
+		DiskBudget:  int64(envInt("TORRENT_DISK_BUDGET_MB", 0)) * 1024 * 1024,
+
+		MetadataTimeout: envDuration("TORRENT_METADATA_TIMEOUT", 2*time.Minute),
+
+		DownloadLimit: int64(envInt("TORRENT_DOWNLOAD_LIMIT_KB", 0)) * 1024,
+		UploadLimit:   int64(envInt("TORRENT_UPLOAD_LIMIT_KB", 0)) * 1024,
+
+		Encryption: os.Getenv("TORRENT_ENCRYPTION"),
+		Proxy:      os.Getenv("TORRENT_PROXY"),
+		Blocklist:  os.Getenv("TORRENT_BLOCKLIST"),
+
+		Trackers: envTrackers("TORRENT_TRACKERS", defaultTrackers),
+	}
+}
+
+// WorkerConfig builds the client configuration of the download worker. It
+// reads the same environment as DefaultConfig, except that:
+//
+//	TORRENT_WORKER_DATA_PATH overrides the worker data directory, DOWNLOAD_PATH/.worker-torrents by default
+//
+// The API deletes data in its data dir that its own torrents do not hold, so
+// the worker must never share it. Jobs remove the torrents they add along
+// with their data, and a save job must not lose its torrent halfway through,
+// so the worker neither evicts nor limits torrents. Saving reads whole
+// videos, so data always goes to disk.
+func WorkerConfig(port int) Config {
+	c := DefaultConfig(port)
+
+	c.DataDir = os.Getenv("TORRENT_WORKER_DATA_PATH")
+	if c.DataDir == "" {
+		c.DataDir = filepath.Join(downloadDir(), ".worker-torrents")
+	}
+	c.Storage = StorageDisk
+	c.IdleTimeout = 0
+	c.MaxActive = 0
+	c.EvictLRU = false
+	c.DiskBudget = 0
+	return c
+}
+
+// downloadDir resolves the library directory the same way storage.New does.
+func downloadDir() string {
+	// Try environment override first (for flexibility)
+	dataDir := os.Getenv("DOWNLOAD_PATH")
+	if dataDir == "" {
+		// Default to Docker path if not specified
+		dataDir = "/app/fluxstream/download"
+
+		// Fallback for local dev environments
+		if _, err := os.Stat(dataDir); os.IsNotExist(err) {
+			if home, err := os.UserHomeDir(); err == nil {
+				dataDir = filepath.Join(home, ".local", "share", "fluxstream", "downloads")
+			}
+		}
+	}
+	return dataDir
+}
+
+func envInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("[Config] invalid %s=%q, using %d: %v", key, raw, def, err)
+		return def
+	}
+	return v
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	if raw == "0" {
+		return 0
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("[Config] invalid %s=%q, using %s: %v", key, raw, def, err)
+		return def
+	}
+	return v
+}
+
+// envTrackers reads a comma separated tracker list. Unlike the other
+// settings, a variable that is set but empty disables the defaults.
+func envTrackers(key string, def []string) []string {
+	raw, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	var list []string
+	for _, u := range strings.Split(raw, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			list = append(list, u)
+		}
+	}
+	trackers, err := validTrackers(list)
+	if err != nil {
+		log.Printf("[Config] invalid %s, using the defaults: %v", key, err)
+		return def
+	}
+	return trackers
+}
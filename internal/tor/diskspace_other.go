@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package tor
+
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, ErrDiskSpaceUnsupported
+}
@@ -0,0 +1,41 @@
+package tor
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Engine is what the server and worker need to stream and save videos: adding
+// torrents, reading their files and describing them, exporting them and
+// cleaning them up. *Torrent implements it on top of the anacrolix client;
+// tortest.Engine implements it in memory for tests.
+type Engine interface {
+	// AddMagnet registers a magnet link under id, see Torrent.AddMagnet.
+	AddMagnet(id, magnetLink string, trackers ...string) (string, error)
+	// AddMetainfo registers a .torrent under id, see Torrent.AddMetainfo.
+	AddMetainfo(id string, mi *metainfo.MetaInfo, trackers ...string) (string, error)
+	// Has reports whether id is registered.
+	Has(id string) bool
+	// State reports how far id has come in resolving its metadata.
+	State(id string) (State, error)
+
+	// GetReader and GetFileReader return a reader over the main video file
+	// or the file at index, nil if there is none. The caller closes it.
+	GetReader(id string) *torrent.Reader
+	GetFileReader(id string, index int) *torrent.Reader
+
+	// GetMetadata and GetFileMetadata describe the main video file or the
+	// file at index, GetFiles every file in torrent order.
+	GetMetadata(id string) (*FileMetadata, error)
+	GetFileMetadata(id string, index int) (*FileMetadata, error)
+	GetFiles(id string) ([]FileMetadata, error)
+
+	// GetMagnetLink and GetMetainfo export the torrent of id.
+	GetMagnetLink(id string) *string
+	GetMetainfo(id string) (*metainfo.MetaInfo, error)
+
+	// CleanupTorrent unregisters id.
+	CleanupTorrent(id string) error
+}
+
+var _ Engine = (*Torrent)(nil)
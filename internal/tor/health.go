@@ -0,0 +1,61 @@
+package tor
+
+import (
+	"errors"
+
+	"github.com/anacrolix/dht/v2"
+)
+
+// ErrDiskSpaceUnsupported is returned by DiskSpace on systems it cannot query.
+var ErrDiskSpaceUnsupported = errors.New("disk space is not reported on this system")
+
+// Health describes whether the client can take part in swarms: the addresses
+// it accepts peers on and what its DHT servers know of the network.
+type Health struct {
+	ListenAddrs []string `json:"listen_addrs"`
+	// DHT is false when the DHT is disabled, e.g. behind a proxy.
+	DHT          bool `json:"dht"`
+	DHTNodes     int  `json:"dht_nodes"`
+	DHTGoodNodes int  `json:"dht_good_nodes"`
+	Torrents     int  `json:"torrents"`
+}
+
+// Listening reports whether the client has bound its listen port.
+func (h Health) Listening() bool {
+	return len(h.ListenAddrs) > 0
+}
+
+// Health probes the client. It does not touch the network.
+func (tr *Torrent) Health() Health {
+	var h Health
+	for _, addr := range tr.cl.ListenAddrs() {
+		h.ListenAddrs = append(h.ListenAddrs, addr.String())
+	}
+
+	for _, s := range tr.cl.DhtServers() {
+		h.DHT = true
+		if stats, ok := s.Stats().(dht.ServerStats); ok {
+			h.DHTNodes += stats.Nodes
+			h.DHTGoodNodes += stats.GoodNodes
+		}
+	}
+
+	h.Torrents = len(tr.cl.Torrents())
+	return h
+}
+
+// DiskSpace is the space on the volume holding Config.DataDir, in bytes.
+type DiskSpace struct {
+	Path  string `json:"path"`
+	Free  uint64 `json:"free"` // Available to the process, not to root
+	Total uint64 `json:"total"`
+}
+
+// DiskSpace returns the space on the volume the torrent data is written to.
+func (tr *Torrent) DiskSpace() (DiskSpace, error) {
+	free, total, err := diskSpace(tr.cfg.DataDir)
+	if err != nil {
+		return DiskSpace{Path: tr.cfg.DataDir}, err
+	}
+	return DiskSpace{Path: tr.cfg.DataDir, Free: free, Total: total}, nil
+}
@@ -0,0 +1,23 @@
+package tor
+
+import "testing"
+
+func TestHealth(t *testing.T) {
+	tr := newTestTorrent(t, t.TempDir())
+
+	h := tr.Health()
+	if !h.Listening() {
+		t.Errorf("expected the client to listen, got %+v", h)
+	}
+	if h.DHT {
+		t.Errorf("expected no DHT with NoDHT set, got %+v", h)
+	}
+
+	space, err := tr.DiskSpace()
+	if err != nil {
+		t.Fatalf("DiskSpace: %v", err)
+	}
+	if space.Total == 0 || space.Free > space.Total {
+		t.Errorf("implausible disk space %+v", space)
+	}
+}
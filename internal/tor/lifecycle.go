@@ -0,0 +1,202 @@
+package tor
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for ids that were never added or were cleaned up.
+	ErrNotFound = errors.New("torrent not found")
+	// ErrEvicted is returned for ids dropped by the lifecycle manager.
+	ErrEvicted = errors.New("torrent evicted")
+	// ErrTooManyTorrents is returned when MaxActive is reached and nothing
+	// can be evicted.
+	ErrTooManyTorrents = errors.New("too many active torrents")
+)
+
+const (
+	// droppedRetention is how long an evicted or unresolved id keeps
+	// reporting why it was dropped before it is treated as unknown.
+	droppedRetention = 24 * time.Hour
+	// maxSweepInterval bounds how late an idle torrent can be noticed.
+	maxSweepInterval = time.Minute
+)
+
+// Evicted reports whether videoId was dropped by the lifecycle manager, so
+// callers can tell clients to add it again rather than that it never existed.
+func (tr *Torrent) Evicted(videoId string) bool {
+	return errors.Is(tr.tor.droppedErr(videoId, droppedRetention), ErrEvicted)
+}
+
+// lookupErr explains why videoId is not active.
+func (tr *Torrent) lookupErr(videoId string) error {
+	if err := tr.tor.droppedErr(videoId, droppedRetention); err != nil {
+		return err
+	}
+	return ErrNotFound
+}
+
+// manageLifecycle periodically applies the idle timeout and the disk budget,
+// and forgets state kept for torrents that have been dropped.
+func (tr *Torrent) manageLifecycle() {
+	interval := maxSweepInterval
+	if tr.cfg.IdleTimeout > 0 && tr.cfg.IdleTimeout/4 < interval {
+		interval = tr.cfg.IdleTimeout / 4
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tr.sweep()
+		case <-tr.cl.Closed():
+			return
+		}
+	}
+}
+
+func (tr *Torrent) sweep() {
+	if tr.cfg.IdleTimeout > 0 {
+		for _, id := range tr.tor.evictIdle(time.Now().Add(-tr.cfg.IdleTimeout)) {
+			log.Printf("[Lifecycle] evicted idle torrent for id: %s", id)
+		}
+	}
+
+	if tr.cfg.DiskBudget > 0 {
+		tr.enforceDiskBudget()
+	}
+
+	tr.tor.pruneDropped(droppedRetention)
+	tr.rates.forget(tr.tor.held())
+	if tr.tor.changed() {
+		tr.saveSessions()
+	}
+}
+
+// dataEntry is a top-level file or directory in the data dir. The anacrolix
+// file storage puts each torrent under its info name.
+type dataEntry struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// enforceDiskBudget first deletes data left behind by torrents that are no
+// longer active, oldest first, then evicts idle torrents in LRU order and
+// deletes their data until the data dir fits in the budget.
+func (tr *Torrent) enforceDiskBudget() {
+	entries, err := scanDataDir(tr.cfg.DataDir)
+	if err != nil {
+		log.Printf("[Lifecycle] failed to scan data dir %s: %v", tr.cfg.DataDir, err)
+		return
+	}
+
+	var usage int64
+	sizes := make(map[string]int64, len(entries))
+	for _, e := range entries {
+		usage += e.size
+		sizes[e.name] = e.size
+	}
+	if usage <= tr.cfg.DiskBudget {
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, t := range tr.tor.held() {
+		active[t.Name()] = true
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries {
+		if usage <= tr.cfg.DiskBudget {
+			return
+		}
+		if active[e.name] {
+			continue
+		}
+		if err := tr.removeData(e.name); err != nil {
+			continue
+		}
+		usage -= e.size
+	}
+
+	for usage > tr.cfg.DiskBudget {
+		id, t, ok := tr.tor.evictLRU()
+		if !ok {
+			log.Printf("[Lifecycle] data dir uses %d bytes over a %d byte budget, but every torrent is being streamed", usage, tr.cfg.DiskBudget)
+			return
+		}
+		log.Printf("[Lifecycle] evicted torrent for id %s to stay within the disk budget", id)
+
+		if tr.tor.inUse(t) {
+			continue
+		}
+		name := t.Name()
+		if err := tr.removeData(name); err != nil {
+			continue
+		}
+		usage -= sizes[name]
+	}
+}
+
+func (tr *Torrent) removeData(name string) error {
+	path := filepath.Join(tr.cfg.DataDir, name)
+	if err := os.RemoveAll(path); err != nil {
+		log.Printf("[Lifecycle] failed to remove %s: %v", path, err)
+		return err
+	}
+	log.Printf("[Lifecycle] removed torrent data %s", path)
+	return nil
+}
+
+// scanDataDir sizes every top-level entry of dir. Dot entries hold client
+// state such as the piece completion database and are skipped.
+func scanDataDir(dir string) ([]dataEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []dataEntry
+	for _, de := range dirEntries {
+		if strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		e := dataEntry{name: de.Name(), size: info.Size(), modTime: info.ModTime()}
+		if de.IsDir() {
+			e.size = 0
+			filepath.WalkDir(filepath.Join(dir, de.Name()), func(_ string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				if fi, err := d.Info(); err == nil {
+					e.size += fi.Size()
+					if fi.ModTime().After(e.modTime) {
+						e.modTime = fi.ModTime()
+					}
+				}
+				return nil
+			})
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,183 @@
+package tor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// addTestTorrents writes a video per name into dataDir and adds it to the
+// client of tr, without registering it.
+func addTestTorrents(t *testing.T, tr *Torrent, dataDir string, names ...string) []*torrent.Torrent {
+	t.Helper()
+
+	var list []*torrent.Torrent
+	for _, name := range names {
+		_, mi := writeTestVideo(t, dataDir, name, 64*1024, 16*1024)
+		tt, err := tr.cl.AddTorrent(mi)
+		if err != nil {
+			t.Fatalf("add %s: %v", name, err)
+		}
+		list = append(list, tt)
+	}
+	return list
+}
+
+func TestRegistryAddWhenFull(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	ts := addTestTorrents(t, tr, dataDir, "a.mp4", "b.mp4", "c.mp4", "d.mp4")
+	rg := newRegistry()
+
+	for i, id := range []string{"a", "b"} {
+		if _, _, err := rg.add(id, "", ts[i], 2, false); err != nil {
+			t.Fatalf("add %s: %v", id, err)
+		}
+	}
+
+	if _, _, err := rg.add("c", "", ts[2], 2, false); !errors.Is(err, ErrTooManyTorrents) {
+		t.Fatalf("add to a full registry: got %v, want ErrTooManyTorrents", err)
+	}
+
+	// a was used after b was added, so b is the least recently used
+	e, _ := rg.acquire("a")
+	rg.release(e)
+	if _, _, err := rg.add("c", "", ts[2], 2, true); err != nil {
+		t.Fatalf("add with LRU eviction: %v", err)
+	}
+	if _, ok := rg.lookup("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if err := rg.droppedErr("b", time.Hour); !errors.Is(err, ErrEvicted) {
+		t.Errorf("evicted id reports %v, want ErrEvicted", err)
+	}
+	if _, ok := rg.lookup("a"); !ok {
+		t.Error("the recently used a was evicted")
+	}
+
+	// Ids being streamed are never evicted
+	for _, id := range []string{"a", "c"} {
+		if _, ok := rg.acquire(id); !ok {
+			t.Fatalf("acquire %s", id)
+		}
+	}
+	if _, _, err := rg.add("d", "", ts[3], 2, true); !errors.Is(err, ErrTooManyTorrents) {
+		t.Errorf("add while every id is streamed: got %v, want ErrTooManyTorrents", err)
+	}
+}
+
+func TestSweepEvictsIdleTorrents(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	tr.cfg.IdleTimeout = time.Minute
+
+	for _, name := range []string{"idle", "streamed", "recent"} {
+		_, mi := writeTestVideo(t, dataDir, name+".mp4", 64*1024, 16*1024)
+		if _, err := tr.AddMetainfo(name, mi); err != nil {
+			t.Fatalf("add %s: %v", name, err)
+		}
+	}
+	reader := tr.GetReader("streamed")
+	if reader == nil {
+		t.Fatal("no reader")
+	}
+	defer (*reader).Close()
+
+	for _, id := range []string{"idle", "streamed"} {
+		e, _ := tr.tor.lookup(id)
+		tr.tor.mu.Lock()
+		e.lastUsed = time.Now().Add(-time.Hour)
+		tr.tor.mu.Unlock()
+	}
+	tr.sweep()
+
+	if tr.Has("idle") || !tr.Evicted("idle") {
+		t.Error("expected the idle torrent to be evicted")
+	}
+	if _, err := tr.GetFileMetadata("idle", MainFile); !errors.Is(err, ErrEvicted) {
+		t.Errorf("metadata of an evicted id: got %v, want ErrEvicted", err)
+	}
+	for _, id := range []string{"streamed", "recent"} {
+		if !tr.Has(id) {
+			t.Errorf("%s was evicted", id)
+		}
+	}
+}
+
+func TestEnforceDiskBudget(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+
+	// Left behind by a torrent that is gone
+	leftover := filepath.Join(dataDir, "leftover.mp4")
+	if err := os.WriteFile(leftover, make([]byte, 64*1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(leftover, old, old)
+
+	for _, name := range []string{"idle", "streamed"} {
+		_, mi := writeTestVideo(t, dataDir, name+".mp4", 64*1024, 16*1024)
+		if _, err := tr.AddMetainfo(name, mi); err != nil {
+			t.Fatalf("add %s: %v", name, err)
+		}
+	}
+	reader := tr.GetReader("streamed")
+	if reader == nil {
+		t.Fatal("no reader")
+	}
+	defer (*reader).Close()
+
+	// Removing the leftover data alone does not fit two videos in the budget
+	tr.cfg.DiskBudget = 100 * 1024
+	tr.enforceDiskBudget()
+
+	for _, name := range []string{"leftover.mp4", "idle.mp4"} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	if !tr.Evicted("idle") {
+		t.Error("expected the idle torrent to be evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "streamed.mp4")); err != nil {
+		t.Errorf("data of the streamed torrent was removed: %v", err)
+	}
+	if !tr.Has("streamed") {
+		t.Error("the streamed torrent was evicted")
+	}
+}
+
+func TestSweepSavesOnlyChangedSessions(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	tr.sessions = &sessionStore{path: path}
+
+	_, mi := writeTestVideo(t, dataDir, "video.mp4", 64*1024, 16*1024)
+	id, err := tr.AddMetainfo("video", mi)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("sessions not saved on add: %v", err)
+	}
+
+	os.Remove(path)
+	tr.sweep()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("sweep saved unchanged sessions: %v", err)
+	}
+
+	// A change that is not saved right away is saved by the next sweep
+	e, _ := tr.tor.lookup(id)
+	tr.tor.selectFile(e, 0)
+	tr.sweep()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("sweep did not save changed sessions: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package tor
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Storage backends for torrent data.
+const (
+	// StorageDisk writes pieces to Config.DataDir.
+	StorageDisk = "disk"
+	// StorageMemory keeps pieces in a bounded in-memory cache and never writes
+	// them to disk. Pieces that were played or fetched long ago are evicted
+	// and downloaded again if they are needed.
+	StorageMemory = "memory"
+)
+
+// errPieceEvicted is returned when reading a piece whose data was evicted. The
+// client then marks the piece incomplete and downloads it again.
+var errPieceEvicted = errors.New("piece evicted from memory cache")
+
+type memoryPieceKey struct {
+	hash  metainfo.Hash
+	index int
+}
+
+// memoryPiece is the data of one piece. elem is its place in the LRU list.
+type memoryPiece struct {
+	key      memoryPieceKey
+	data     []byte
+	complete bool
+	elem     *list.Element
+}
+
+// memoryStorage keeps the pieces of every torrent in RAM, up to capacity bytes
+// in total. Reads and writes move a piece to the front of an LRU list, so the
+// pieces around where playback is reading stay while the rest are evicted.
+type memoryStorage struct {
+	capacity int64
+	// capFunc is shared by every torrent so the client budgets the capacity
+	// across all of them.
+	capFunc func() (int64, bool)
+
+	mu     sync.Mutex
+	used   int64
+	pieces map[memoryPieceKey]*memoryPiece
+	lru    list.List // Front is the most recently used
+}
+
+func newMemoryStorage(capacity int64) *memoryStorage {
+	s := &memoryStorage{capacity: capacity, pieces: make(map[memoryPieceKey]*memoryPiece)}
+	s.capFunc = func() (int64, bool) { return s.capacity, true }
+	return s
+}
+
+// OpenTorrent implements storage.ClientImpl.
+func (s *memoryStorage) OpenTorrent(_ context.Context, _ *metainfo.Info, hash metainfo.Hash) (storage.TorrentImpl, error) {
+	return storage.TorrentImpl{
+		Piece: func(p metainfo.Piece) storage.PieceImpl {
+			return &memoryPieceImpl{s: s, key: memoryPieceKey{hash, p.Index()}, length: p.Length()}
+		},
+		Close: func() error {
+			s.drop(hash)
+			return nil
+		},
+		Capacity: &s.capFunc,
+	}, nil
+}
+
+// Close implements storage.ClientImplCloser.
+func (s *memoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pieces = make(map[memoryPieceKey]*memoryPiece)
+	s.lru.Init()
+	s.used = 0
+	return nil
+}
+
+// drop frees every piece of the torrent with hash.
+func (s *memoryStorage) drop(hash metainfo.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, p := range s.pieces {
+		if key.hash == hash {
+			s.removeLocked(p)
+		}
+	}
+}
+
+// inUse returns how many bytes of piece data are held.
+func (s *memoryStorage) inUse() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// getLocked returns the piece for key and marks it used, allocating it first
+// if create is set. Allocating evicts the least recently used pieces until the
+// new one fits. mu must be held.
+func (s *memoryStorage) getLocked(key memoryPieceKey, length int64, create bool) *memoryPiece {
+	p, ok := s.pieces[key]
+	if ok {
+		s.lru.MoveToFront(p.elem)
+		return p
+	}
+	if !create {
+		return nil
+	}
+
+	for s.used+length > s.capacity && s.lru.Len() > 0 {
+		s.removeLocked(s.lru.Back().Value.(*memoryPiece))
+	}
+	p = &memoryPiece{key: key, data: make([]byte, length)}
+	p.elem = s.lru.PushFront(p)
+	s.pieces[key] = p
+	s.used += length
+	return p
+}
+
+func (s *memoryStorage) removeLocked(p *memoryPiece) {
+	s.lru.Remove(p.elem)
+	delete(s.pieces, p.key)
+	s.used -= int64(len(p.data))
+}
+
+// memoryPieceImpl is the client's handle on a piece. The client asks for
+// handles often, so they hold no data themselves.
+type memoryPieceImpl struct {
+	s      *memoryStorage
+	key    memoryPieceKey
+	length int64
+}
+
+func (p *memoryPieceImpl) ReadAt(b []byte, off int64) (int, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	piece := p.s.getLocked(p.key, p.length, false)
+	if piece == nil {
+		return 0, errPieceEvicted
+	}
+	if off >= int64(len(piece.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, piece.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (p *memoryPieceImpl) WriteAt(b []byte, off int64) (int, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	piece := p.s.getLocked(p.key, p.length, true)
+	if off >= int64(len(piece.data)) {
+		return 0, io.ErrShortWrite
+	}
+	n := copy(piece.data[off:], b)
+	if n < len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (p *memoryPieceImpl) MarkComplete() error {
+	return p.setComplete(true)
+}
+
+func (p *memoryPieceImpl) MarkNotComplete() error {
+	return p.setComplete(false)
+}
+
+func (p *memoryPieceImpl) setComplete(complete bool) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if piece, ok := p.s.pieces[p.key]; ok {
+		piece.complete = complete
+	} else if complete {
+		return errPieceEvicted
+	}
+	return nil
+}
+
+// Completion reports evicted pieces as known to be incomplete, which is how
+// the client learns that it has to download them again.
+func (p *memoryPieceImpl) Completion() storage.Completion {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	piece, ok := p.s.pieces[p.key]
+	return storage.Completion{Ok: true, Complete: ok && piece.complete}
+}
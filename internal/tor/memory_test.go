@@ -0,0 +1,98 @@
+package tor
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestMemoryStorageStreamsWithinCapacity(t *testing.T) {
+	seedDir := t.TempDir()
+	seeder := newTestTorrent(t, seedDir)
+	data, mi := writeTestVideo(t, seedDir, "video.mp4", 2<<20, 64<<10)
+	if _, err := seeder.AddMetainfo("seed", mi); err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for 8 of the 32 pieces
+	const capacity = 512 << 10
+	memory := newMemoryStorage(capacity)
+	leechDir := t.TempDir()
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = leechDir
+	cfg.DefaultStorage = memory
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.DisableIPv6 = true
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	leecher := newTorrent(cl, Config{DataDir: leechDir, Storage: StorageMemory, MemoryCache: capacity})
+	leecher.memory = memory
+
+	id, err := leecher.AddMetainfo("leech", mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt, _ := leecher.tor.get(id)
+	lt.AddClientPeer(seeder.cl)
+
+	reader := leecher.GetReader(id)
+	if reader == nil {
+		t.Fatal("no reader")
+	}
+	defer (*reader).Close()
+
+	done := make(chan []byte)
+	go func() {
+		var got bytes.Buffer
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := (*reader).Read(buf)
+			got.Write(buf[:n])
+			if used := memory.inUse(); used > capacity {
+				t.Errorf("cache holds %d bytes, over its %d byte capacity", used, capacity)
+			}
+			if err != nil {
+				break
+			}
+		}
+		done <- got.Bytes()
+	}()
+	select {
+	case got := <-done:
+		if !bytes.Equal(got, data) {
+			t.Fatal("read different data through the memory cache")
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("timed out streaming through the memory cache")
+	}
+
+	// Going back to the start needs the evicted pieces again. Both sides
+	// looked complete, so they have hung up on each other by now
+	lt.AddClientPeer(seeder.cl)
+	if _, err := (*reader).Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 64<<10)
+	if _, err := io.ReadFull(*reader, head); err != nil || !bytes.Equal(head, data[:len(head)]) {
+		t.Fatalf("reread of an evicted piece: %v", err)
+	}
+
+	entries, err := os.ReadDir(leechDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == "video.mp4" {
+			t.Error("memory storage wrote torrent data to disk")
+		}
+	}
+}
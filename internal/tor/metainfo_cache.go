@@ -0,0 +1,202 @@
+package tor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// metainfoDirName is the cache directory inside DataDir. The leading dot keeps
+// it out of the disk budget accounting.
+const metainfoDirName = ".metainfo"
+
+// CachedMetainfo describes a metainfo file kept by the cache.
+type CachedMetainfo struct {
+	InfoHash string    `json:"info_hash"`
+	Name     string    `json:"name"`
+	Length   int64     `json:"length"` // Total size of the torrent's files
+	Size     int64     `json:"size"`   // Size of the cached .torrent file
+	LastUsed time.Time `json:"last_used"`
+}
+
+// metainfoCache stores the metainfo of resolved torrents as .torrent files
+// named after their info-hash, so a magnet link seen before resolves without
+// asking peers for the info dictionary. A file's modification time is bumped
+// every time it is loaded and serves as its last use.
+type metainfoCache struct {
+	dir string
+}
+
+func newMetainfoCache(dataDir string) *metainfoCache {
+	return &metainfoCache{dir: filepath.Join(dataDir, metainfoDirName)}
+}
+
+func (c *metainfoCache) path(hash metainfo.Hash) string {
+	return filepath.Join(c.dir, hash.HexString()+".torrent")
+}
+
+// load returns the cached metainfo for hash. Entries that fail to decode or
+// do not match their info-hash are removed.
+func (c *metainfoCache) load(hash metainfo.Hash) (*metainfo.MetaInfo, bool) {
+	path := c.path(hash)
+	mi, err := metainfo.LoadFromFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[MetainfoCache] dropping unreadable %s: %v", path, err)
+			os.Remove(path)
+		}
+		return nil, false
+	}
+	if mi.HashInfoBytes() != hash {
+		log.Printf("[MetainfoCache] dropping %s, its info does not match the info-hash", path)
+		os.Remove(path)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return mi, true
+}
+
+// store writes the metainfo of t, whose info must be known.
+func (c *metainfoCache) store(t *torrent.Torrent) {
+	mi := t.Metainfo()
+
+	var buf bytes.Buffer
+	if err := mi.Write(&buf); err != nil {
+		log.Printf("[MetainfoCache] failed to encode metainfo of %s: %v", t.InfoHash().HexString(), err)
+		return
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		log.Printf("[MetainfoCache] failed to create %s: %v", c.dir, err)
+		return
+	}
+
+	// Write then rename so a crash never leaves a truncated entry behind
+	path := c.path(t.InfoHash())
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		log.Printf("[MetainfoCache] failed to write %s: %v", path, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		log.Printf("[MetainfoCache] failed to write %s: %v", path, err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.Printf("[MetainfoCache] failed to write %s: %v", path, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Printf("[MetainfoCache] failed to write %s: %v", path, err)
+	}
+}
+
+// list returns every cached entry, most recently used first.
+func (c *metainfoCache) list() ([]CachedMetainfo, error) {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []CachedMetainfo{}, nil
+		}
+		return nil, err
+	}
+
+	list := make([]CachedMetainfo, 0, len(dirEntries))
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".torrent" {
+			continue
+		}
+
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entry := CachedMetainfo{
+			InfoHash: strings.TrimSuffix(name, ".torrent"),
+			Size:     fi.Size(),
+			LastUsed: fi.ModTime(),
+		}
+
+		if mi, err := metainfo.LoadFromFile(filepath.Join(c.dir, name)); err == nil {
+			var info metainfo.Info
+			if err := bencode.Unmarshal(mi.InfoBytes, &info); err == nil {
+				entry.Name = info.BestName()
+				entry.Length = info.TotalLength()
+			}
+		}
+		list = append(list, entry)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.After(list[j].LastUsed) })
+	return list, nil
+}
+
+// remove deletes the entry for hash.
+func (c *metainfoCache) remove(hash metainfo.Hash) error {
+	return os.Remove(c.path(hash))
+}
+
+// prune deletes entries last used before cutoff and returns how many were
+// deleted.
+func (c *metainfoCache) prune(cutoff time.Time) (int, error) {
+	list, err := c.list()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, e := range list {
+		if !e.LastUsed.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, e.InfoHash+".torrent")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// CachedMetainfo lists the metainfo cache, most recently used first.
+func (tr *Torrent) CachedMetainfo() ([]CachedMetainfo, error) {
+	return tr.infoCache.list()
+}
+
+// DeleteCachedMetainfo removes the cached metainfo for a hex info-hash. It
+// returns ErrNotFound if nothing is cached for it.
+func (tr *Torrent) DeleteCachedMetainfo(infoHash string) error {
+	var hash metainfo.Hash
+	if err := hash.FromHexString(infoHash); err != nil {
+		return fmt.Errorf("invalid info-hash %q: %w", infoHash, err)
+	}
+
+	err := tr.infoCache.remove(hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w for info-hash: %s", ErrNotFound, infoHash)
+	}
+	return err
+}
+
+// PruneCachedMetainfo removes cached metainfo that has not been used for
+// olderThan, or every entry when olderThan is zero, and returns how many
+// entries were removed.
+func (tr *Torrent) PruneCachedMetainfo(olderThan time.Duration) (int, error) {
+	return tr.infoCache.prune(time.Now().Add(-olderThan))
+}
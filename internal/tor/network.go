@@ -0,0 +1,78 @@
+package tor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/mse"
+	"golang.org/x/net/proxy"
+)
+
+// Encryption policies for peer connections.
+const (
+	// EncryptionPrefer obfuscates headers when the peer supports it and falls
+	// back to plain connections otherwise.
+	EncryptionPrefer = "prefer"
+	// EncryptionRequire only talks to peers that encrypt the whole stream.
+	EncryptionRequire = "require"
+)
+
+// errUDPProxied is returned for UDP tracker announces, which a SOCKS5 proxy
+// cannot carry.
+var errUDPProxied = errors.New("udp trackers are disabled while using a proxy")
+
+// applyEncryption sets the header obfuscation and crypto methods of cfg.
+func applyEncryption(cfg *torrent.ClientConfig, policy string) error {
+	switch policy {
+	case "", EncryptionPrefer:
+		cfg.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true}
+		cfg.CryptoProvides = mse.AllSupportedCrypto
+	case EncryptionRequire:
+		cfg.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: true}
+		cfg.CryptoProvides = mse.CryptoMethodRC4
+		cfg.CryptoSelector = func(provided mse.CryptoMethod) mse.CryptoMethod {
+			// Zero fails the handshake of peers that only offer plaintext
+			return provided & mse.CryptoMethodRC4
+		}
+	default:
+		return fmt.Errorf("unknown encryption policy %q, want %q or %q", policy, EncryptionPrefer, EncryptionRequire)
+	}
+	return nil
+}
+
+// applyProxy sends peer, tracker and web seed connections of cfg through the
+// SOCKS5 proxy at rawURL. Everything that would go around it over UDP is
+// turned off: the DHT, uTP, WebRTC peers and UDP trackers. The returned
+// dialer has to be added to the client for outgoing peer connections.
+func applyProxy(cfg *torrent.ClientConfig, rawURL string) (torrent.Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "socks5" && u.Scheme != "socks5h") {
+		return nil, fmt.Errorf("proxy must be a socks5:// URL, got %q", rawURL)
+	}
+
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	dialer, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("proxy %s does not support contexts", u.Redacted())
+	}
+
+	cfg.DialForPeerConns = false
+	cfg.DisableUTP = true
+	cfg.NoDHT = true
+	cfg.DisableWebtorrent = true
+
+	cfg.HTTPProxy = http.ProxyURL(u)
+	cfg.TrackerDialContext = dialer.DialContext
+	cfg.TrackerListenPacket = func(network, addr string) (net.PacketConn, error) {
+		return nil, errUDPProxied
+	}
+
+	return torrent.NetworkDialer{Network: "tcp", Dialer: dialer}, nil
+}
@@ -0,0 +1,216 @@
+package tor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/scythe504/webtorrent/internal/media"
+)
+
+const (
+	// defaultBitrate is assumed, in bytes per second, until a file's duration
+	// has been probed (roughly 8 Mbit/s).
+	defaultBitrate = 1 << 20
+	// readaheadSeconds is how much playback the reader fetches ahead of the
+	// read position.
+	readaheadSeconds = 30
+	minReadahead     = 4 << 20
+	maxReadahead     = 128 << 20
+	// seekPinSeconds is how much playback after a seek offset is pinned at
+	// the highest priority until it has been read.
+	seekPinSeconds = 4
+	// probeTimeout bounds how long a duration probe may wait for pieces.
+	probeTimeout = 30 * time.Second
+)
+
+// fileKey identifies a file across every id the same torrent is added under.
+type fileKey struct {
+	hash  metainfo.Hash
+	index int
+}
+
+// bitrates caches the estimated bitrate of probed files. A zero value marks a
+// probe in progress.
+type bitrates struct {
+	m sync.Map
+}
+
+// get returns the estimated bitrate of f, starting a background probe the
+// first time f is seen.
+func (b *bitrates) get(key fileKey, f *torrent.File) int64 {
+	v, loaded := b.m.LoadOrStore(key, int64(0))
+	if !loaded {
+		go b.probe(key, f)
+	}
+	if rate := v.(int64); rate > 0 {
+		return rate
+	}
+	return defaultBitrate
+}
+
+// probe estimates the bitrate of f as its size over its probed duration.
+func (b *bitrates) probe(key fileKey, f *torrent.File) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	reader := f.NewReader()
+	defer reader.Close()
+	reader.SetContext(ctx)
+	reader.SetResponsive()
+
+	duration, err := media.Duration(reader)
+	if err != nil || duration < time.Second {
+		log.Printf("[Playback] could not probe duration of %s, assuming %d B/s: %v", f.DisplayPath(), defaultBitrate, err)
+		b.m.Store(key, int64(defaultBitrate))
+		return
+	}
+
+	rate := int64(float64(f.Length()) / duration.Seconds())
+	b.m.Store(key, rate)
+	log.Printf("[Playback] %s runs %s, estimated %d B/s", f.DisplayPath(), duration.Round(time.Second), rate)
+}
+
+// pins reference-counts piece priorities raised by playback readers, so one
+// reader releasing its pins never cancels pieces another reader still wants.
+type pins struct {
+	mu     sync.Mutex
+	counts map[*torrent.Piece]int
+}
+
+func newPins() *pins {
+	return &pins{counts: make(map[*torrent.Piece]int)}
+}
+
+func (p *pins) pin(pieces []*torrent.Piece) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, piece := range pieces {
+		if p.counts[piece] == 0 {
+			piece.SetPriority(torrent.PiecePriorityNow)
+		}
+		p.counts[piece]++
+	}
+}
+
+func (p *pins) unpin(pieces []*torrent.Piece) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, piece := range pieces {
+		p.counts[piece]--
+		if p.counts[piece] <= 0 {
+			delete(p.counts, piece)
+			piece.SetPriority(torrent.PiecePriorityNone)
+		}
+	}
+}
+
+// playbackReader tunes a file reader for media playback. It reads ahead by a
+// window sized from the file's estimated bitrate, returns data before whole
+// pieces are verified, and pins the pieces right after every seek offset to
+// the highest priority. Pins from an abandoned range are released as soon as
+// the reader seeks elsewhere, reads past them or is closed.
+type playbackReader struct {
+	torrent.Reader
+	t       *torrent.Torrent
+	file    *torrent.File
+	pins    *pins
+	bitrate func() int64
+	// maxReadahead caps the readahead window below maxReadahead when set.
+	maxReadahead int64
+
+	pos    int64
+	pinEnd int64 // file offset where the pinned window ends
+	pinned []*torrent.Piece
+}
+
+func newPlaybackReader(t *torrent.Torrent, file *torrent.File, p *pins, bitrate func() int64) *playbackReader {
+	r := &playbackReader{
+		Reader:  file.NewReader(),
+		t:       t,
+		file:    file,
+		pins:    p,
+		bitrate: bitrate,
+	}
+
+	r.Reader.SetResponsive()
+	r.Reader.SetReadaheadFunc(func(torrent.ReadaheadContext) int64 {
+		ahead := readahead(r.bitrate())
+		if r.maxReadahead > 0 {
+			ahead = min(ahead, r.maxReadahead)
+		}
+		return ahead
+	})
+
+	return r
+}
+
+// readahead returns how many bytes to fetch ahead for a given bitrate.
+func readahead(bitrate int64) int64 {
+	return min(max(bitrate*readaheadSeconds, minReadahead), maxReadahead)
+}
+
+func (r *playbackReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.pos += int64(n)
+	if r.pinned != nil && r.pos >= r.pinEnd {
+		// The pinned window has been played, readahead takes over from here
+		r.unpinAll()
+	}
+	return n, err
+}
+
+func (r *playbackReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.Reader.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	if pos != r.pos {
+		r.pos = pos
+		r.pinFrom(pos)
+	}
+	return pos, nil
+}
+
+func (r *playbackReader) Close() error {
+	r.unpinAll()
+	return r.Reader.Close()
+}
+
+// pinFrom replaces the current pins with the pieces covering seekPinSeconds
+// of playback from off.
+func (r *playbackReader) pinFrom(off int64) {
+	r.unpinAll()
+
+	length := r.file.Length()
+	if off >= length {
+		return
+	}
+
+	end := min(off+max(r.bitrate()*seekPinSeconds, 1), length)
+	pieceLen := r.t.Info().PieceLength
+	begin := int((r.file.Offset() + off) / pieceLen)
+	last := int((r.file.Offset() + end - 1) / pieceLen)
+
+	pieces := make([]*torrent.Piece, 0, last-begin+1)
+	for i := begin; i <= last; i++ {
+		pieces = append(pieces, r.t.Piece(i))
+	}
+
+	r.pins.pin(pieces)
+	r.pinned = pieces
+	r.pinEnd = end
+}
+
+func (r *playbackReader) unpinAll() {
+	if r.pinned == nil {
+		return
+	}
+	r.pins.unpin(r.pinned)
+	r.pinned = nil
+}
@@ -0,0 +1,232 @@
+package tor
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"golang.org/x/time/rate"
+)
+
+func TestReadahead(t *testing.T) {
+	tests := []struct {
+		bitrate int64
+		want    int64
+	}{
+		{0, minReadahead},
+		{64 << 10, minReadahead},
+		{defaultBitrate, 30 << 20},
+		{2 << 20, 60 << 20},
+		{64 << 20, maxReadahead},
+	}
+	for _, tt := range tests {
+		if got := readahead(tt.bitrate); got != tt.want {
+			t.Errorf("readahead(%d) = %d, want %d", tt.bitrate, got, tt.want)
+		}
+	}
+}
+
+func TestBitrateFromProbedDuration(t *testing.T) {
+	const size = 1 << 20
+
+	// An MP4 of 2 seconds
+	mvhd := []byte{0, 0, 0, 0}
+	mvhd = append(mvhd, make([]byte, 8)...) // Creation and modification times
+	mvhd = binary.BigEndian.AppendUint32(mvhd, 1000)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, 2000)
+	data := append(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00")), mp4Box("moov", mp4Box("mvhd", mvhd))...)
+	data = append(data, mp4Box("mdat", make([]byte, size-8-len(data)))...)
+
+	dataDir := t.TempDir()
+	path := filepath.Join(dataDir, "movie.mp4")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 64 << 10}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTestTorrent(t, dataDir)
+	tt, err := tr.cl.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := tt.Files()[0]
+
+	var b bitrates
+	key := fileKey{tt.InfoHash(), 0}
+	b.m.Store(key, int64(0)) // Keep get from probing in the background
+	if got := b.get(key, f); got != defaultBitrate {
+		t.Errorf("bitrate while probing = %d, want the default %d", got, defaultBitrate)
+	}
+	b.probe(key, f)
+	if got := b.get(key, f); got != size/2 {
+		t.Fatalf("probed bitrate = %d, want %d", got, size/2)
+	}
+	if got := readahead(b.get(key, f)); got != 15<<20 {
+		t.Errorf("readahead = %d, want 30s of playback, %d", got, 15<<20)
+	}
+}
+
+// mp4Box encodes an MP4 box.
+func mp4Box(boxType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, boxType...), payload...)
+}
+
+func TestSeekPinsPieces(t *testing.T) {
+	const pieceLength = 16 << 10
+
+	// Missing data, so pinned pieces are wanted
+	_, mi := writeTestVideo(t, t.TempDir(), "movie.mp4", 64*pieceLength, pieceLength)
+	tr := newTestTorrent(t, t.TempDir())
+	tt, err := tr.cl.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-tt.GotInfo()
+	tt.VerifyData()
+
+	// seekPinSeconds of playback spans four pieces
+	bitrate := func() int64 { return pieceLength / seekPinSeconds * 4 }
+	pinned := func(from, to int) {
+		t.Helper()
+		for i := range tt.NumPieces() {
+			want := i >= from && i < to
+			if got := tt.PieceState(i).Priority == torrent.PiecePriorityNow; got != want {
+				t.Errorf("piece %d pinned = %v, want %v", i, got, want)
+			}
+		}
+	}
+
+	r := newPlaybackReader(tt, tt.Files()[0], tr.pins, bitrate)
+	r.Seek(10*pieceLength+100, io.SeekStart)
+	pinned(10, 15)
+
+	// A second reader on the same pieces keeps them pinned
+	other := newPlaybackReader(tt, tt.Files()[0], tr.pins, bitrate)
+	other.Seek(12*pieceLength, io.SeekStart)
+
+	r.Seek(40*pieceLength, io.SeekStart)
+	for i := range 2 {
+		if tt.PieceState(10+i).Priority != torrent.PiecePriorityNone {
+			t.Errorf("piece %d still pinned after seeking away", 10+i)
+		}
+	}
+	for i := 12; i < 16; i++ {
+		if tt.PieceState(i).Priority != torrent.PiecePriorityNow {
+			t.Errorf("piece %d unpinned while another reader wants it", i)
+		}
+	}
+	other.Close()
+	pinned(40, 44)
+
+	r.Close()
+	pinned(0, 0)
+	if n := len(tr.pins.counts); n != 0 {
+		t.Errorf("%d pins left after closing every reader", n)
+	}
+}
+
+// BenchmarkSeekTimeToFirstByte measures how long a fresh leecher takes to
+// return the first byte after seeking deep into a file, served by a
+// rate-limited seeder over loopback. Compare the ms/ttfb metric of the
+// default and playback sub-benchmarks.
+func BenchmarkSeekTimeToFirstByte(b *testing.B) {
+	const (
+		size        = 32 << 20
+		pieceLength = 2 << 20
+		seekTo      = size * 3 / 4
+	)
+
+	seedDir := b.TempDir()
+	_, mi := writeTestVideo(b, seedDir, "movie.mp4", size, pieceLength)
+
+	seeder := newRateLimitedSeeder(b, seedDir)
+	st, err := seeder.cl.AddTorrent(mi)
+	if err != nil {
+		b.Fatalf("seeder add: %v", err)
+	}
+	<-st.GotInfo()
+	if err := st.VerifyData(); err != nil {
+		b.Fatalf("seeder verify: %v", err)
+	}
+
+	readers := map[string]func(*Torrent, *torrent.Torrent) torrent.Reader{
+		"default": func(_ *Torrent, t *torrent.Torrent) torrent.Reader {
+			return t.Files()[0].NewReader()
+		},
+		"playback": func(tr *Torrent, t *torrent.Torrent) torrent.Reader {
+			bitrate := func() int64 { return defaultBitrate }
+			return newPlaybackReader(t, t.Files()[0], tr.pins, bitrate)
+		},
+	}
+
+	for _, name := range []string{"default", "playback"} {
+		b.Run(name, func(b *testing.B) {
+			var total time.Duration
+			for range b.N {
+				b.StopTimer()
+				leecher := newTestTorrent(b, b.TempDir())
+				lt, err := leecher.cl.AddTorrent(mi)
+				if err != nil {
+					b.Fatalf("leecher add: %v", err)
+				}
+				<-lt.GotInfo()
+				lt.AddClientPeer(seeder.cl)
+				reader := readers[name](leecher, lt)
+				buf := make([]byte, 32*1024)
+				b.StartTimer()
+
+				start := time.Now()
+				if _, err := reader.Seek(seekTo, io.SeekStart); err != nil {
+					b.Fatalf("seek: %v", err)
+				}
+				if _, err := reader.Read(buf); err != nil {
+					b.Fatalf("read: %v", err)
+				}
+				total += time.Since(start)
+
+				b.StopTimer()
+				reader.Close()
+				leecher.cl.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(total.Milliseconds())/float64(b.N), "ms/ttfb")
+		})
+	}
+}
+
+// newRateLimitedSeeder returns a local client that uploads slowly enough for
+// piece scheduling to matter.
+func newRateLimitedSeeder(b *testing.B, dataDir string) *Torrent {
+	b.Helper()
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dataDir
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.DisableIPv6 = true
+	cfg.Seed = true
+	cfg.UploadRateLimiter = rate.NewLimiter(rate.Limit(16<<20), 256<<10)
+
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		b.Fatalf("failed to create seeder: %v", err)
+	}
+	b.Cleanup(func() { cl.Close() })
+
+	return newTorrent(cl, Config{DataDir: dataDir})
+}
@@ -0,0 +1,532 @@
+package tor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// registry tracks the torrents handed out to HTTP handlers and workers. Every
+// access goes through mu so handlers can add, read and clean up concurrently.
+//
+// Each torrent handle is reference-counted: one reference per id it is
+// registered under and one per open reader. The handle is only dropped once
+// the last reference is gone, so removing an id never pulls a torrent out from
+// under a viewer that is still streaming it.
+//
+// Ids are unique per info-hash: byHash points each torrent back at the id it
+// was first registered under.
+//
+// Ids that were dropped without being cleaned up on purpose, by eviction or
+// because their metadata never resolved to a video, leave a record in dropped
+// so lookups can explain what happened to them.
+//
+// Torrents seeded from the library live in the same client but are never
+// registered. seeds holds them so that an add of the same info-hash, which
+// gets their handle back from the client, neither takes them over nor drops
+// them.
+type registry struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	byHash  map[metainfo.Hash]string
+	refs    map[*torrent.Torrent]int
+	dropped map[string]dropRecord
+	seeds   map[*torrent.Torrent]bool
+	// dirty is set when the sessions change and cleared when they are taken
+	// for saving.
+	dirty bool
+}
+
+// entry is a registered id along with how it is being used. lastUsed only
+// moves when readers are opened or closed, so polling metadata or stats does
+// not keep an otherwise idle torrent alive.
+type entry struct {
+	id       string
+	t        *torrent.Torrent
+	readers  int
+	lastUsed time.Time
+
+	// source is a magnet link that adds the torrent again after a restart,
+	// and file the index of the file last streamed.
+	source string
+	file   int
+	// only is the selection of files the magnet link asked for with so=,
+	// nil for every file.
+	only fileSelection
+
+	// state is StateResolving until resolved is closed.
+	state    State
+	resolved chan struct{}
+}
+
+// dropRecord is why and when an id was dropped.
+type dropRecord struct {
+	err error
+	at  time.Time
+}
+
+func newRegistry() *registry {
+	return &registry{
+		entries: make(map[string]*entry),
+		byHash:  make(map[metainfo.Hash]string),
+		refs:    make(map[*torrent.Torrent]int),
+		dropped: make(map[string]dropRecord),
+		seeds:   make(map[*torrent.Torrent]bool),
+	}
+}
+
+// add registers t under id in the resolving state. If the info-hash of t is
+// already registered, nothing is added and the existing entry is returned
+// with created false. When the registry already holds maxActive ids it either
+// evicts the least recently used idle one (evictLRU) or fails with
+// ErrTooManyTorrents. A maxActive of zero means no limit. Torrents being
+// seeded fail with ErrSeeding.
+func (rg *registry) add(id, source string, t *torrent.Torrent, maxActive int, evictLRU bool) (e *entry, created bool, err error) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	if rg.seeds[t] {
+		return nil, false, fmt.Errorf("%w for id: %s", ErrSeeding, id)
+	}
+
+	if existing, ok := rg.byHash[t.InfoHash()]; ok {
+		return rg.entries[existing], false, nil
+	}
+
+	if _, ok := rg.entries[id]; ok {
+		return nil, false, fmt.Errorf("torrent already registered for id: %s", id)
+	}
+
+	if maxActive > 0 && len(rg.entries) >= maxActive {
+		victim, ok := rg.lruIdleLocked()
+		if !evictLRU || !ok {
+			return nil, false, ErrTooManyTorrents
+		}
+		rg.evictLocked(victim)
+	}
+
+	e = &entry{
+		id:       id,
+		t:        t,
+		lastUsed: time.Now(),
+		source:   source,
+		file:     MainFile,
+		state:    StateResolving,
+		resolved: make(chan struct{}),
+	}
+	rg.entries[id] = e
+	rg.byHash[t.InfoHash()] = id
+	rg.refs[t]++
+	delete(rg.dropped, id)
+	rg.dirty = true
+	return e, true, nil
+}
+
+// resolve ends the resolving state of e. An entry that failed to resolve is
+// unregistered and its id remembers the failure as err.
+func (rg *registry) resolve(e *entry, state State, err error) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	if e.state != StateResolving {
+		return
+	}
+	e.state = state
+	close(e.resolved)
+
+	if err != nil && rg.entries[e.id] == e {
+		rg.dropLocked(e.id, err)
+	}
+}
+
+// state returns the torrent registered under id and its resolution state.
+func (rg *registry) state(id string) (*torrent.Torrent, State, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e, ok := rg.entries[id]
+	if !ok {
+		return nil, "", false
+	}
+	return e.t, e.state, true
+}
+
+// lookup returns the entry registered under id.
+func (rg *registry) lookup(id string) (*entry, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e, ok := rg.entries[id]
+	return e, ok
+}
+
+// selectFile records index as the file last streamed from e and reports
+// whether that changed.
+func (rg *registry) selectFile(e *entry, index int) bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	if e.file == index {
+		return false
+	}
+	e.file = index
+	rg.dirty = true
+	return true
+}
+
+// setSelection restricts e to the files of s.
+func (rg *registry) setSelection(e *entry, s fileSelection) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e.only = s
+	rg.dirty = true
+}
+
+func (rg *registry) selection(e *entry) fileSelection {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	return e.only
+}
+
+// setSource changes the magnet link that adds e again after a restart.
+func (rg *registry) setSource(e *entry, source string) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e.source = source
+	rg.dirty = true
+}
+
+// sessions returns a snapshot of the registered ids.
+func (rg *registry) sessions() []session {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	return rg.sessionsLocked()
+}
+
+// takeSessions returns a snapshot of the registered ids for saving and
+// clears dirty.
+func (rg *registry) takeSessions() []session {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	rg.dirty = false
+	return rg.sessionsLocked()
+}
+
+// changed reports whether the sessions changed since they were last taken.
+func (rg *registry) changed() bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	return rg.dirty
+}
+
+// markChanged makes the sessions count as changed again, after a snapshot
+// taken for saving could not be written.
+func (rg *registry) markChanged() {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	rg.dirty = true
+}
+
+func (rg *registry) sessionsLocked() []session {
+	list := make([]session, 0, len(rg.entries))
+	for id, e := range rg.entries {
+		list = append(list, session{
+			Id:       id,
+			InfoHash: e.t.InfoHash().HexString(),
+			Magnet:   withSelectOnly(e.source, e.only),
+			File:     e.file,
+		})
+	}
+	return list
+}
+
+// torrents returns a snapshot of the registered ids and their torrents.
+func (rg *registry) torrents() map[string]*torrent.Torrent {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	active := make(map[string]*torrent.Torrent, len(rg.entries))
+	for id, e := range rg.entries {
+		active[id] = e.t
+	}
+	return active
+}
+
+// stateOf returns the resolution state of an entry handed out earlier.
+func (rg *registry) stateOf(e *entry) State {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	return e.state
+}
+
+// idForHash returns the id a torrent with the given info-hash is registered
+// under.
+func (rg *registry) idForHash(hash metainfo.Hash) (string, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	id, ok := rg.byHash[hash]
+	return id, ok
+}
+
+// full reports whether another id can be added without evicting anything.
+func (rg *registry) full(maxActive int) bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	return maxActive > 0 && len(rg.entries) >= maxActive
+}
+
+// get returns the torrent registered under id.
+func (rg *registry) get(id string) (*torrent.Torrent, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e, ok := rg.entries[id]
+	if !ok {
+		return nil, false
+	}
+	return e.t, true
+}
+
+// acquire returns the entry registered under id and takes a reference on its
+// torrent for a reader. Every successful acquire must be paired with a
+// release.
+func (rg *registry) acquire(id string) (*entry, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e, ok := rg.entries[id]
+	if !ok {
+		return nil, false
+	}
+	e.readers++
+	e.lastUsed = time.Now()
+	rg.refs[e.t]++
+	return e, true
+}
+
+// release gives back a reference taken with acquire.
+func (rg *registry) release(e *entry) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e.readers--
+	e.lastUsed = time.Now()
+	rg.unrefLocked(e.t)
+}
+
+// remove unregisters id. It reports whether id was registered, and how many
+// other references keep the torrent alive.
+func (rg *registry) remove(id string) (ok bool, remaining int) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	e, ok := rg.entries[id]
+	if !ok {
+		return false, 0
+	}
+	delete(rg.entries, id)
+	delete(rg.byHash, e.t.InfoHash())
+	rg.dirty = true
+	rg.unrefLocked(e.t)
+	return true, rg.refs[e.t]
+}
+
+// evictIdle evicts every id that has had no readers since before cutoff and
+// returns the evicted ids.
+func (rg *registry) evictIdle(cutoff time.Time) []string {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	var ids []string
+	for id, e := range rg.entries {
+		if e.readers == 0 && e.lastUsed.Before(cutoff) {
+			rg.evictLocked(id)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// evictLRU evicts the least recently used id without readers. It returns the
+// evicted torrent so its data can be removed once nobody else holds it.
+func (rg *registry) evictLRU() (string, *torrent.Torrent, bool) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	id, ok := rg.lruIdleLocked()
+	if !ok {
+		return "", nil, false
+	}
+	t := rg.entries[id].t
+	rg.evictLocked(id)
+	return id, t, true
+}
+
+// droppedErr returns why id was dropped, or nil if it was never added or was
+// cleaned up on purpose. Records older than keep are forgotten.
+func (rg *registry) droppedErr(id string, keep time.Duration) error {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	rec, ok := rg.dropped[id]
+	if !ok {
+		return nil
+	}
+	if time.Since(rec.at) > keep {
+		delete(rg.dropped, id)
+		return nil
+	}
+	return rec.err
+}
+
+// pruneDropped forgets drop records older than keep.
+func (rg *registry) pruneDropped(keep time.Duration) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	for id, rec := range rg.dropped {
+		if time.Since(rec.at) > keep {
+			delete(rg.dropped, id)
+		}
+	}
+}
+
+// inUse reports whether t is still registered or being read.
+func (rg *registry) inUse(t *torrent.Torrent) bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	return rg.refs[t] > 0
+}
+
+// held returns every torrent that is registered or still being read.
+func (rg *registry) held() []*torrent.Torrent {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	list := make([]*torrent.Torrent, 0, len(rg.refs))
+	for t := range rg.refs {
+		list = append(list, t)
+	}
+	return list
+}
+
+func (rg *registry) lruIdleLocked() (string, bool) {
+	var (
+		victim string
+		oldest time.Time
+	)
+	for id, e := range rg.entries {
+		if e.readers > 0 {
+			continue
+		}
+		if victim == "" || e.lastUsed.Before(oldest) {
+			victim, oldest = id, e.lastUsed
+		}
+	}
+	return victim, victim != ""
+}
+
+func (rg *registry) evictLocked(id string) {
+	rg.dropLocked(id, ErrEvicted)
+}
+
+func (rg *registry) dropLocked(id string, err error) {
+	e := rg.entries[id]
+	delete(rg.entries, id)
+	delete(rg.byHash, e.t.InfoHash())
+	rg.dropped[id] = dropRecord{err: err, at: time.Now()}
+	rg.dirty = true
+	rg.unrefLocked(e.t)
+}
+
+// discard drops t unless it is registered, being read or seeded. The
+// anacrolix client returns the existing handle when a known info-hash is
+// added again, so a rejected add must not drop a torrent that is in use
+// elsewhere.
+func (rg *registry) discard(t *torrent.Torrent) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	if rg.refs[t] == 0 && !rg.seeds[t] {
+		t.Drop()
+	}
+}
+
+// seed adds the torrent spec describes to cl as a seed. It is added under mu,
+// so an add of the same info-hash racing with it either gets a handle that is
+// known to be seeded, or adds the torrent first and the seed is not created.
+func (rg *registry) seed(cl *torrent.Client, spec *torrent.TorrentSpec) (*torrent.Torrent, bool, error) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	t, created, err := cl.AddTorrentSpec(spec)
+	if err == nil && created {
+		rg.seeds[t] = true
+	}
+	return t, created, err
+}
+
+// seeding reports whether a torrent with the given info-hash is seeded.
+func (rg *registry) seeding(hash metainfo.Hash) bool {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	for t := range rg.seeds {
+		if t.InfoHash() == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// unseed drops a torrent added with seed.
+func (rg *registry) unseed(t *torrent.Torrent) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	delete(rg.seeds, t)
+	t.Drop()
+}
+
+// unrefLocked drops one reference on t, and drops the torrent itself once the
+// last reference is gone. Dropping under mu keeps a concurrent add of the same
+// info-hash from registering a handle that is about to be closed.
+func (rg *registry) unrefLocked(t *torrent.Torrent) {
+	rg.refs[t]--
+	if rg.refs[t] > 0 {
+		return
+	}
+	delete(rg.refs, t)
+	t.Drop()
+}
+
+// trackedReader releases its registry reference when closed.
+type trackedReader struct {
+	torrent.Reader
+	rg    *registry
+	e     *entry
+	close sync.Once
+}
+
+func (r *trackedReader) Close() error {
+	var err error
+	r.close.Do(func() {
+		err = r.Reader.Close()
+		r.rg.release(r.e)
+	})
+	return err
+}
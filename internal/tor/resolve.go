@@ -0,0 +1,100 @@
+package tor
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// State is how far a torrent has come in resolving its metadata.
+type State string
+
+const (
+	// StateResolving means the metadata is still being fetched from peers.
+	StateResolving State = "resolving"
+	// StateReady means the metadata is known and holds at least one video.
+	StateReady State = "ready"
+	// StateNoVideoFiles means the metadata holds no video file. The torrent
+	// has been dropped.
+	StateNoVideoFiles State = "no_video_files"
+	// StateTimedOut means the metadata did not arrive within MetadataTimeout.
+	// The torrent has been dropped.
+	StateTimedOut State = "timed_out"
+)
+
+var (
+	// ErrResolving is returned for files of a torrent whose metadata has not
+	// arrived yet.
+	ErrResolving = errors.New("torrent metadata is still resolving")
+	// ErrNoVideoFiles is returned for torrents without a video file.
+	ErrNoVideoFiles = errors.New("no valid video files in torrent")
+	// ErrMetadataTimeout is returned for torrents whose metadata did not
+	// arrive within MetadataTimeout.
+	ErrMetadataTimeout = errors.New("timed out resolving torrent metadata")
+)
+
+// State reports how far the metadata of videoId has resolved. Ids that failed
+// to resolve keep reporting their failure state for a while after they are
+// dropped; other unknown ids return ErrEvicted or ErrNotFound.
+func (tr *Torrent) State(videoId string) (State, error) {
+	if _, state, ok := tr.tor.state(videoId); ok {
+		return state, nil
+	}
+
+	err := tr.lookupErr(videoId)
+	switch {
+	case errors.Is(err, ErrNoVideoFiles):
+		return StateNoVideoFiles, nil
+	case errors.Is(err, ErrMetadataTimeout):
+		return StateTimedOut, nil
+	}
+	return "", err
+}
+
+// resolve waits in the background for the metadata of e and moves it out of
+// the resolving state.
+func (tr *Torrent) resolve(e *entry) {
+	var deadline <-chan time.Time
+	if tr.cfg.MetadataTimeout > 0 {
+		timer := time.NewTimer(tr.cfg.MetadataTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case <-e.t.GotInfo():
+		if !tr.hasVideo(e.t) {
+			tr.sniffVideos(e.t)
+		}
+		if !tr.hasSelectedVideo(e.t, tr.tor.selection(e)) {
+			log.Printf("[Resolve] no valid video files found for id: %s", e.id)
+			tr.tor.resolve(e, StateNoVideoFiles, ErrNoVideoFiles)
+			tr.saveSessions()
+			return
+		}
+		if isPrivate(e.t) {
+			tr.dropDefaultTrackers(e)
+		}
+		tr.applySelection(e)
+		log.Printf("[Resolve] metadata ready for id: %s", e.id)
+		tr.tor.resolve(e, StateReady, nil)
+		tr.infoCache.store(e.t)
+	case <-deadline:
+		log.Printf("[Resolve] timeout waiting for metadata for id: %s", e.id)
+		tr.tor.resolve(e, StateTimedOut, ErrMetadataTimeout)
+		tr.saveSessions()
+	case <-e.t.Closed():
+	case <-tr.cl.Closed():
+	}
+}
+
+// awaitResolved blocks until e has left the resolving state, or its torrent
+// is closed.
+func awaitResolved(e *entry) bool {
+	select {
+	case <-e.resolved:
+		return true
+	case <-e.t.Closed():
+		return false
+	}
+}
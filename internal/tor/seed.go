@@ -0,0 +1,180 @@
+package tor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	g "github.com/anacrolix/generics"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Seeding policies for saved videos.
+const (
+	SeedNever = "never"
+	SeedRatio = "ratio"
+	SeedTime  = "time"
+)
+
+// ErrSeeding is returned when adding a torrent that is being seeded from the
+// library, which has to be streamed from the saved file instead.
+var ErrSeeding = errors.New("torrent is being seeded from the library")
+
+// SeedPolicy decides how long a saved video is seeded.
+type SeedPolicy struct {
+	Mode string
+	// Ratio is the upload target for SeedRatio, as a multiple of the size of
+	// the seeded file.
+	Ratio float64
+	// Duration is how long SeedTime seeds for.
+	Duration time.Duration
+}
+
+// DefaultSeedPolicy reads the seeding policy from the environment:
+//
+//	SEED_POLICY  "never" (default), "ratio" or "time"
+//	SEED_RATIO   upload target for "ratio", default 1.0
+//	SEED_TIME    seeding time for "time", default "24h"
+func DefaultSeedPolicy() SeedPolicy {
+	p := SeedPolicy{
+		Mode:     os.Getenv("SEED_POLICY"),
+		Ratio:    1,
+		Duration: envDuration("SEED_TIME", 24*time.Hour),
+	}
+
+	if raw := os.Getenv("SEED_RATIO"); raw != "" {
+		ratio, err := strconv.ParseFloat(raw, 64)
+		if err != nil || ratio < 0 {
+			log.Printf("[Config] invalid SEED_RATIO=%q, using %.1f", raw, p.Ratio)
+		} else {
+			p.Ratio = ratio
+		}
+	}
+
+	switch p.Mode {
+	case SeedRatio, SeedTime, SeedNever:
+	case "":
+		p.Mode = SeedNever
+	default:
+		log.Printf("[Config] invalid SEED_POLICY=%q, not seeding", p.Mode)
+		p.Mode = SeedNever
+	}
+	return p
+}
+
+// Done reports whether a seed that uploaded uploaded bytes of a length byte
+// file over elapsed has met the policy.
+func (p SeedPolicy) Done(uploaded, length int64, elapsed time.Duration) bool {
+	switch p.Mode {
+	case SeedRatio:
+		return length <= 0 || float64(uploaded) >= p.Ratio*float64(length)
+	case SeedTime:
+		return elapsed >= p.Duration
+	}
+	return true
+}
+
+// Seed is a saved file being seeded.
+type Seed struct {
+	t       *torrent.Torrent
+	rg      *registry
+	store   storage.ClientImplCloser
+	Length  int64
+	Started time.Time
+}
+
+// Uploaded returns the bytes of file data sent to peers so far.
+func (s *Seed) Uploaded() int64 {
+	st := s.t.Stats()
+	return st.BytesWrittenData.Int64()
+}
+
+// Ratio returns the bytes uploaded as a multiple of the file's size.
+func (s *Seed) Ratio() float64 {
+	if s.Length <= 0 {
+		return 0
+	}
+	return float64(s.Uploaded()) / float64(s.Length)
+}
+
+// Stop stops seeding. The file itself is left alone.
+func (s *Seed) Stop() {
+	s.rg.unseed(s.t)
+	s.store.Close()
+}
+
+// GetMetainfo returns the metainfo of the torrent registered under videoId.
+func (tr *Torrent) GetMetainfo(videoId string) (*metainfo.MetaInfo, error) {
+	t, state, ok := tr.tor.state(videoId)
+	if !ok {
+		return nil, fmt.Errorf("%w for videoId: %s", tr.lookupErr(videoId), videoId)
+	}
+	if state != StateReady {
+		return nil, fmt.Errorf("%w for videoId: %s", ErrResolving, videoId)
+	}
+
+	mi := t.Metainfo()
+	return &mi, nil
+}
+
+// SeedFile seeds the file at index of the torrent mi describes from path,
+// such as the copy of it saved in the library, instead of from DataDir. Only
+// the pieces that lie entirely within the file can be served, and only once
+// the file has been hashed, which happens in the background. The torrent must
+// not be active in the client, clean it up first.
+func (tr *Torrent) SeedFile(mi *metainfo.MetaInfo, index int, path string) (*Seed, error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	files := info.UpvertedFiles()
+	if index < 0 || index >= len(files) {
+		return nil, fmt.Errorf("file index %d out of range for %s", index, info.BestName())
+	}
+
+	seeded := &files[index]
+	store := storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir: filepath.Dir(path),
+		FilePathMaker: func(opts storage.FilePathMakerOpts) string {
+			if slices.Equal(opts.File.BestPath(), seeded.BestPath()) {
+				return filepath.Base(path)
+			}
+			// Never written, downloads are disallowed
+			return filepath.Join(".unseeded", mi.HashInfoBytes().HexString(), filepath.Join(opts.File.BestPath()...))
+		},
+		PieceCompletion: storage.NewMapPieceCompletion(),
+		UsePartFiles:    g.Some(false),
+	})
+
+	t, created, err := tr.tor.seed(tr.cl, &torrent.TorrentSpec{
+		AddTorrentOpts: torrent.AddTorrentOpts{
+			InfoHash:             mi.HashInfoBytes(),
+			InfoBytes:            mi.InfoBytes,
+			Storage:              store,
+			DisallowDataDownload: true,
+		},
+		Trackers: mi.UpvertedAnnounceList(),
+	})
+	if err != nil || !created {
+		store.Close()
+		if err == nil {
+			err = fmt.Errorf("torrent %s is still active", mi.HashInfoBytes().HexString())
+		}
+		return nil, err
+	}
+
+	go func() {
+		if err := t.VerifyData(); err != nil {
+			log.Printf("[Seed] failed to hash %s: %v", path, err)
+		}
+	}()
+
+	return &Seed{t: t, rg: tr.tor, store: store, Length: seeded.Length, Started: time.Now()}, nil
+}
@@ -0,0 +1,87 @@
+package tor
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSeedFileServesTheLibraryCopy(t *testing.T) {
+	dataDir, libraryDir := t.TempDir(), t.TempDir()
+	data, mi := writeTestVideo(t, libraryDir, "video.mp4", 512*1024, 16*1024)
+	saved := filepath.Join(libraryDir, "saved.mp4")
+	if err := os.Rename(filepath.Join(libraryDir, "video.mp4"), saved); err != nil {
+		t.Fatal(err)
+	}
+
+	seeder := newTestTorrent(t, dataDir)
+	seed, err := seeder.SeedFile(mi, 0, saved)
+	if err != nil {
+		t.Fatalf("SeedFile: %v", err)
+	}
+	defer seed.Stop()
+	if _, err := seeder.SeedFile(mi, 0, saved); err == nil {
+		t.Fatal("seeded the same torrent twice")
+	}
+
+	leecher := newTestTorrent(t, t.TempDir())
+	id, err := leecher.AddMetainfo("leech", mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt, _ := leecher.tor.get(id)
+	lt.AddClientPeer(seeder.cl)
+
+	reader := leecher.GetReader(id)
+	if reader == nil {
+		t.Fatal("no reader")
+	}
+	defer (*reader).Close()
+
+	done := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(*reader)
+		done <- got
+	}()
+	select {
+	case got := <-done:
+		if !bytes.Equal(got, data) {
+			t.Fatal("leecher read different data")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out downloading from the seed")
+	}
+
+	if seed.Uploaded() < int64(len(data)) || seed.Ratio() < 1 {
+		t.Errorf("uploaded %d bytes, ratio %.2f", seed.Uploaded(), seed.Ratio())
+	}
+	for _, p := range []string{filepath.Join(dataDir, "video.mp4"), filepath.Join(libraryDir, ".unseeded")} {
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("seeding created %s", p)
+		}
+	}
+}
+
+func TestSeedPolicyDone(t *testing.T) {
+	tests := []struct {
+		policy   SeedPolicy
+		uploaded int64
+		elapsed  time.Duration
+		done     bool
+	}{
+		{SeedPolicy{Mode: SeedNever}, 0, 0, true},
+		{SeedPolicy{Mode: SeedRatio, Ratio: 1.5}, 100, time.Hour, false},
+		{SeedPolicy{Mode: SeedRatio, Ratio: 1.5}, 150, 0, true},
+		{SeedPolicy{Mode: SeedTime, Duration: time.Hour}, 1000, time.Minute, false},
+		{SeedPolicy{Mode: SeedTime, Duration: time.Hour}, 0, time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Done(tt.uploaded, 100, tt.elapsed); got != tt.done {
+			t.Errorf("%+v.Done(%d, 100, %s) = %v, want %v", tt.policy, tt.uploaded, tt.elapsed, got, tt.done)
+		}
+	}
+}
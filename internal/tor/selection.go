@@ -0,0 +1,148 @@
+package tor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// maxSelectOnly bounds how many file indexes a so= parameter may expand to,
+// so a range like 0-999999999 cannot exhaust memory.
+const maxSelectOnly = 1 << 16
+
+// ErrInvalidSelection is returned for magnet links whose so= parameter is not
+// a list of file indexes and ranges.
+var ErrInvalidSelection = errors.New("invalid file selection")
+
+// fileSelection is the sorted file indexes a magnet link selected with so=
+// (BEP 53). A nil selection selects every file.
+type fileSelection []int
+
+func (s fileSelection) has(index int) bool {
+	if s == nil {
+		return true
+	}
+	_, found := slices.BinarySearch(s, index)
+	return found
+}
+
+// String formats s the way so= expects it, with runs collapsed into ranges,
+// e.g. "0,2,4-6".
+func (s fileSelection) String() string {
+	var parts []string
+	for i := 0; i < len(s); {
+		j := i
+		for j+1 < len(s) && s[j+1] == s[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", s[i], s[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(s[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseSelectOnly parses the value of a so= parameter, such as "0,2,4-6".
+func parseSelectOnly(raw string) (fileSelection, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var s fileSelection
+	for _, part := range strings.Split(raw, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSelection, part)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.Atoi(last)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidSelection, part)
+			}
+		}
+		if len(s)+to-from >= maxSelectOnly {
+			return nil, fmt.Errorf("%w: more than %d files", ErrInvalidSelection, maxSelectOnly)
+		}
+		for i := from; i <= to; i++ {
+			s = append(s, i)
+		}
+	}
+
+	slices.Sort(s)
+	return slices.Compact(s), nil
+}
+
+// selectOnly returns the selection of a magnet link.
+func selectOnly(magnetLink string) (fileSelection, error) {
+	m, err := metainfo.ParseMagnetUri(magnetLink)
+	if err != nil {
+		return nil, err
+	}
+	return parseSelectOnly(m.Params.Get("so"))
+}
+
+// withSelectOnly returns magnetLink with its so= parameter set to s, so the
+// selection survives in links that were rebuilt from the torrent.
+func withSelectOnly(magnetLink string, s fileSelection) string {
+	if s == nil {
+		return magnetLink
+	}
+	m, err := metainfo.ParseMagnetUri(magnetLink)
+	if err != nil {
+		return magnetLink
+	}
+	if m.Params == nil {
+		m.Params = make(map[string][]string)
+	}
+	m.Params.Set("so", s.String())
+	return m.String()
+}
+
+// hasSelectedVideo reports whether a file s selects in t is a video.
+func (tr *Torrent) hasSelectedVideo(t *torrent.Torrent, s fileSelection) bool {
+	for i, f := range t.Files() {
+		if s.has(i) && tr.isVideo(i, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// applySelection keeps the files e did not select from ever being
+// downloaded. Pieces they share with a selected file still are.
+func (tr *Torrent) applySelection(e *entry) {
+	s := tr.tor.selection(e)
+	if s == nil {
+		return
+	}
+
+	skipped := 0
+	for i, f := range e.t.Files() {
+		if !s.has(i) {
+			f.SetPriority(torrent.PiecePriorityNone)
+			skipped++
+		}
+	}
+	log.Printf("[Selection] skipping %d file(s) not selected for id: %s", skipped, e.id)
+}
+
+// selectionOf returns the file selection of videoId, nil when every file is
+// selected.
+func (tr *Torrent) selectionOf(videoId string) fileSelection {
+	e, ok := tr.tor.lookup(videoId)
+	if !ok {
+		return nil
+	}
+	return tr.tor.selection(e)
+}
@@ -0,0 +1,92 @@
+package tor
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestParseSelectOnly(t *testing.T) {
+	s, err := parseSelectOnly("6-8, 0,2,7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.String(); got != "0,2,6-8" {
+		t.Errorf("got %q", got)
+	}
+	if !s.has(7) || s.has(1) || !fileSelection(nil).has(1) {
+		t.Errorf("wrong membership for %v", s)
+	}
+
+	for _, raw := range []string{"a", "3-1", "-1", "0-99999999"} {
+		if _, err := parseSelectOnly(raw); !errors.Is(err, ErrInvalidSelection) {
+			t.Errorf("parsing %q: got %v, want ErrInvalidSelection", raw, err)
+		}
+	}
+}
+
+func TestMagnetSelectOnly(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	tr.cfg.MetadataTimeout = 2 * time.Second
+
+	dir := filepath.Join(dataDir, "pack")
+	os.MkdirAll(dir, 0o755)
+	for name, size := range map[string]int{"big.mkv": 96 << 10, "small.mp4": 32 << 10} {
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info := metainfo.Info{PieceLength: 16 << 10}
+	if err := info.BuildFromFilePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+
+	// Only the cache can serve the metadata of the magnet link
+	if _, err := tr.AddMetainfo("cached", mi); err != nil {
+		t.Fatal(err)
+	}
+	tr.CleanupTorrent("cached")
+
+	magnet := "magnet:?xt=urn:btih:" + mi.HashInfoBytes().HexString() + "&so=1"
+	id, err := tr.AddMagnet("picked", magnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, tr, id, StateReady)
+
+	meta, err := tr.GetMetadata(id)
+	if err != nil || meta.Name != "small.mp4" {
+		t.Fatalf("main file = %+v, %v; want the selected small.mp4", meta, err)
+	}
+	if _, err := tr.GetFileMetadata(id, 0); err == nil {
+		t.Error("served a file that was not selected")
+	}
+	files, err := tr.GetFiles(id)
+	if err != nil || len(files) != 2 || files[0].Selected || !files[1].Selected {
+		t.Errorf("files = %+v, %v; want only the second selected", files, err)
+	}
+	if e, _ := tr.tor.lookup(id); e.t.Files()[0].Priority() != torrent.PiecePriorityNone {
+		t.Error("unselected file may still be downloaded")
+	}
+
+	sessions := tr.tor.sessions()
+	if len(sessions) != 1 || !strings.Contains(sessions[0].Magnet, "so=1") {
+		t.Errorf("sessions = %+v, want the selection kept", sessions)
+	}
+}
@@ -0,0 +1,145 @@
+package tor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// session is a registered id as persisted across restarts. Piece completion
+// does not need to be saved here: the client keeps it in its own database in
+// DataDir, so restored torrents pick up their data without verifying it again.
+type session struct {
+	Id       string `json:"id"`
+	InfoHash string `json:"info_hash"`
+	Magnet   string `json:"magnet"`
+	// File is the index of the file last streamed, or MainFile.
+	File int `json:"file_index"`
+}
+
+// sessionStore writes the registered ids to a JSON file whenever they change.
+type sessionStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// save replaces the stored sessions with a fresh snapshot and reports whether
+// it was written. Taking the snapshot under mu keeps concurrent saves from
+// writing an older one last.
+func (ss *sessionStore) save(snapshot func() []session) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	data, err := json.MarshalIndent(snapshot(), "", "  ")
+	if err != nil {
+		log.Printf("[Sessions] failed to encode sessions: %v", err)
+		return false
+	}
+
+	tmp := ss.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[Sessions] failed to write %s: %v", tmp, err)
+		return false
+	}
+	if err := os.Rename(tmp, ss.path); err != nil {
+		log.Printf("[Sessions] failed to write %s: %v", ss.path, err)
+		return false
+	}
+	return true
+}
+
+func (ss *sessionStore) load() ([]session, error) {
+	data, err := os.ReadFile(ss.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var sessions []session
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// saveSessions persists the registered ids if a session file is configured.
+func (tr *Torrent) saveSessions() {
+	if tr.sessions == nil {
+		return
+	}
+	if !tr.sessions.save(tr.tor.takeSessions) {
+		// Try again on the next sweep
+		tr.tor.markChanged()
+	}
+}
+
+// restoreSessions adds back the ids that were registered when the process
+// last stopped, under the same ids. Their metainfo normally comes from the
+// metainfo cache, so they are ready again without contacting peers.
+func (tr *Torrent) restoreSessions() {
+	sessions, err := tr.sessions.load()
+	if err != nil {
+		log.Printf("[Sessions] failed to load %s: %v", tr.sessions.path, err)
+		return
+	}
+
+	restored := 0
+	for _, s := range sessions {
+		// Saving now would drop the sessions that are not restored yet. The
+		// saved link holds the trackers the torrent had, defaults included
+		// unless they were removed.
+		id, _, err := tr.addMagnet(s.Id, s.Magnet, nil, false)
+		if err != nil {
+			log.Printf("[Sessions] failed to restore id %s: %v", s.Id, err)
+			continue
+		}
+		if id != s.Id {
+			log.Printf("[Sessions] id %s holds the same torrent as %s, not restored", s.Id, id)
+			continue
+		}
+
+		if e, ok := tr.tor.lookup(id); ok {
+			tr.tor.selectFile(e, s.File)
+			if s.File != MainFile {
+				go tr.warmBitrate(e, s.File)
+			}
+		}
+		restored++
+	}
+
+	if len(sessions) > 0 {
+		tr.saveSessions()
+		log.Printf("[Sessions] restored %d of %d torrent(s) from %s", restored, len(sessions), filepath.Base(tr.sessions.path))
+	}
+}
+
+// warmBitrate starts the bitrate probe of the file a restored session was
+// streaming, so the first request after a restart gets a fitting readahead.
+func (tr *Torrent) warmBitrate(e *entry, index int) {
+	if !awaitResolved(e) || tr.tor.stateOf(e) != StateReady {
+		return
+	}
+
+	files := e.t.Files()
+	if index < 0 || index >= len(files) {
+		return
+	}
+	tr.bitrates.get(fileKey{hash: e.t.InfoHash(), index: index}, files[index])
+}
+
+// sourceMagnet returns a magnet link that re-adds mi, trackers included.
+func sourceMagnet(mi *metainfo.MetaInfo) string {
+	m, err := mi.MagnetV2()
+	if err != nil {
+		return "magnet:?xt=urn:btih:" + mi.HashInfoBytes().HexString()
+	}
+	return m.String()
+}
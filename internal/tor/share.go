@@ -0,0 +1,296 @@
+package tor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Piece lengths accepted for shared files.
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 64 << 20
+)
+
+// ErrInvalidPieceLength is returned for piece lengths that are not a power of
+// two between 16KiB and 64MiB.
+var ErrInvalidPieceLength = errors.New("piece length must be a power of two between 16KiB and 64MiB")
+
+// ShareOptions controls the torrent built for a shared file.
+type ShareOptions struct {
+	// PieceLength is the piece size in bytes. Zero picks one from the size of
+	// the file.
+	PieceLength int64
+	// Trackers are announced to on top of the default trackers.
+	Trackers []string
+}
+
+// Share is a local file seeded in place as a torrent of its own.
+type Share struct {
+	Id       string
+	Path     string
+	MetaInfo *metainfo.MetaInfo
+	seed     *Seed
+}
+
+// Magnet returns a magnet link to the shared file, trackers included.
+func (s *Share) Magnet() string {
+	return sourceMagnet(s.MetaInfo)
+}
+
+// PieceLength returns the piece size of the shared torrent.
+func (s *Share) PieceLength() int64 {
+	info, err := s.MetaInfo.UnmarshalInfo()
+	if err != nil {
+		return 0
+	}
+	return info.PieceLength
+}
+
+// Seed returns the seed of the shared file.
+func (s *Share) Seed() *Seed {
+	return s.seed
+}
+
+// shareRecord is a share as persisted across restarts. The metainfo is kept
+// whole so the file does not have to be hashed to rebuild it, and its magnet
+// link stays the same.
+type shareRecord struct {
+	Id      string `json:"id"`
+	Path    string `json:"path"`
+	Torrent []byte `json:"torrent"`
+}
+
+// shares holds the shared files by id, and saves them to path whenever they
+// change unless path is empty.
+type shares struct {
+	mu   sync.Mutex
+	byId map[string]*Share
+	path string
+}
+
+func newShares(path string) *shares {
+	return &shares{byId: make(map[string]*Share), path: path}
+}
+
+// saveLocked writes the shares to the share file. mu must be held.
+func (sh *shares) saveLocked() {
+	if sh.path == "" {
+		return
+	}
+
+	records := make([]shareRecord, 0, len(sh.byId))
+	for _, s := range sh.byId {
+		var buf bytes.Buffer
+		if err := s.MetaInfo.Write(&buf); err != nil {
+			log.Printf("[Share] failed to encode torrent of %s: %v", s.Id, err)
+			continue
+		}
+		records = append(records, shareRecord{Id: s.Id, Path: s.Path, Torrent: buf.Bytes()})
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		log.Printf("[Share] failed to encode shares: %v", err)
+		return
+	}
+	tmp := sh.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[Share] failed to write %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, sh.path); err != nil {
+		log.Printf("[Share] failed to write %s: %v", sh.path, err)
+	}
+}
+
+func (sh *shares) load() ([]shareRecord, error) {
+	data, err := os.ReadFile(sh.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []shareRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// put stores s under its id, stopping the share it replaces.
+func (sh *shares) put(s *Share) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if old, ok := sh.byId[s.Id]; ok && old.seed != s.seed {
+		old.seed.Stop()
+	}
+	sh.byId[s.Id] = s
+	sh.saveLocked()
+}
+
+// Shared returns the share of id.
+func (tr *Torrent) Shared(id string) (*Share, bool) {
+	tr.shares.mu.Lock()
+	defer tr.shares.mu.Unlock()
+
+	s, ok := tr.shares.byId[id]
+	return s, ok
+}
+
+// ShareFile builds a single file torrent from the file at path and seeds it
+// in place under id, without copying the file. Sharing the same file under
+// the same id again returns the existing share, with any new trackers added,
+// unless a different piece length is asked for. Hashing the file takes a
+// while for large files.
+func (tr *Torrent) ShareFile(id, path string, opts ShareOptions) (*Share, error) {
+	trackers, err := validTrackers(opts.Trackers)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PieceLength != 0 && !validPieceLength(opts.PieceLength) {
+		return nil, ErrInvalidPieceLength
+	}
+
+	if s, ok := tr.Shared(id); ok && s.Path == path && (opts.PieceLength == 0 || opts.PieceLength == s.PieceLength()) {
+		return tr.addShareTrackers(s, trackers), nil
+	}
+
+	mi, err := tr.buildTorrent(path, opts.PieceLength, trackers)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := tr.SeedFile(mi, 0, path)
+	if err != nil {
+		// The same file shared under another id
+		if s, ok := tr.sharedHash(mi.HashInfoBytes()); ok {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	s := &Share{Id: id, Path: path, MetaInfo: mi, seed: seed}
+	tr.shares.put(s)
+	return s, nil
+}
+
+func validPieceLength(n int64) bool {
+	return n >= minPieceLength && n <= maxPieceLength && n&(n-1) == 0
+}
+
+// buildTorrent hashes the file at path into a single file torrent announced
+// to trackers and the default trackers.
+func (tr *Torrent) buildTorrent(path string, pieceLength int64, trackers []string) (*metainfo.MetaInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if pieceLength == 0 {
+		pieceLength = metainfo.ChoosePieceLength(stat.Size())
+	}
+
+	info := metainfo.Info{PieceLength: pieceLength}
+	if err := info.BuildFromFilePath(path); err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metainfo.MetaInfo{
+		InfoBytes:    infoBytes,
+		AnnounceList: tr.extraTrackers(trackers, true),
+		CreatedBy:    "fluxstream",
+		CreationDate: time.Now().Unix(),
+	}, nil
+}
+
+// addShareTrackers adds trackers to a share. The torrent is announced to them
+// right away, and they are part of its magnet link and .torrent file from now
+// on.
+func (tr *Torrent) addShareTrackers(s *Share, trackers []string) *Share {
+	tiers := mergeTiers(s.MetaInfo.UpvertedAnnounceList(), [][]string{trackers})
+	if len(tiers) == len(s.MetaInfo.UpvertedAnnounceList()) {
+		return s
+	}
+
+	s.seed.t.ModifyTrackers(tiers)
+
+	mi := *s.MetaInfo
+	mi.Announce, mi.AnnounceList = "", tiers
+	updated := &Share{Id: s.Id, Path: s.Path, MetaInfo: &mi, seed: s.seed}
+	tr.shares.put(updated)
+	return updated
+}
+
+func (tr *Torrent) sharedHash(hash metainfo.Hash) (*Share, bool) {
+	tr.shares.mu.Lock()
+	defer tr.shares.mu.Unlock()
+
+	for _, s := range tr.shares.byId {
+		if s.MetaInfo.HashInfoBytes() == hash {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// restoreShares seeds the files that were shared when the process last
+// stopped. Files that are gone or changed size are no longer shared.
+func (tr *Torrent) restoreShares() {
+	records, err := tr.shares.load()
+	if err != nil {
+		log.Printf("[Share] failed to load %s: %v", tr.shares.path, err)
+		return
+	}
+
+	tr.shares.mu.Lock()
+	defer tr.shares.mu.Unlock()
+
+	for _, r := range records {
+		mi, err := metainfo.Load(bytes.NewReader(r.Torrent))
+		if err != nil {
+			log.Printf("[Share] invalid torrent for %s: %v", r.Id, err)
+			continue
+		}
+		info, err := mi.UnmarshalInfo()
+		if err != nil {
+			log.Printf("[Share] invalid torrent for %s: %v", r.Id, err)
+			continue
+		}
+		if stat, err := os.Stat(r.Path); err != nil || stat.Size() != info.TotalLength() {
+			log.Printf("[Share] %s changed or is gone, no longer sharing %s", r.Path, r.Id)
+			continue
+		}
+
+		seed, err := tr.SeedFile(mi, 0, r.Path)
+		if err != nil {
+			log.Printf("[Share] failed to seed %s: %v", r.Path, err)
+			continue
+		}
+		tr.shares.byId[r.Id] = &Share{Id: r.Id, Path: r.Path, MetaInfo: mi, seed: seed}
+	}
+
+	if len(records) > 0 {
+		tr.shares.saveLocked()
+		log.Printf("[Share] seeding %d of %d shared file(s) from %s", len(tr.shares.byId), len(records), filepath.Base(tr.shares.path))
+	}
+}
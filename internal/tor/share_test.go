@@ -0,0 +1,127 @@
+package tor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShareFileSeedsInPlace(t *testing.T) {
+	libraryDir := t.TempDir()
+	data := make([]byte, 300*1024)
+	rand.Read(data)
+	path := filepath.Join(libraryDir, "home video.mp4")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := t.TempDir()
+	sharer := newTestTorrent(t, dataDir)
+	sharer.shares.path = filepath.Join(dataDir, ".shares.json")
+
+	if _, err := sharer.ShareFile("home", path, ShareOptions{PieceLength: 3000}); err == nil {
+		t.Fatal("accepted a piece length that is not a power of two")
+	}
+	share, err := sharer.ShareFile("home", path, ShareOptions{PieceLength: 32 << 10})
+	if err != nil {
+		t.Fatalf("ShareFile: %v", err)
+	}
+	if share.PieceLength() != 32<<10 {
+		t.Errorf("got piece length %d", share.PieceLength())
+	}
+
+	again, err := sharer.ShareFile("home", path, ShareOptions{Trackers: []string{"http://friends.example/announce"}})
+	if err != nil {
+		t.Fatalf("sharing again: %v", err)
+	}
+	if again.MetaInfo.HashInfoBytes() != share.MetaInfo.HashInfoBytes() {
+		t.Fatal("sharing again built another torrent")
+	}
+	if list := again.MetaInfo.UpvertedAnnounceList(); len(list) != 1 || list[0][0] != "http://friends.example/announce" {
+		t.Errorf("got trackers %v after adding one", list)
+	}
+
+	leecher := newTestTorrent(t, t.TempDir())
+	id, err := leecher.AddMetainfo("leech", again.MetaInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt, _ := leecher.tor.get(id)
+	lt.AddClientPeer(sharer.cl)
+
+	reader := leecher.GetReader(id)
+	if reader == nil {
+		t.Fatal("no reader")
+	}
+	defer (*reader).Close()
+
+	done := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(*reader)
+		done <- got
+	}()
+	select {
+	case got := <-done:
+		if !bytes.Equal(got, data) {
+			t.Fatal("leecher read different data")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out downloading the shared file")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "home video.mp4")); err == nil {
+		t.Error("sharing copied the file into the data directory")
+	}
+
+	// A restart seeds the same torrent again
+	share.Seed().Stop()
+	restarted := newTestTorrent(t, dataDir)
+	restarted.shares.path = sharer.shares.path
+	restarted.restoreShares()
+	restored, ok := restarted.Shared("home")
+	if !ok || restored.Magnet() != again.Magnet() {
+		t.Fatalf("got %v after a restart, want the share with magnet %s", restored, again.Magnet())
+	}
+	restored.Seed().Stop()
+}
+
+func TestAddLeavesSharedTorrentAlone(t *testing.T) {
+	libraryDir := t.TempDir()
+	path := filepath.Join(libraryDir, "home video.mp4")
+	if err := os.WriteFile(path, make([]byte, 64*1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTestTorrent(t, t.TempDir())
+	share, err := tr.ShareFile("home", path, ShareOptions{})
+	if err != nil {
+		t.Fatalf("ShareFile: %v", err)
+	}
+	defer share.Seed().Stop()
+	hash := share.MetaInfo.HashInfoBytes()
+
+	if _, err := tr.AddMagnet("stream", share.Magnet()); !errors.Is(err, ErrSeeding) {
+		t.Errorf("adding the magnet of a share: got %v, want ErrSeeding", err)
+	}
+	if _, err := tr.AddMetainfo("stream", share.MetaInfo); !errors.Is(err, ErrSeeding) {
+		t.Errorf("adding the metainfo of a share: got %v, want ErrSeeding", err)
+	}
+
+	// An add that got the seeded handle from the client, then was rejected
+	seeded, _ := tr.cl.Torrent(hash)
+	if _, _, err := tr.tor.add("stream", "", seeded, 0, false); !errors.Is(err, ErrSeeding) {
+		t.Errorf("registering a seeded handle: got %v, want ErrSeeding", err)
+	}
+	tr.tor.discard(seeded)
+
+	if tr.Has("stream") {
+		t.Error("the shared torrent was registered")
+	}
+	if _, ok := tr.cl.Torrent(hash); !ok {
+		t.Error("the shared torrent was dropped")
+	}
+}
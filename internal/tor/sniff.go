@@ -0,0 +1,134 @@
+package tor
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/media"
+)
+
+const (
+	// sniffTimeout bounds how long sniffing may wait for the first piece of
+	// the files it reads.
+	sniffTimeout = 20 * time.Second
+	// minSniffLength is the size below which a file without a video
+	// extension is not worth fetching the head of.
+	minSniffLength = 1 << 20
+	// maxSniffed is how many of the largest such files are sniffed when a
+	// torrent has no video by extension.
+	maxSniffed = 3
+)
+
+// containers caches the containers sniffed from the head of files. A zero
+// Container marks a file that was sniffed and is not media.
+type containers struct {
+	m sync.Map
+}
+
+func (c *containers) lookup(key fileKey) (media.Container, bool) {
+	v, ok := c.m.Load(key)
+	if !ok {
+		return media.Container{}, false
+	}
+	return v.(media.Container), true
+}
+
+// sniff reads the head of f and caches its container. Nothing is cached when
+// the head could not be read, so the next call tries again.
+func (c *containers) sniff(ctx context.Context, key fileKey, f *torrent.File) (media.Container, bool) {
+	if container, ok := c.lookup(key); ok {
+		return container, container.Name != ""
+	}
+
+	reader := f.NewReader()
+	defer reader.Close()
+	reader.SetContext(ctx)
+	reader.SetResponsive()
+	reader.SetReadahead(media.SniffLen)
+
+	container, ok, err := media.SniffReader(reader)
+	if err != nil {
+		log.Printf("[Sniff] failed to read head of %s: %v", f.DisplayPath(), err)
+		return media.Container{}, false
+	}
+	c.m.Store(key, container)
+	return container, ok
+}
+
+// isVideo reports whether the file at index is a video, by extension or by
+// the container sniffed from it.
+func (tr *Torrent) isVideo(index int, f *torrent.File) bool {
+	if internal.IsVideoFile(filepath.Ext(f.DisplayPath())) {
+		return true
+	}
+	container, _ := tr.containers.lookup(fileKey{f.Torrent().InfoHash(), index})
+	return container.Video
+}
+
+// hasVideo reports whether the files of t, whose info must be known, include
+// at least one video.
+func (tr *Torrent) hasVideo(t *torrent.Torrent) bool {
+	for i, f := range t.Files() {
+		if tr.isVideo(i, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffCandidates returns the indexes of the largest files of t that could be
+// videos under a missing or wrong extension.
+func sniffCandidates(t *torrent.Torrent) []int {
+	files := t.Files()
+
+	var candidates []int
+	for i, f := range files {
+		ext := filepath.Ext(f.DisplayPath())
+		if f.Length() < minSniffLength || internal.IsVideoFile(ext) || internal.IsSubtitleFile(ext) {
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+	slices.SortFunc(candidates, func(a, b int) int {
+		return int(files[b].Length() - files[a].Length())
+	})
+	if len(candidates) > maxSniffed {
+		candidates = candidates[:maxSniffed]
+	}
+	return candidates
+}
+
+// sniffVideos sniffs the candidates of t for video containers, so that
+// torrents whose videos lack a known extension can still be played.
+func (tr *Torrent) sniffVideos(t *torrent.Torrent) {
+	ctx, cancel := context.WithTimeout(context.Background(), sniffTimeout)
+	defer cancel()
+
+	files := t.Files()
+	for _, i := range sniffCandidates(t) {
+		if container, ok := tr.containers.sniff(ctx, fileKey{t.InfoHash(), i}, files[i]); ok && container.Video {
+			log.Printf("[Sniff] %s is %s despite its extension", files[i].DisplayPath(), container.Name)
+		}
+	}
+}
+
+// contentType returns the MIME type to serve the file at index with. Videos
+// are sniffed, so that a mislabeled file is served as what it holds, falling
+// back to the type of the extension if the head cannot be read in time.
+func (tr *Torrent) contentType(index int, f *torrent.File) string {
+	ext := filepath.Ext(f.DisplayPath())
+	if tr.isVideo(index, f) {
+		ctx, cancel := context.WithTimeout(context.Background(), sniffTimeout)
+		defer cancel()
+		if container, ok := tr.containers.sniff(ctx, fileKey{f.Torrent().InfoHash(), index}, f); ok {
+			return container.MimeType
+		}
+	}
+	return media.TypeByExtension(ext)
+}
@@ -0,0 +1,77 @@
+package tor
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestAddMetainfoSniffsExtensionlessVideo(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+
+	// A Matroska header in front of random data, under a name with no
+	// extension
+	data := make([]byte, 2<<20)
+	rand.Read(data)
+	copy(data, "\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska")
+	path := filepath.Join(dataDir, "movie")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 256 << 10}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+
+	id, err := tr.AddMetainfo("sniffed", mi)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	waitForState(t, tr, id, StateReady)
+
+	meta, err := tr.GetMetadata(id)
+	if err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	if !meta.IsVideo || meta.MimeType != "video/x-matroska" {
+		t.Errorf("got is_video %v and type %q, want a matroska video", meta.IsVideo, meta.MimeType)
+	}
+
+	_, junk := writeTestVideo(t, dataDir, "blob.bin", 2<<20, 256<<10)
+	id, err = tr.AddMetainfo("junk", junk)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	waitForState(t, tr, id, StateNoVideoFiles)
+	if _, err := tr.GetMetadata(id); !errors.Is(err, ErrNoVideoFiles) {
+		t.Errorf("metadata of a torrent without videos: got %v", err)
+	}
+}
+
+func waitForState(t *testing.T, tr *Torrent, id string, want State) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		state, err := tr.State(id)
+		if err == nil && state == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %q, %v; want %q", state, err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,160 @@
+package tor
+
+import (
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Stats is a snapshot of a torrent's swarm and of the download progress of
+// one of its files.
+type Stats struct {
+	FileIndex      int     `json:"file_index"`
+	ConnectedPeers int     `json:"connected_peers"`
+	Seeders        int     `json:"seeders"`
+	DownloadRate   float64 `json:"download_rate"` // Bytes per second since the previous sample
+	UploadRate     float64 `json:"upload_rate"`   // Bytes per second since the previous sample
+	BytesCompleted int64   `json:"bytes_completed"`
+	Length         int64   `json:"length"`
+	Pieces         int     `json:"pieces"`
+	PiecesComplete int     `json:"pieces_complete"`
+	// PieceBitmap has one bit per piece of the file, most significant bit
+	// first, set when the piece is complete. Base64 encoded.
+	PieceBitmap string `json:"piece_bitmap"`
+	// BlocklistHits counts the peers the IP blocklist kept out of the whole
+	// client since it was loaded. Always zero without a blocklist.
+	BlocklistHits int64 `json:"blocklist_hits"`
+}
+
+// minSampleInterval keeps rapid polls from computing rates over tiny windows.
+const minSampleInterval = 500 * time.Millisecond
+
+// rateSample is a reading of a torrent's data counters.
+type rateSample struct {
+	at      time.Time
+	read    int64
+	written int64
+}
+
+// rateSampler turns the cumulative anacrolix counters into rates by
+// remembering the previous reading per torrent.
+type rateSampler struct {
+	mu      sync.Mutex
+	samples map[*torrent.Torrent]rateSample
+	rates   map[*torrent.Torrent][2]float64
+}
+
+func newRateSampler() *rateSampler {
+	return &rateSampler{
+		samples: make(map[*torrent.Torrent]rateSample),
+		rates:   make(map[*torrent.Torrent][2]float64),
+	}
+}
+
+// sample records the counters of t read at time at and returns the download
+// and upload rates since the previous sample.
+func (rs *rateSampler) sample(t *torrent.Torrent, st torrent.TorrentStats, at time.Time) (down, up float64) {
+	now := rateSample{
+		at:      at,
+		read:    st.BytesReadData.Int64(),
+		written: st.BytesWrittenData.Int64(),
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	prev, ok := rs.samples[t]
+	if !ok {
+		rs.samples[t] = now
+		return 0, 0
+	}
+
+	elapsed := now.at.Sub(prev.at)
+	if elapsed < minSampleInterval {
+		rates := rs.rates[t]
+		return rates[0], rates[1]
+	}
+
+	down = float64(now.read-prev.read) / elapsed.Seconds()
+	up = float64(now.written-prev.written) / elapsed.Seconds()
+	rs.samples[t] = now
+	rs.rates[t] = [2]float64{down, up}
+	return down, up
+}
+
+// forget drops the samples of torrents that are no longer held.
+func (rs *rateSampler) forget(keep []*torrent.Torrent) {
+	held := make(map[*torrent.Torrent]bool, len(keep))
+	for _, t := range keep {
+		held[t] = true
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for t := range rs.samples {
+		if !held[t] {
+			delete(rs.samples, t)
+			delete(rs.rates, t)
+		}
+	}
+}
+
+// GetStats reports the swarm state of the torrent and the progress of the
+// file at index, or of the main video file when index is MainFile.
+func (tr *Torrent) GetStats(videoId string, index int) (*Stats, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	i, file, err := tr.pickFile(files, videoId, index)
+	if err != nil {
+		return nil, err
+	}
+
+	t := file.Torrent()
+	st := t.Stats()
+	down, up := tr.rates.sample(t, st, time.Now())
+
+	begin, end := file.BeginPieceIndex(), file.EndPieceIndex()
+	bitmap := make([]byte, (end-begin+7)/8)
+	complete := 0
+
+	piece := 0
+	for _, run := range t.PieceStateRuns() {
+		from, to := max(piece, begin), min(piece+run.Length, end)
+		if run.Complete {
+			for p := from; p < to; p++ {
+				bit := p - begin
+				bitmap[bit/8] |= 0x80 >> (bit % 8)
+				complete++
+			}
+		}
+		piece += run.Length
+		if piece >= end {
+			break
+		}
+	}
+
+	var blocked int64
+	if tr.blocklist != nil {
+		blocked = tr.blocklist.hits.Load()
+	}
+
+	return &Stats{
+		FileIndex:      i,
+		ConnectedPeers: st.ActivePeers,
+		Seeders:        st.ConnectedSeeders,
+		DownloadRate:   down,
+		UploadRate:     up,
+		BytesCompleted: file.BytesCompleted(),
+		Length:         file.Length(),
+		Pieces:         end - begin,
+		PiecesComplete: complete,
+		PieceBitmap:    base64.StdEncoding.EncodeToString(bitmap),
+		BlocklistHits:  blocked,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package tor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestRateSampler(t *testing.T) {
+	counters := func(read, written int64) torrent.TorrentStats {
+		var st torrent.TorrentStats
+		st.BytesReadData.Add(read)
+		st.BytesWrittenData.Add(written)
+		return st
+	}
+
+	rs := newRateSampler()
+	tt := &torrent.Torrent{}
+	start := time.Now()
+
+	if down, up := rs.sample(tt, counters(1000, 1000), start); down != 0 || up != 0 {
+		t.Errorf("first sample = %v, %v; want no rates yet", down, up)
+	}
+	// Too soon after the previous sample to tell
+	if down, up := rs.sample(tt, counters(5000, 1000), start.Add(100*time.Millisecond)); down != 0 || up != 0 {
+		t.Errorf("sample within %s = %v, %v; want the previous rates", minSampleInterval, down, up)
+	}
+	if down, up := rs.sample(tt, counters(9000, 2000), start.Add(2*time.Second)); down != 4000 || up != 500 {
+		t.Errorf("sample after 2s = %v, %v; want 4000, 500", down, up)
+	}
+	if down, up := rs.sample(tt, counters(9500, 2000), start.Add(2100*time.Millisecond)); down != 4000 || up != 500 {
+		t.Errorf("sample right after = %v, %v; want the previous rates", down, up)
+	}
+
+	rs.forget(nil)
+	if down, up := rs.sample(tt, counters(20000, 3000), start.Add(4*time.Second)); down != 0 || up != 0 {
+		t.Errorf("sample after forget = %v, %v; want no rates yet", down, up)
+	}
+}
+
+func TestGetStatsPieceBitmap(t *testing.T) {
+	const pieceLength = 16 << 10
+
+	// The video starts within the second piece and ends in the eighth, the
+	// last one
+	dataDir := t.TempDir()
+	mi := writeTestPack(t, dataDir, "show", map[string]int{"a.nfo": 24 << 10, "b.mp4": 100 << 10}, pieceLength)
+
+	// Corrupt the fifth piece of the torrent, the fourth of the video
+	f, err := os.OpenFile(filepath.Join(dataDir, "show", "b.mp4"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(bytes.Repeat([]byte{0}, pieceLength), 4*pieceLength-(24<<10))
+	f.Close()
+
+	tr := newTestTorrent(t, dataDir)
+	id, err := tr.AddMetainfo("show", mi)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	tt, _ := tr.tor.get(id)
+	if err := tt.VerifyData(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	stats, err := tr.GetStats(id, MainFile)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.FileIndex != 1 || stats.Length != 100<<10 {
+		t.Errorf("got stats of file %d of %d bytes, want the video", stats.FileIndex, stats.Length)
+	}
+	if stats.Pieces != 7 || stats.PiecesComplete != 6 {
+		t.Errorf("got %d of %d pieces complete, want 6 of 7", stats.PiecesComplete, stats.Pieces)
+	}
+	if want := base64.StdEncoding.EncodeToString([]byte{0b11101110}); stats.PieceBitmap != want {
+		t.Errorf("got piece bitmap %s, want %s", stats.PieceBitmap, want)
+	}
+}
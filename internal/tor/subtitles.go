@@ -0,0 +1,403 @@
+package tor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/mkv"
+	"github.com/scythe504/webtorrent/internal/subtitles"
+)
+
+// maxSubtitleSize caps how much of a subtitle file is read into memory.
+const maxSubtitleSize = 8 << 20
+
+// embeddedReadahead keeps readers of Matroska headers and subtitle blocks
+// from fetching much more than the elements they read.
+const embeddedReadahead = 64 << 10
+
+// Sources of subtitle tracks.
+const (
+	// SourceSidecar marks subtitle tracks read from a separate file in the
+	// torrent.
+	SourceSidecar = "sidecar"
+	// SourceEmbedded marks subtitle tracks stored inside a Matroska video.
+	SourceEmbedded = "embedded"
+)
+
+// ErrSubtitleNotFound is returned for track ids that do not name a subtitle
+// track of the torrent.
+var ErrSubtitleNotFound = errors.New("subtitle track not found")
+
+// SubtitleTrack is a subtitle track that can be served as WebVTT.
+type SubtitleTrack struct {
+	Id        string `json:"id"`         // e.g. "f3" for the sidecar file at index 3, "m0-3" for track 3 of file 0
+	FileIndex int    `json:"file_index"` // File holding the track
+	Label     string `json:"label"`      // e.g. "English" or "en.forced"
+	Language  string `json:"language,omitempty"`
+	Format    string `json:"format"` // Format of the source, "srt", "ass" or "vtt"
+	Source    string `json:"source"`
+}
+
+// sidecarId returns the track id of the sidecar subtitle file at index.
+func sidecarId(index int) string {
+	return "f" + strconv.Itoa(index)
+}
+
+// embeddedId returns the track id of track number of the Matroska file at
+// index.
+func embeddedId(index int, number uint64) string {
+	return fmt.Sprintf("m%d-%d", index, number)
+}
+
+// subtitleDirs are folder names torrents commonly keep subtitles in.
+var subtitleDirs = map[string]bool{
+	"subs":      true,
+	"subtitles": true,
+	"sub":       true,
+}
+
+// sidecarSubtitles returns the subtitle files that belong to the video at
+// index. A subtitle belongs to a video when it sits next to the video or in a
+// Subs folder next to it, and either its name starts with the video's name,
+// e.g. "Movie.en.srt" for "Movie.mkv", or the video is the only one there. It
+// also belongs when it sits in a Subs/<video name> folder. Season packs reuse
+// episode names across folders, so names alone never match across them.
+func sidecarSubtitles(files []*torrent.File, index int) []SubtitleTrack {
+	videoPath := files[index].DisplayPath()
+	videoDir := path.Dir(videoPath)
+	videoStem := stem(videoPath)
+
+	// Videos per directory decide whether loose subtitles can be attributed
+	videosIn := make(map[string]int)
+	for _, f := range files {
+		if internal.IsVideoFile(path.Ext(f.DisplayPath())) {
+			videosIn[path.Dir(f.DisplayPath())]++
+		}
+	}
+
+	var tracks []SubtitleTrack
+	for i, f := range files {
+		subPath := f.DisplayPath()
+		format := subtitles.FormatFromExtension(path.Ext(subPath))
+		if format == "" {
+			continue
+		}
+
+		subDir, subStem := path.Dir(subPath), stem(subPath)
+		inSubsDir, named := subtitleDir(subDir, videoDir, videoStem)
+		if subDir != videoDir && !inSubsDir {
+			continue
+		}
+		label, ok := "", false
+
+		switch {
+		case strings.EqualFold(subStem, videoStem):
+			ok = true
+		case len(subStem) > len(videoStem) && strings.EqualFold(subStem[:len(videoStem)+1], videoStem+"."):
+			ok, label = true, subStem[len(videoStem)+1:]
+		case named:
+			ok, label = true, subStem
+		default:
+			ok, label = videosIn[videoDir] == 1, subStem
+		}
+		if !ok {
+			continue
+		}
+
+		if label == "" {
+			label = "Subtitles"
+		}
+		tracks = append(tracks, SubtitleTrack{
+			Id:        sidecarId(i),
+			FileIndex: i,
+			Label:     label,
+			Language:  languageCode(label),
+			Format:    format,
+			Source:    SourceSidecar,
+		})
+	}
+
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].FileIndex < tracks[j].FileIndex })
+	return tracks
+}
+
+// subtitleDir reports whether dir is a subtitle folder next to a video in
+// videoDir, such as "Subs", and whether it is named after the video, such as
+// "Subs/<video name>".
+func subtitleDir(dir, videoDir, videoStem string) (ok, named bool) {
+	rel := dir
+	if videoDir != "." {
+		if !strings.HasPrefix(dir, videoDir+"/") {
+			return false, false
+		}
+		rel = dir[len(videoDir)+1:]
+	}
+
+	first, rest, _ := strings.Cut(rel, "/")
+	if !subtitleDirs[strings.ToLower(first)] {
+		return false, false
+	}
+	if rest == "" {
+		return true, false
+	}
+	return strings.EqualFold(rest, videoStem), true
+}
+
+// languageCode returns the leading 2 or 3 letter language code of a label
+// like "en" or "eng.forced", or "" when it does not start with one.
+func languageCode(label string) string {
+	code, _, _ := strings.Cut(label, ".")
+	if len(code) < 2 || len(code) > 3 {
+		return ""
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return code
+}
+
+func stem(p string) string {
+	base := path.Base(p)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// isMatroska reports whether the file at p may hold embedded tracks.
+func isMatroska(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".mkv", ".webm":
+		return true
+	}
+	return false
+}
+
+// trackProbe is a lookup of the subtitle tracks of a Matroska file. tracks is
+// set before done is closed.
+type trackProbe struct {
+	done   chan struct{}
+	tracks []mkv.Track
+}
+
+// embeddedTracks caches the text subtitle tracks found in Matroska files.
+type embeddedTracks struct {
+	m sync.Map
+}
+
+// probe returns the lookup of the tracks of f, starting it in the background
+// the first time f is seen. Failed lookups are forgotten so they can be
+// retried once more of the torrent is reachable.
+func (et *embeddedTracks) probe(key fileKey, f *torrent.File) *trackProbe {
+	v, loaded := et.m.LoadOrStore(key, &trackProbe{done: make(chan struct{})})
+	p := v.(*trackProbe)
+	if loaded {
+		return p
+	}
+
+	go func() {
+		defer close(p.done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+
+		reader := f.NewReader()
+		defer reader.Close()
+		reader.SetContext(ctx)
+		reader.SetResponsive()
+		reader.SetReadahead(embeddedReadahead)
+
+		tracks, err := mkv.SubtitleTracks(reader)
+		if err != nil {
+			log.Printf("[Subtitles] could not read the tracks of %s: %v", f.DisplayPath(), err)
+			et.m.Delete(key)
+			return
+		}
+		p.tracks = tracks
+	}()
+	return p
+}
+
+// embeddedSubtitles turns the text subtitle tracks of the file at index into
+// subtitle tracks.
+func embeddedSubtitles(index int, tracks []mkv.Track) []SubtitleTrack {
+	list := make([]SubtitleTrack, 0, len(tracks))
+	for _, t := range tracks {
+		label := t.Name
+		if label == "" {
+			label = t.Language
+		}
+		language := t.Language
+		if language == "und" {
+			language = ""
+		}
+
+		list = append(list, SubtitleTrack{
+			Id:        embeddedId(index, t.Number),
+			FileIndex: index,
+			Label:     label,
+			Language:  language,
+			Format:    codecFormat(t.CodecID),
+			Source:    SourceEmbedded,
+		})
+	}
+	return list
+}
+
+func codecFormat(codec string) string {
+	switch codec {
+	case mkv.CodecWebVTT:
+		return subtitles.FormatWebVTT
+	case mkv.CodecSRT:
+		return subtitles.FormatSRT
+	}
+	return subtitles.FormatASS
+}
+
+// videoSubtitles lists the sidecar subtitles of the video at index, and its
+// embedded tracks when they have been probed. When wait is false the probe
+// runs in the background and its tracks show up on a later call.
+func (tr *Torrent) videoSubtitles(ctx context.Context, files []*torrent.File, index int, wait bool) ([]SubtitleTrack, error) {
+	tracks := sidecarSubtitles(files, index)
+
+	file := files[index]
+	if !isMatroska(file.DisplayPath()) {
+		return tracks, nil
+	}
+
+	p := tr.embedded.probe(fileKey{hash: file.Torrent().InfoHash(), index: index}, file)
+	if wait {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case <-p.done:
+		tracks = append(tracks, embeddedSubtitles(index, p.tracks)...)
+	default:
+	}
+	return tracks, nil
+}
+
+// GetSubtitles lists the subtitle tracks of the video at index, or of the
+// main video file when index is MainFile. It waits for the tracks embedded in
+// Matroska files to be read.
+func (tr *Torrent) GetSubtitles(ctx context.Context, videoId string, index int) ([]SubtitleTrack, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	i, _, err := tr.pickFile(files, videoId, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return tr.videoSubtitles(ctx, files, i, true)
+}
+
+// Subtitle returns the subtitle track trackId of the torrent as WebVTT.
+func (tr *Torrent) Subtitle(ctx context.Context, videoId, trackId string) ([]byte, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(trackId, "m") {
+		return tr.embeddedSubtitle(ctx, videoId, files, trackId)
+	}
+
+	index, err := strconv.Atoi(strings.TrimPrefix(trackId, "f"))
+	if !strings.HasPrefix(trackId, "f") || err != nil || index < 0 || index >= len(files) {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, trackId)
+	}
+	format := subtitles.FormatFromExtension(path.Ext(files[index].DisplayPath()))
+	if format == "" {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, trackId)
+	}
+
+	data, err := tr.readFile(ctx, videoId, index, maxSubtitleSize)
+	if err != nil {
+		return nil, err
+	}
+	return subtitles.ToWebVTT(data, format)
+}
+
+// embeddedSubtitle extracts track "m<file>-<track>" from a Matroska file.
+func (tr *Torrent) embeddedSubtitle(ctx context.Context, videoId string, files []*torrent.File, trackId string) ([]byte, error) {
+	rawIndex, rawNumber, _ := strings.Cut(strings.TrimPrefix(trackId, "m"), "-")
+	index, err := strconv.Atoi(rawIndex)
+	if err != nil || index < 0 || index >= len(files) || !isMatroska(files[index].DisplayPath()) {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, trackId)
+	}
+	number, err := strconv.ParseUint(rawNumber, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, trackId)
+	}
+
+	tracks, err := tr.videoSubtitles(ctx, files, index, true)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(tracks, func(t SubtitleTrack) bool { return t.Id == trackId }) {
+		return nil, fmt.Errorf("%w: %s", ErrSubtitleNotFound, trackId)
+	}
+
+	var cues []subtitles.Cue
+	err = tr.withReader(ctx, videoId, index, func(_ *torrent.File, r torrent.Reader) error {
+		r.SetReadahead(embeddedReadahead)
+		cues, err = mkv.ReadSubtitles(r, number)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subtitles.WriteWebVTT(cues), nil
+}
+
+// readFile reads the whole file at index, which must not be larger than
+// limit.
+func (tr *Torrent) readFile(ctx context.Context, videoId string, index int, limit int64) ([]byte, error) {
+	var data []byte
+	err := tr.withReader(ctx, videoId, index, func(file *torrent.File, r torrent.Reader) error {
+		if file.Length() > limit {
+			return fmt.Errorf("%s is larger than %d bytes", file.DisplayPath(), limit)
+		}
+
+		var err error
+		data, err = io.ReadAll(r)
+		return err
+	})
+	return data, err
+}
+
+// withReader calls fn with a responsive reader of the file at index that
+// stops when ctx is done. The torrent counts as in use while fn runs.
+func (tr *Torrent) withReader(ctx context.Context, videoId string, index int, fn func(*torrent.File, torrent.Reader) error) error {
+	e, ok := tr.tor.acquire(videoId)
+	if !ok {
+		return fmt.Errorf("%w for videoId: %s", tr.lookupErr(videoId), videoId)
+	}
+	defer tr.tor.release(e)
+
+	file := e.t.Files()[index]
+	reader := file.NewReader()
+	defer reader.Close()
+	reader.SetContext(ctx)
+	reader.SetResponsive()
+
+	return fn(file, reader)
+}
@@ -0,0 +1,96 @@
+package tor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// testFiles returns the files of a torrent holding the given paths, without
+// any data.
+func testFiles(t *testing.T, paths ...string) []*torrent.File {
+	t.Helper()
+
+	info := metainfo.Info{Name: "pack", PieceLength: 16 << 10}
+	for _, p := range paths {
+		info.Files = append(info.Files, metainfo.FileInfo{Length: 1, Path: strings.Split(p, "/")})
+	}
+	info.Pieces = make([]byte, 20)
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTestTorrent(t, t.TempDir())
+	tt, err := tr.cl.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tt.Files()
+}
+
+func TestSidecarSubtitles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		video string
+		want  map[string]string // Path to label
+	}{
+		{
+			name:  "same dir",
+			files: []string{"Movie.mkv", "Movie.srt", "Movie.en.srt", "Movie.eng.forced.ass", "Other.nfo"},
+			video: "Movie.mkv",
+			want:  map[string]string{"Movie.srt": "Subtitles", "Movie.en.srt": "en", "Movie.eng.forced.ass": "eng.forced"},
+		},
+		{
+			name:  "subs dir",
+			files: []string{"Movie.mkv", "Subs/English.srt", "Subs/fr.srt"},
+			video: "Movie.mkv",
+			want:  map[string]string{"Subs/English.srt": "English", "Subs/fr.srt": "fr"},
+		},
+		{
+			name:  "subs dir named after the video",
+			files: []string{"E01.mkv", "E02.mkv", "Subs/E01/2_English.srt", "Subs/E02/2_English.srt"},
+			video: "E01.mkv",
+			want:  map[string]string{"Subs/E01/2_English.srt": "2_English"},
+		},
+		{
+			name:  "multi-video dir",
+			files: []string{"E01.mkv", "E02.mkv", "E01.en.srt", "E02.en.srt", "Subs/E01.srt", "English.srt", "Subs/English.srt"},
+			video: "E01.mkv",
+			want:  map[string]string{"E01.en.srt": "en", "Subs/E01.srt": "Subtitles"},
+		},
+		{
+			name:  "cross directory",
+			files: []string{"S01/E01.mkv", "S01/E01.srt", "S01/Subs/E01.en.srt", "S02/E01.mkv", "S02/E01.fr.srt", "E01.srt", "Extras/Subs/E01.srt"},
+			video: "S02/E01.mkv",
+			want:  map[string]string{"S02/E01.fr.srt": "fr"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := testFiles(t, tt.files...)
+			index := -1
+			for i, f := range files {
+				if f.DisplayPath() == tt.video {
+					index = i
+				}
+			}
+			if index < 0 {
+				t.Fatalf("no file %s", tt.video)
+			}
+
+			got := make(map[string]string)
+			for _, track := range sidecarSubtitles(files, index) {
+				got[files[track.FileIndex].DisplayPath()] = track.Label
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
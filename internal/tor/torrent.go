@@ -1,6 +1,7 @@
 package tor
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,27 +10,69 @@ import (
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/media"
 )
 
 type Torrent struct {
-	cl  *torrent.Client
-	tor map[string]*torrent.Torrent
+	cl       *torrent.Client
+	tor      *registry
+	cfg      Config
+	bitrates bitrates
+	embedded embeddedTracks
+	// containers caches what sniffing found in the head of files.
+	containers containers
+	pins       *pins
+	rates      *rateSampler
+	// bandwidth holds the rate limiters handed to the client and the
+	// per-video limits.
+	bandwidth *bandwidth
+	// trackers follows the announce results of the trackers.
+	trackers *trackerMonitor
+	// infoCache lets known magnet links skip fetching their metadata.
+	infoCache *metainfoCache
+	// blocklist keeps peers in blocked IP ranges out, nil unless
+	// Config.Blocklist is set.
+	blocklist *blocklist
+	// memory holds the torrent data when Config.Storage is StorageMemory,
+	// nil otherwise.
+	memory *memoryStorage
+	// shares are the local files seeded as torrents of their own.
+	shares *shares
+	// sessions persists the registered ids, nil unless Config.SessionFile is
+	// set.
+	sessions *sessionStore
 }
 
 type FileMetadata struct {
+	Index     int    `json:"index"`     // Position of the file within the torrent
 	Name      string `json:"name"`      // e.g. "movie.mkv"
 	Path      string `json:"path"`      // Full path within torrent
 	Length    int64  `json:"length"`    // File size in bytes
 	Extension string `json:"extension"` // e.g. ".mp4"
+	Type      string `json:"type"`      // "video", "subtitle" or "other"
 	IsVideo   bool   `json:"is_video"`  // Whether it's a recognized video format
+	MimeType  string `json:"mime_type"` // e.g. "video/x-matroska", "" if unknown
+	Selected  bool   `json:"selected"`  // False when the magnet link's so= left it out
+	// Subtitles lists the tracks found for a video file.
+	Subtitles []SubtitleTrack `json:"subtitles,omitempty"`
 }
 
-func New(port int) Torrent {
+// MainFile selects the largest video file wherever a file index is expected.
+const MainFile = -1
+
+const (
+	FileTypeVideo    = "video"
+	FileTypeSubtitle = "subtitle"
+	FileTypeOther    = "other"
+)
+
+func New(c Config) *Torrent {
 	cfg := torrent.NewDefaultClientConfig()
 
 	// Networking
-	cfg.ListenPort = port
+	cfg.ListenPort = c.Port
 	cfg.DisableIPv6 = true
 	cfg.DisableUTP = false
 	cfg.DisableAggressiveUpload = true
@@ -39,111 +82,297 @@ func New(port int) Torrent {
 	// Performance tuning
 	cfg.MinDialTimeout = 5 * time.Second
 
-	// Try environment override first (for flexibility)
-	dataDir := os.Getenv("DOWNLOAD_PATH")
-	if dataDir == "" {
-		// Default to Docker path if not specified
-		dataDir = "/app/fluxstream/download"
-
-		// Fallback for local dev environments
-		if _, err := os.Stat(dataDir); os.IsNotExist(err) {
-			if home, err := os.UserHomeDir(); err == nil {
-				dataDir = filepath.Join(home, ".local", "share", "fluxstream", "downloads")
-			}
+	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	cfg.DataDir = c.DataDir
+
+	var memory *memoryStorage
+	switch c.Storage {
+	case "", StorageDisk:
+	case StorageMemory:
+		if c.MemoryCache <= 0 {
+			log.Fatalf("memory storage needs a positive cache size, got %d", c.MemoryCache)
 		}
+		memory = newMemoryStorage(c.MemoryCache)
+		cfg.DefaultStorage = memory
+	default:
+		log.Fatalf("unknown storage %q, want %q or %q", c.Storage, StorageDisk, StorageMemory)
 	}
 
-	cfg.DataDir = dataDir
+	bw := newBandwidth(Limits{Download: c.DownloadLimit, Upload: c.UploadLimit})
+	cfg.DownloadRateLimiter = bw.download
+	cfg.UploadRateLimiter = bw.upload
+
+	// Privacy
+	if err := applyEncryption(cfg, c.Encryption); err != nil {
+		log.Fatal(err)
+	}
+	var proxyDialer torrent.Dialer
+	if c.Proxy != "" {
+		d, err := applyProxy(cfg, c.Proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxyDialer = d
+	}
+	var blocked *blocklist
+	if c.Blocklist != "" {
+		blocked = newBlocklist(c.Blocklist)
+		if err := blocked.load(); err != nil {
+			log.Fatal(err)
+		}
+		cfg.IPBlocklist = blocked
+	}
 
 	client, err := torrent.NewClient(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if proxyDialer != nil {
+		client.AddDialer(proxyDialer)
+	}
 
-	return Torrent{
-		cl:  client,
-		tor: make(map[string]*torrent.Torrent),
+	tr := newTorrent(client, c)
+	tr.bandwidth = bw
+	tr.blocklist = blocked
+	tr.memory = memory
+	if tr.sessions != nil {
+		tr.restoreSessions()
+	}
+	if tr.shares.path != "" {
+		tr.restoreShares()
 	}
+	go tr.manageLifecycle()
+	go tr.enforceLimits()
+	go tr.monitorTrackers()
+	if tr.blocklist != nil {
+		go tr.watchBlocklist()
+	}
+
+	return tr
 }
 
-func (tr *Torrent) AddMagnet(id, magnetLink string) error {
-	t, err := tr.cl.AddMagnet(magnetLink)
+// newTorrent wraps an anacrolix client. The bandwidth limiters have to be in
+// the client config before the client exists, so callers that limit transfer
+// rates build them and set bandwidth themselves.
+func newTorrent(cl *torrent.Client, c Config) *Torrent {
+	tr := &Torrent{
+		cl:    cl,
+		tor:   newRegistry(),
+		cfg:   c,
+		pins:  newPins(),
+		rates: newRateSampler(),
+
+		trackers: newTrackerMonitor(),
+		shares:   newShares(c.ShareFile),
+
+		infoCache: newMetainfoCache(c.DataDir),
+	}
+	if c.SessionFile != "" {
+		tr.sessions = &sessionStore{path: c.SessionFile}
+	}
+	return tr
+}
+
+// AddMagnet adds a magnet link under id and returns the id the torrent is
+// registered under. It returns as soon as the torrent is added: the metadata
+// resolves in the background, see State. trackers are added on top of the
+// ones of the link and the configured defaults. A torrent that is already
+// active keeps its existing id, which is returned instead of id, and gets the
+// extra trackers. It fails with ErrTooManyTorrents when MaxActive is reached
+// and no idle torrent may be evicted, and with ErrSeeding for a torrent that
+// is being seeded.
+func (tr *Torrent) AddMagnet(id, magnetLink string, trackers ...string) (string, error) {
+	registered, created, err := tr.addMagnet(id, magnetLink, trackers, true)
+	if created {
+		tr.saveSessions()
+	}
+	return registered, err
+}
+
+// addMagnet is AddMagnet without persisting the sessions, and also reports
+// whether a new id was registered. The default trackers are only added when
+// defaults is set.
+func (tr *Torrent) addMagnet(id, magnetLink string, trackers []string, defaults bool) (string, bool, error) {
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnetLink)
 	if err != nil {
-		return fmt.Errorf("failed to add magnet: %w", err)
+		return "", false, fmt.Errorf("failed to add magnet: %w", err)
+	}
+	only, err := selectOnly(magnetLink)
+	if err != nil {
+		return "", false, err
+	}
+	trackers, err = validTrackers(trackers)
+	if err != nil {
+		return "", false, err
+	}
+	if existing, ok := tr.tor.idForHash(spec.InfoHash); ok {
+		tr.addTrackers(existing, trackers)
+		return existing, false, nil
+	}
+	if tr.tor.seeding(spec.InfoHash) {
+		return "", false, fmt.Errorf("%w for id: %s", ErrSeeding, id)
 	}
 
-	// Wait for torrent metadata (with timeout)
-	select {
-	case <-t.GotInfo():
-	case <-time.After(10 * time.Second):
-		t.Drop()
-		return fmt.Errorf("timeout waiting for metadata for id: %s", id)
+	if tr.tor.full(tr.cfg.MaxActive) && !tr.cfg.EvictLRU {
+		return "", false, ErrTooManyTorrents
 	}
 
-	files := t.Files()
-	if len(files) == 0 {
-		t.Drop()
-		return fmt.Errorf("no files found in torrent for id: %s", id)
+	// Metadata resolved before does not have to come from peers again
+	if mi, ok := tr.infoCache.load(spec.InfoHash); ok {
+		spec.InfoBytes = mi.InfoBytes
+		spec.Trackers = append(spec.Trackers, mi.UpvertedAnnounceList()...)
 	}
+	// Whether the torrent is private is only known once its info is, see
+	// resolve
+	spec.Trackers = mergeTiers(spec.Trackers, tr.extraTrackers(trackers, defaults))
 
-	// Check if at least one valid video file exists
-	hasVideo := false
-	for _, f := range files {
-		if internal.IsVideoFile(filepath.Ext(f.DisplayPath())) {
-			hasVideo = true
-			break
-		}
+	t, _, err := tr.cl.AddTorrentSpec(spec)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to add magnet: %w", err)
 	}
 
-	if !hasVideo {
-		t.Drop() // prevent keeping useless torrents
-		return fmt.Errorf("no valid video files found for id: %s", id)
+	source := magnetLink
+	if len(trackers) > 0 || defaults && len(tr.cfg.Trackers) > 0 {
+		source = magnetFor(t)
+	}
+	e, created, err := tr.tor.add(id, source, t, tr.cfg.MaxActive, tr.cfg.EvictLRU)
+	if err != nil {
+		tr.tor.discard(t)
+		return "", false, err
 	}
+	if created {
+		tr.tor.setSelection(e, only)
+		go tr.resolve(e)
+	}
+	return e.id, created, nil
+}
 
-	// Save torrent handle
-	tr.tor[id] = t
-	return nil
+// AddMetainfo adds a torrent from its metainfo, e.g. a .torrent file, under
+// id. The info is already known, so it is validated right away and the
+// torrent starts out ready. Like AddMagnet, it adds trackers and the defaults
+// (unless the torrent is private), and returns the existing id of a torrent
+// that is already active.
+func (tr *Torrent) AddMetainfo(id string, mi *metainfo.MetaInfo, trackers ...string) (string, error) {
+	trackers, err := validTrackers(trackers)
+	if err != nil {
+		return "", err
+	}
+	if existing, ok := tr.tor.idForHash(mi.HashInfoBytes()); ok {
+		tr.addTrackers(existing, trackers)
+		return existing, nil
+	}
+	if tr.tor.seeding(mi.HashInfoBytes()) {
+		return "", fmt.Errorf("%w for id: %s", ErrSeeding, id)
+	}
+
+	if tr.tor.full(tr.cfg.MaxActive) && !tr.cfg.EvictLRU {
+		return "", ErrTooManyTorrents
+	}
+
+	t, err := tr.cl.AddTorrent(mi)
+	if err != nil {
+		return "", fmt.Errorf("failed to add torrent: %w", err)
+	}
+
+	// Files without a video extension are sniffed once resolving, which
+	// needs peers
+	sniff := !tr.hasVideo(t)
+	if sniff && len(sniffCandidates(t)) == 0 {
+		tr.tor.discard(t) // prevent keeping useless torrents
+		return "", fmt.Errorf("%w for id: %s", ErrNoVideoFiles, id)
+	}
+
+	tiers := mergeTiers(mi.UpvertedAnnounceList(), tr.extraTrackers(trackers, !isPrivate(t)))
+	t.ModifyTrackers(tiers)
+
+	withTrackers := *mi
+	withTrackers.Announce, withTrackers.AnnounceList = "", tiers
+	e, created, err := tr.tor.add(id, sourceMagnet(&withTrackers), t, tr.cfg.MaxActive, tr.cfg.EvictLRU)
+	if err != nil {
+		tr.tor.discard(t)
+		return "", err
+	}
+	if created && sniff {
+		tr.saveSessions()
+		go tr.resolve(e)
+	} else if created {
+		tr.tor.resolve(e, StateReady, nil)
+		tr.infoCache.store(t)
+		tr.saveSessions()
+	}
+	return e.id, nil
 }
+
+// Has reports whether id is registered.
+func (tr *Torrent) Has(id string) bool {
+	_, ok := tr.tor.get(id)
+	return ok
+}
+
+// GetReader returns a reader over the main video file of the torrent.
 func (tr *Torrent) GetReader(id string) *torrent.Reader {
+	return tr.GetFileReader(id, MainFile)
+}
+
+// GetFileReader returns a reader over the file at index, or over the main
+// video file when index is MainFile. The reader is tuned for playback, see
+// playbackReader. The torrent stays active until the reader is closed, even
+// if CleanupTorrent is called in the meantime.
+func (tr *Torrent) GetFileReader(id string, index int) *torrent.Reader {
 	// Ensure torrent exists
-	t, ok := tr.tor[id]
-	if !ok || t == nil {
+	e, ok := tr.tor.acquire(id)
+	if !ok {
 		return nil
 	}
+	t := e.t
 
-	// Wait for metadata
-	select {
-	case <-t.GotInfo():
-	case <-time.After(15 * time.Second):
-		log.Printf("[GetReader] timeout waiting for metadata: %s", id)
+	// Wait for metadata, for as long as the torrent is allowed to resolve
+	if !awaitResolved(e) || tr.tor.stateOf(e) != StateReady {
+		log.Printf("[GetReader] metadata did not resolve for id: %s", id)
+		tr.tor.release(e)
 		return nil
 	}
 
-	// Get the main video file
-	mainFile, err := tr.GetMainVideoFile(id)
+	i, file, err := tr.pickFile(t.Files(), id, index)
 	if err != nil {
-		log.Printf("[GetReader] failed to get main video file: %v", err)
+		log.Printf("[GetReader] failed to get file: %v", err)
+		tr.tor.release(e)
 		return nil
 	}
 
-	// Find the file that matches the path in metadata
-	reader := mainFile.NewReader()
-	if reader == nil {
-		log.Printf("[GetReader] failed to create reader for file: %s", mainFile.DisplayPath())
-		return nil
+	if tr.tor.selectFile(e, i) {
+		tr.saveSessions()
+	}
+
+	key := fileKey{hash: t.InfoHash(), index: i}
+	bitrate := func() int64 { return tr.bitrates.get(key, file) }
+
+	playback := newPlaybackReader(t, file, tr.pins, bitrate)
+	if tr.memory != nil {
+		// A window larger than the cache would evict itself before it is read
+		playback.maxReadahead = tr.memory.capacity / 4
+	}
+
+	var reader torrent.Reader = &trackedReader{
+		Reader: playback,
+		rg:     tr.tor,
+		e:      e,
 	}
 	return &reader
 }
+
 func (tr *Torrent) GetMagnetLink(videoId string) *string {
-	t, ok := tr.tor[videoId]
-	if !ok || t == nil {
+	t, ok := tr.tor.get(videoId)
+	if !ok {
 		log.Printf("[GetMagnetLink] torrent not found for id: %s", videoId)
 		return nil
 	}
 
-	metainfo := t.Metainfo()
+	mi := t.Metainfo()
 
-	magnetV2, err := metainfo.MagnetV2()
+	magnetV2, err := mi.MagnetV2()
 	if err != nil {
 		log.Printf("[GetMagnetLink] failed to get magnet V2: %v", err)
 		return nil
@@ -153,51 +382,63 @@ func (tr *Torrent) GetMagnetLink(videoId string) *string {
 	return &magnetURI
 }
 
-// CleanupTorrent safely stops and removes a torrent from memory and disk cache.
+// CleanupTorrent removes a torrent from the registry. Its download is stopped
+// immediately, or once the last open reader on it is closed.
 func (tr *Torrent) CleanupTorrent(videoId string) error {
-	// Ensure torrent exists
-	t, ok := tr.tor[videoId]
-	if !ok || t == nil {
+	ok, remaining := tr.tor.remove(videoId)
+	if !ok {
 		log.Printf("[CleanupTorrent] no active torrent found for id: %s", videoId)
 		return nil
 	}
+	tr.saveSessions()
 
-	// Stop all activity on the torrent
-	defer func() {
-		delete(tr.tor, videoId)
-		log.Printf("[CleanupTorrent] cleaned up torrent for id: %s", videoId)
-	}()
-
-	// Attempt to close all readers
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("[CleanupTorrent] recovered while closing readers for id %s: %v", videoId, r)
-			}
-		}()
-		for _, f := range t.Files() {
-			f.DisplayPath() // touch file to ensure safety
-		}
-	}()
-
-	// Stop the torrent download
-	t.Drop()
+	if remaining > 0 {
+		log.Printf("[CleanupTorrent] removed id %s, torrent kept alive by %d open reference(s)", videoId, remaining)
+		return nil
+	}
 
+	log.Printf("[CleanupTorrent] cleaned up torrent for id: %s", videoId)
 	return nil
 }
 
+// RemoveTorrent unregisters videoId like CleanupTorrent, then deletes the data
+// of its torrent from DataDir unless another id or an open reader still holds
+// the torrent.
+func (tr *Torrent) RemoveTorrent(videoId string) error {
+	t, ok := tr.tor.get(videoId)
+	if err := tr.CleanupTorrent(videoId); err != nil || !ok {
+		return err
+	}
+	if tr.tor.inUse(t) {
+		return nil
+	}
+	return tr.removeData(t.Name())
+}
+
 // GetMainVideoFile returns the largest valid video file in the torrent.
 func (tr *Torrent) GetMainVideoFile(videoId string) (*torrent.File, error) {
-	t, ok := tr.tor[videoId]
-	if !ok {
-		return nil, fmt.Errorf("torrent not found for videoId: %s", videoId)
+	_, file, err := tr.mainVideoFile(videoId)
+	return file, err
+}
+
+// mainVideoFile returns the index and handle of the largest video file.
+func (tr *Torrent) mainVideoFile(videoId string) (int, *torrent.File, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return 0, nil, err
 	}
+	return tr.pickFile(files, videoId, MainFile)
+}
 
-	// Wait for metadata
-	select {
-	case <-t.GotInfo():
-	case <-time.After(5 * time.Second):
-		return nil, fmt.Errorf("timeout waiting for torrent metadata for videoId: %s", videoId)
+// files returns the files of a resolved torrent in torrent order. It fails
+// with ErrResolving rather than waiting while the metadata is still unknown.
+func (tr *Torrent) files(videoId string) ([]*torrent.File, error) {
+	t, state, ok := tr.tor.state(videoId)
+	if !ok {
+		return nil, fmt.Errorf("%w for videoId: %s", tr.lookupErr(videoId), videoId)
+	}
+	if state != StateReady {
+		return nil, fmt.Errorf("%w for videoId: %s", ErrResolving, videoId)
 	}
 
 	files := t.Files()
@@ -205,41 +446,124 @@ func (tr *Torrent) GetMainVideoFile(videoId string) (*torrent.File, error) {
 		return nil, fmt.Errorf("no files in torrent for videoId: %s", videoId)
 	}
 
-	var best *torrent.File
+	return files, nil
+}
+
+// pickFile returns the file at index, or the largest video file when index is
+// MainFile. Files the magnet link of videoId did not select are never picked.
+func (tr *Torrent) pickFile(files []*torrent.File, videoId string, index int) (int, *torrent.File, error) {
+	only := tr.selectionOf(videoId)
+	if index != MainFile {
+		if index < 0 || index >= len(files) {
+			return 0, nil, fmt.Errorf("file index %d out of range for videoId: %s", index, videoId)
+		}
+		if !only.has(index) {
+			return 0, nil, fmt.Errorf("file index %d not selected for videoId: %s", index, videoId)
+		}
+		return index, files[index], nil
+	}
+
+	best := -1
 	for i := range files {
-		ext := strings.ToLower(filepath.Ext(files[i].DisplayPath()))
-		if !internal.IsVideoFile(ext) {
+		if !only.has(i) || !tr.isVideo(i, files[i]) {
 			continue
 		}
-		if best == nil || files[i].Length() > best.Length() {
-			best = files[i]
+		if best < 0 || files[i].Length() > files[best].Length() {
+			best = i
 		}
 	}
 
-	if best == nil {
-		return nil, fmt.Errorf("no video files found in torrent for videoId: %s", videoId)
+	if best < 0 {
+		return 0, nil, fmt.Errorf("no video files found in torrent for videoId: %s", videoId)
 	}
 
-	return best, nil
+	return best, files[best], nil
+}
+
+// GetFile returns the file at index, or the main video file when index is
+// MainFile.
+func (tr *Torrent) GetFile(videoId string, index int) (*torrent.File, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, file, err := tr.pickFile(files, videoId, index)
+	return file, err
+}
+
+// GetFiles lists every file in the torrent.
+func (tr *Torrent) GetFiles(videoId string) ([]FileMetadata, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	only := tr.selectionOf(videoId)
+	list := make([]FileMetadata, 0, len(files))
+	for i, f := range files {
+		meta := tr.newFileMetadata(i, f)
+		meta.Selected = only.has(i)
+		list = append(list, *meta)
+	}
+
+	return list, nil
 }
 
 // GetMetadata returns metadata for the main video file of the torrent.
 func (tr *Torrent) GetMetadata(videoId string) (*FileMetadata, error) {
-	mainFile, err := tr.GetMainVideoFile(videoId)
+	return tr.GetFileMetadata(videoId, MainFile)
+}
+
+// GetFileMetadata returns metadata for the file at index, or for the main
+// video file when index is MainFile.
+func (tr *Torrent) GetFileMetadata(videoId string, index int) (*FileMetadata, error) {
+	files, err := tr.files(videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	i, file, err := tr.pickFile(files, videoId, index)
 	if err != nil {
 		return nil, err
 	}
 
-	path := mainFile.DisplayPath()
+	meta := tr.newFileMetadata(i, file)
+	meta.Selected = true // pickFile only returns selected files
+	if meta.IsVideo {
+		meta.MimeType = tr.contentType(i, file)
+		meta.Subtitles, _ = tr.videoSubtitles(context.Background(), files, i, false)
+	}
+	return meta, nil
+}
+
+// newFileMetadata describes the file at index. Its MIME type comes from the
+// container if f was already sniffed, from its extension otherwise.
+func (tr *Torrent) newFileMetadata(index int, f *torrent.File) *FileMetadata {
+	path := f.DisplayPath()
 	ext := strings.ToLower(filepath.Ext(path))
+	isVideo := tr.isVideo(index, f)
+
+	mimeType := media.TypeByExtension(ext)
+	if container, ok := tr.containers.lookup(fileKey{f.Torrent().InfoHash(), index}); ok && container.Name != "" {
+		mimeType = container.MimeType
+	}
 
-	meta := &FileMetadata{
+	fileType := FileTypeOther
+	if isVideo {
+		fileType = FileTypeVideo
+	} else if internal.IsSubtitleFile(ext) {
+		fileType = FileTypeSubtitle
+	}
+
+	return &FileMetadata{
+		Index:     index,
 		Name:      filepath.Base(path),
 		Path:      path,
-		Length:    mainFile.Length(),
+		Length:    f.Length(),
 		Extension: ext,
-		IsVideo:   internal.IsVideoFile(ext),
+		Type:      fileType,
+		IsVideo:   isVideo,
+		MimeType:  mimeType,
 	}
-
-	return meta, nil
 }
@@ -0,0 +1,390 @@
+package tor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// newTestTorrent returns a Torrent whose client never leaves the machine.
+func newTestTorrent(t testing.TB, dataDir string) *Torrent {
+	t.Helper()
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dataDir
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.DisableIPv6 = true
+	cfg.Seed = true
+
+	bw := newBandwidth(Limits{})
+	cfg.DownloadRateLimiter = bw.download
+	cfg.UploadRateLimiter = bw.upload
+
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { cl.Close() })
+
+	tr := newTorrent(cl, Config{DataDir: dataDir})
+	tr.bandwidth = bw
+	return tr
+}
+
+// writeTestVideo writes a random file into dataDir and returns its contents and
+// metainfo, so a client using dataDir seeds it without any peers.
+func writeTestVideo(t testing.TB, dataDir, name string, size int, pieceLength int64) ([]byte, *metainfo.MetaInfo) {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.Read(data)
+
+	path := filepath.Join(dataDir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+
+	info := metainfo.Info{PieceLength: pieceLength}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatalf("failed to build info for %s: %v", path, err)
+	}
+
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatalf("failed to encode info for %s: %v", path, err)
+	}
+
+	return data, &metainfo.MetaInfo{InfoBytes: infoBytes}
+}
+
+// writeTestPack writes random files of the given sizes into dataDir/name and
+// returns the metainfo of a torrent of that directory. Files are ordered by
+// name within the torrent.
+func writeTestPack(t testing.TB, dataDir, name string, sizes map[string]int, pieceLength int64) *metainfo.MetaInfo {
+	t.Helper()
+
+	dir := filepath.Join(dataDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, size := range sizes {
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(dir, file), data, 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+
+	info := metainfo.Info{PieceLength: pieceLength}
+	if err := info.BuildFromFilePath(dir); err != nil {
+		t.Fatalf("failed to build info for %s: %v", dir, err)
+	}
+
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatalf("failed to encode info for %s: %v", dir, err)
+	}
+
+	return &metainfo.MetaInfo{InfoBytes: infoBytes}
+}
+
+func TestRegistryConcurrentAddStreamCleanup(t *testing.T) {
+	const (
+		torrents = 16
+		viewers  = 4
+	)
+
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+
+	contents := make([][]byte, torrents)
+	metas := make([]*metainfo.MetaInfo, torrents)
+	for i := range torrents {
+		contents[i], metas[i] = writeTestVideo(t, dataDir, fmt.Sprintf("video-%d.mp4", i), 256*1024, 16*1024)
+	}
+
+	var wg sync.WaitGroup
+	for i := range torrents {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("vid-%d", i)
+			if _, err := tr.AddMetainfo(id, metas[i]); err != nil {
+				t.Errorf("add %s: %v", id, err)
+				return
+			}
+
+			stream := func(reader *torrent.Reader) {
+				defer (*reader).Close()
+
+				got, err := io.ReadAll(*reader)
+				if err != nil {
+					t.Errorf("read %s: %v", id, err)
+					return
+				}
+				if !bytes.Equal(got, contents[i]) {
+					t.Errorf("read %s: content mismatch", id)
+				}
+			}
+
+			var streams sync.WaitGroup
+			for range viewers {
+				// Readers opened before cleanup must be able to finish.
+				reader := tr.GetReader(id)
+				if reader == nil {
+					t.Errorf("no reader for %s before cleanup", id)
+					return
+				}
+				streams.Add(1)
+				go func() {
+					defer streams.Done()
+					stream(reader)
+				}()
+
+				// Late viewers, metadata and magnet lookups race with cleanup.
+				streams.Add(1)
+				go func() {
+					defer streams.Done()
+					tr.GetMetadata(id)
+					tr.GetMagnetLink(id)
+					if reader := tr.GetReader(id); reader != nil {
+						stream(reader)
+					}
+				}()
+			}
+
+			if err := tr.CleanupTorrent(id); err != nil {
+				t.Errorf("cleanup %s: %v", id, err)
+			}
+			streams.Wait()
+
+			if reader := tr.GetReader(id); reader != nil {
+				t.Errorf("reader for %s still available after cleanup", id)
+				(*reader).Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(tr.cl.Torrents()); n != 0 {
+		t.Errorf("expected every torrent to be dropped, %d still active", n)
+	}
+}
+
+func TestPickFile(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	// The largest file is not a video
+	mi := writeTestPack(t, dataDir, "pack", map[string]int{
+		"a.mkv": 32 * 1024,
+		"b.mp4": 64 * 1024,
+		"c.nfo": 128 * 1024,
+		"d.srt": 1024,
+	}, 16*1024)
+	id, err := tr.AddMetainfo("pack", mi)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	main, err := tr.GetFileMetadata(id, MainFile)
+	if err != nil {
+		t.Fatalf("main file: %v", err)
+	}
+	if main.Index != 1 || main.Name != "b.mp4" {
+		t.Errorf("main file is %d %s, want the largest video 1 b.mp4", main.Index, main.Name)
+	}
+
+	sub, err := tr.GetFileMetadata(id, 3)
+	if err != nil {
+		t.Fatalf("file 3: %v", err)
+	}
+	if sub.Index != 3 || sub.IsVideo || sub.Type != FileTypeSubtitle {
+		t.Errorf("file 3 = %+v, want the subtitle", sub)
+	}
+
+	for _, index := range []int{4, -2} {
+		if _, err := tr.GetFileMetadata(id, index); err == nil {
+			t.Errorf("file %d: expected an out of range error", index)
+		}
+		if r := tr.GetFileReader(id, index); r != nil {
+			(*r).Close()
+			t.Errorf("file %d: got a reader for an out of range index", index)
+		}
+	}
+
+	files, err := tr.GetFiles(id)
+	if err != nil {
+		t.Fatalf("files: %v", err)
+	}
+	want := []string{FileTypeVideo, FileTypeVideo, FileTypeOther, FileTypeSubtitle}
+	if len(files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(files), len(want))
+	}
+	for i, f := range files {
+		if f.Index != i || f.Type != want[i] || !f.Selected {
+			t.Errorf("file %d = %+v, want a selected %s", i, f, want[i])
+		}
+	}
+}
+
+func TestAddDeduplicatesByInfoHash(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	_, mi := writeTestVideo(t, dataDir, "video.mp4", 64*1024, 16*1024)
+
+	first, err := tr.AddMetainfo("first", mi)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	magnet, err := mi.MagnetV2()
+	if err != nil {
+		t.Fatalf("magnet: %v", err)
+	}
+	again, err := tr.AddMagnet("second", magnet.String())
+	if err != nil {
+		t.Fatalf("re-add: %v", err)
+	}
+	if again != first {
+		t.Errorf("re-adding a known torrent returned id %q, want %q", again, first)
+	}
+	if tr.Has("second") {
+		t.Error("re-adding a known torrent registered a second id")
+	}
+
+	tr.CleanupTorrent(first)
+	third, err := tr.AddMetainfo("third", mi)
+	if err != nil {
+		t.Fatalf("add after cleanup: %v", err)
+	}
+	if third != "third" {
+		t.Errorf("adding after cleanup returned id %q, want %q", third, "third")
+	}
+}
+
+func TestMagnetResolvesInBackground(t *testing.T) {
+	tr := newTestTorrent(t, t.TempDir())
+	tr.cfg.MetadataTimeout = 100 * time.Millisecond
+
+	// Nobody serves this info-hash, so its metadata never arrives
+	magnet := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"
+	id, err := tr.AddMagnet("pending", magnet)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if state, err := tr.State(id); err != nil || state != StateResolving {
+		t.Fatalf("state right after add = %q, %v; want %q", state, err, StateResolving)
+	}
+	if _, err := tr.GetFiles(id); !errors.Is(err, ErrResolving) {
+		t.Errorf("files while resolving: got %v, want ErrResolving", err)
+	}
+
+	if reader := tr.GetReader(id); reader != nil {
+		t.Error("got a reader for a torrent whose metadata never resolved")
+		(*reader).Close()
+	}
+	if state, err := tr.State(id); err != nil || state != StateTimedOut {
+		t.Errorf("state after the deadline = %q, %v; want %q", state, err, StateTimedOut)
+	}
+	if n := len(tr.cl.Torrents()); n != 0 {
+		t.Errorf("expected the unresolved torrent to be dropped, %d still active", n)
+	}
+}
+
+func TestMagnetResolvesFromMetainfoCache(t *testing.T) {
+	dataDir := t.TempDir()
+	tr := newTestTorrent(t, dataDir)
+	tr.cfg.MetadataTimeout = 2 * time.Second
+	_, mi := writeTestVideo(t, dataDir, "video.mp4", 64*1024, 16*1024)
+
+	if _, err := tr.AddMetainfo("first", mi); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	tr.CleanupTorrent("first")
+
+	cached, err := tr.CachedMetainfo()
+	if err != nil || len(cached) != 1 || cached[0].InfoHash != mi.HashInfoBytes().HexString() {
+		t.Fatalf("cache after add = %+v, %v; want the added torrent", cached, err)
+	}
+
+	// No peer can serve the metadata, only the cache
+	magnet, err := mi.MagnetV2()
+	if err != nil {
+		t.Fatalf("magnet: %v", err)
+	}
+	id, err := tr.AddMagnet("second", magnet.String())
+	if err != nil {
+		t.Fatalf("re-add: %v", err)
+	}
+	reader := tr.GetReader(id)
+	if reader == nil {
+		t.Fatal("magnet did not resolve from the cache")
+	}
+	(*reader).Close()
+
+	if removed, err := tr.PruneCachedMetainfo(0); err != nil || removed != 1 {
+		t.Errorf("prune = %d, %v; want 1 entry removed", removed, err)
+	}
+}
+
+func TestSessionsSurviveRestart(t *testing.T) {
+	dataDir := t.TempDir()
+	sessionFile := filepath.Join(dataDir, ".sessions.json")
+
+	before := newTestTorrent(t, dataDir)
+	before.sessions = &sessionStore{path: sessionFile}
+	content, mi := writeTestVideo(t, dataDir, "video.mp4", 256*1024, 16*1024)
+
+	if _, err := before.AddMetainfo("kept", mi); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	reader := before.GetReader("kept")
+	if reader == nil {
+		t.Fatal("no reader before restart")
+	}
+	if _, err := io.ReadAll(*reader); err != nil {
+		t.Fatalf("read before restart: %v", err)
+	}
+	(*reader).Close()
+	before.cl.Close()
+
+	after := newTestTorrent(t, dataDir)
+	after.sessions = &sessionStore{path: sessionFile}
+	after.restoreSessions()
+
+	reader = after.GetReader("kept")
+	if reader == nil {
+		t.Fatal("id not restored after restart")
+	}
+	defer (*reader).Close()
+
+	file, err := after.GetMainVideoFile("kept")
+	if err != nil {
+		t.Fatalf("main file: %v", err)
+	}
+	if got := file.BytesCompleted(); got != file.Length() {
+		t.Errorf("restored torrent has %d of %d bytes complete, want the data reused", got, file.Length())
+	}
+
+	got, err := io.ReadAll(*reader)
+	if err != nil {
+		t.Fatalf("read after restart: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content mismatch after restart")
+	}
+}
@@ -0,0 +1,374 @@
+// Package tortest provides an in-memory tor.Engine that serves byte slices as
+// torrents, so the HTTP layer and the worker can be tested without a network.
+package tortest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/scythe504/webtorrent/internal"
+	"github.com/scythe504/webtorrent/internal/media"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// pieceLength is the piece size of the torrents built by Add.
+const pieceLength = 16 << 10
+
+// File is a file of a fake torrent.
+type File struct {
+	Path string // Slash separated within the torrent, e.g. "Show/S01E01.mkv"
+	Data []byte
+}
+
+// Torrent is a torrent built by Add.
+type Torrent struct {
+	MetaInfo *metainfo.MetaInfo
+	Magnet   string
+	Files    []File
+}
+
+// entry is a registered id. Its torrent is nil while it resolves, which for
+// the fake is forever.
+type entry struct {
+	hash  metainfo.Hash
+	t     *Torrent
+	state tor.State
+}
+
+// Engine is a tor.Engine over torrents held in memory. The magnet links and
+// metainfo of torrents created with Add resolve at once, other magnet links
+// stay resolving as if nobody seeded them. It is safe for concurrent use.
+type Engine struct {
+	mu      sync.Mutex
+	known   map[metainfo.Hash]*Torrent
+	entries map[string]*entry
+	byHash  map[metainfo.Hash]string
+	readers int
+}
+
+var _ tor.Engine = (*Engine)(nil)
+
+// New returns an engine without any torrents.
+func New() *Engine {
+	return &Engine{
+		known:   make(map[metainfo.Hash]*Torrent),
+		entries: make(map[string]*entry),
+		byHash:  make(map[metainfo.Hash]string),
+	}
+}
+
+// Add builds a torrent named name from files and makes it available to
+// AddMagnet and AddMetainfo. A single file whose path is name makes a single
+// file torrent.
+func (e *Engine) Add(name string, files ...File) *Torrent {
+	info := metainfo.Info{Name: name, PieceLength: pieceLength}
+	if len(files) == 1 && files[0].Path == name {
+		info.Length = int64(len(files[0].Data))
+	} else {
+		for _, f := range files {
+			info.Files = append(info.Files, metainfo.FileInfo{
+				Length: int64(len(f.Data)),
+				Path:   strings.Split(f.Path, "/"),
+			})
+		}
+	}
+
+	i := 0
+	err := info.GeneratePieces(func(metainfo.FileInfo) (io.ReadCloser, error) {
+		r := io.NopCloser(bytes.NewReader(files[i].Data))
+		i++
+		return r, nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("tortest: hashing %s: %v", name, err))
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		panic(fmt.Sprintf("tortest: encoding %s: %v", name, err))
+	}
+
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+	magnet, err := mi.MagnetV2()
+	if err != nil {
+		panic(fmt.Sprintf("tortest: magnet of %s: %v", name, err))
+	}
+
+	t := &Torrent{MetaInfo: mi, Magnet: magnet.String(), Files: files}
+	e.mu.Lock()
+	e.known[mi.HashInfoBytes()] = t
+	e.mu.Unlock()
+	return t
+}
+
+// OpenReaders returns how many readers are open, for checking that callers
+// close them.
+func (e *Engine) OpenReaders() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.readers
+}
+
+func (e *Engine) AddMagnet(id, magnetLink string, trackers ...string) (string, error) {
+	m, err := metainfo.ParseMagnetUri(magnetLink)
+	if err != nil {
+		return "", fmt.Errorf("failed to add magnet: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.addLocked(id, m.InfoHash)
+}
+
+func (e *Engine) AddMetainfo(id string, mi *metainfo.MetaInfo, trackers ...string) (string, error) {
+	hash := mi.HashInfoBytes()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t, ok := e.known[hash]
+	if !ok {
+		return "", fmt.Errorf("failed to add torrent: tortest only serves torrents created with Add")
+	}
+	if !hasVideo(t) {
+		return "", fmt.Errorf("%w for id: %s", tor.ErrNoVideoFiles, id)
+	}
+	return e.addLocked(id, hash)
+}
+
+func (e *Engine) addLocked(id string, hash metainfo.Hash) (string, error) {
+	if existing, ok := e.byHash[hash]; ok {
+		return existing, nil
+	}
+	if _, ok := e.entries[id]; ok {
+		return "", fmt.Errorf("torrent already registered for id: %s", id)
+	}
+
+	en := &entry{hash: hash, state: tor.StateResolving}
+	if t, ok := e.known[hash]; ok {
+		en.t, en.state = t, tor.StateReady
+		if !hasVideo(t) {
+			en.state = tor.StateNoVideoFiles
+		}
+	}
+	e.entries[id] = en
+	e.byHash[hash] = id
+	return id, nil
+}
+
+func (e *Engine) Has(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.entries[id]
+	return ok
+}
+
+func (e *Engine) State(id string) (tor.State, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	en, ok := e.entries[id]
+	if !ok {
+		return "", fmt.Errorf("%w for videoId: %s", tor.ErrNotFound, id)
+	}
+	return en.state, nil
+}
+
+func (e *Engine) GetReader(id string) *torrent.Reader {
+	return e.GetFileReader(id, tor.MainFile)
+}
+
+func (e *Engine) GetFileReader(id string, index int) *torrent.Reader {
+	t, err := e.ready(id)
+	if err != nil {
+		return nil
+	}
+	i, err := pickFile(t, id, index)
+	if err != nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	e.readers++
+	e.mu.Unlock()
+
+	var r torrent.Reader = &reader{
+		Reader: bytes.NewReader(t.Files[i].Data),
+		ctx:    context.Background(),
+		close: func() {
+			e.mu.Lock()
+			e.readers--
+			e.mu.Unlock()
+		},
+	}
+	return &r
+}
+
+func (e *Engine) GetMetadata(id string) (*tor.FileMetadata, error) {
+	return e.GetFileMetadata(id, tor.MainFile)
+}
+
+func (e *Engine) GetFileMetadata(id string, index int) (*tor.FileMetadata, error) {
+	t, err := e.ready(id)
+	if err != nil {
+		return nil, err
+	}
+	i, err := pickFile(t, id, index)
+	if err != nil {
+		return nil, err
+	}
+	return fileMetadata(i, t.Files[i]), nil
+}
+
+func (e *Engine) GetFiles(id string) ([]tor.FileMetadata, error) {
+	t, err := e.ready(id)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]tor.FileMetadata, 0, len(t.Files))
+	for i, f := range t.Files {
+		list = append(list, *fileMetadata(i, f))
+	}
+	return list, nil
+}
+
+func (e *Engine) GetMagnetLink(id string) *string {
+	t, err := e.ready(id)
+	if err != nil {
+		return nil
+	}
+	magnet := t.Magnet
+	return &magnet
+}
+
+func (e *Engine) GetMetainfo(id string) (*metainfo.MetaInfo, error) {
+	t, err := e.ready(id)
+	if err != nil {
+		return nil, err
+	}
+	mi := *t.MetaInfo
+	return &mi, nil
+}
+
+func (e *Engine) CleanupTorrent(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if en, ok := e.entries[id]; ok {
+		delete(e.byHash, en.hash)
+		delete(e.entries, id)
+	}
+	return nil
+}
+
+// ready returns the torrent of id, failing like tor.Torrent does while it is
+// unknown or still resolving.
+func (e *Engine) ready(id string) (*Torrent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	en, ok := e.entries[id]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w for videoId: %s", tor.ErrNotFound, id)
+	case en.state == tor.StateNoVideoFiles:
+		return nil, fmt.Errorf("%w for videoId: %s", tor.ErrNoVideoFiles, id)
+	case en.t == nil:
+		return nil, fmt.Errorf("%w for videoId: %s", tor.ErrResolving, id)
+	}
+	return en.t, nil
+}
+
+func hasVideo(t *Torrent) bool {
+	for _, f := range t.Files {
+		if internal.IsVideoFile(path.Ext(f.Path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFile returns the index of the file at index, or of the largest video
+// file when index is tor.MainFile.
+func pickFile(t *Torrent, id string, index int) (int, error) {
+	if index != tor.MainFile {
+		if index < 0 || index >= len(t.Files) {
+			return 0, fmt.Errorf("file index %d out of range for videoId: %s", index, id)
+		}
+		return index, nil
+	}
+
+	best := -1
+	for i, f := range t.Files {
+		if internal.IsVideoFile(path.Ext(f.Path)) && (best < 0 || len(f.Data) > len(t.Files[best].Data)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, fmt.Errorf("no video files found in torrent for videoId: %s", id)
+	}
+	return best, nil
+}
+
+func fileMetadata(index int, f File) *tor.FileMetadata {
+	ext := strings.ToLower(path.Ext(f.Path))
+	isVideo := internal.IsVideoFile(ext)
+
+	fileType := tor.FileTypeOther
+	if isVideo {
+		fileType = tor.FileTypeVideo
+	} else if internal.IsSubtitleFile(ext) {
+		fileType = tor.FileTypeSubtitle
+	}
+
+	return &tor.FileMetadata{
+		Index:     index,
+		Name:      path.Base(f.Path),
+		Path:      f.Path,
+		Length:    int64(len(f.Data)),
+		Extension: ext,
+		Type:      fileType,
+		IsVideo:   isVideo,
+		MimeType:  media.TypeByExtension(ext),
+		Selected:  true,
+	}
+}
+
+// reader is a torrent.Reader over a file held in memory. Reads fail once its
+// context is done, like those of a real reader waiting on pieces.
+type reader struct {
+	*bytes.Reader
+	ctx   context.Context
+	close func()
+	once  sync.Once
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	return r.ReadContext(r.ctx, b)
+}
+
+func (r *reader) ReadContext(ctx context.Context, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(b)
+}
+
+func (r *reader) Close() error {
+	r.once.Do(r.close)
+	return nil
+}
+
+func (r *reader) SetContext(ctx context.Context)         { r.ctx = ctx }
+func (r *reader) SetReadahead(int64)                     {}
+func (r *reader) SetReadaheadFunc(torrent.ReadaheadFunc) {}
+func (r *reader) SetResponsive()                         {}
@@ -0,0 +1,451 @@
+package tor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// defaultTrackers are well known public trackers, added to every torrent that
+// is not private unless TORRENT_TRACKERS overrides them.
+var defaultTrackers = []string{
+	"udp://tracker.opentrackr.org:1337/announce",
+	"udp://open.stealth.si:80/announce",
+	"udp://tracker.torrent.eu.org:451/announce",
+	"udp://exodus.desync.com:6969/announce",
+	"udp://explodie.org:6969/announce",
+	"udp://open.demonii.com:1337/announce",
+}
+
+// trackerSampleInterval is how often announce results are read from the
+// client. Announce times are known to within this interval.
+const trackerSampleInterval = 10 * time.Second
+
+// Announce states of a tracker.
+const (
+	TrackerPending = "pending" // Not announced to yet
+	TrackerWorking = "working"
+	TrackerError   = "error"
+)
+
+// ErrInvalidTracker is returned for tracker URLs that cannot be announced to.
+var ErrInvalidTracker = errors.New("invalid tracker url")
+
+// TrackerStatus is a tracker of a torrent and how announcing to it went.
+type TrackerStatus struct {
+	URL          string     `json:"url"`
+	Status       string     `json:"status"`
+	LastAnnounce *time.Time `json:"last_announce,omitempty"`
+	NextAnnounce *time.Time `json:"next_announce,omitempty"`
+	Peers        int        `json:"peers"` // Peers returned by the last announce
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// validTrackers checks and normalizes tracker URLs, dropping duplicates.
+func validTrackers(urls []string) ([]string, error) {
+	var valid []string
+	for _, raw := range urls {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTracker, raw)
+		}
+		switch u.Scheme {
+		case "http", "https", "udp", "udp4", "udp6", "ws", "wss":
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTracker, raw)
+		}
+		if !slices.Contains(valid, u.String()) {
+			valid = append(valid, u.String())
+		}
+	}
+	return valid, nil
+}
+
+// extraTrackers returns the tiers to add to a torrent on top of its own: the
+// trackers asked for and, with defaults, the configured defaults.
+func (tr *Torrent) extraTrackers(trackers []string, defaults bool) [][]string {
+	var tiers [][]string
+	if len(trackers) > 0 {
+		tiers = append(tiers, trackers)
+	}
+	if defaults && len(tr.cfg.Trackers) > 0 {
+		tiers = append(tiers, tr.cfg.Trackers)
+	}
+	return tiers
+}
+
+// mergeTiers appends the tiers of extra to tiers, leaving out trackers that
+// are already listed.
+func mergeTiers(tiers, extra [][]string) [][]string {
+	listed := make(map[string]bool)
+	for _, tier := range tiers {
+		for _, u := range tier {
+			listed[normalizeTracker(u)] = true
+		}
+	}
+
+	for _, tier := range extra {
+		var added []string
+		for _, u := range tier {
+			if !listed[normalizeTracker(u)] {
+				listed[normalizeTracker(u)] = true
+				added = append(added, u)
+			}
+		}
+		if len(added) > 0 {
+			tiers = append(tiers, added)
+		}
+	}
+	return tiers
+}
+
+// isPrivate reports whether the info of t, which must be known, forbids
+// trackers and peers other than its own.
+func isPrivate(t *torrent.Torrent) bool {
+	info := t.Info()
+	return info != nil && info.Private != nil && *info.Private
+}
+
+// dropDefaultTrackers removes the default trackers from a magnet torrent
+// whose info turned out to be private. Until then the defaults were announced
+// to like for any other magnet link.
+func (tr *Torrent) dropDefaultTrackers(e *entry) {
+	var tiers [][]string
+	for _, tier := range announceList(e.t) {
+		tier = slices.DeleteFunc(tier, func(u string) bool { return slices.Contains(tr.cfg.Trackers, u) })
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	e.t.ModifyTrackers(tiers)
+	tr.tor.setSource(e, magnetFor(e.t))
+}
+
+// addTrackers adds trackers to the torrent already registered under id.
+func (tr *Torrent) addTrackers(id string, trackers []string) {
+	if len(trackers) == 0 {
+		return
+	}
+	e, ok := tr.tor.lookup(id)
+	if !ok {
+		return
+	}
+
+	tiers := announceList(e.t)
+	e.t.ModifyTrackers(mergeTiers(tiers, [][]string{trackers}))
+	tr.tor.setSource(e, magnetFor(e.t))
+	tr.saveSessions()
+}
+
+// announceList returns the tiers of t. Replacing trackers leaves empty tiers
+// behind in the client, they are skipped.
+func announceList(t *torrent.Torrent) [][]string {
+	mi := t.Metainfo()
+	var tiers [][]string
+	for _, tier := range mi.UpvertedAnnounceList() {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	return tiers
+}
+
+// magnetFor returns a magnet link to t with its current trackers. Unlike
+// sourceMagnet it does not need the info.
+func magnetFor(t *torrent.Torrent) string {
+	m := metainfo.Magnet{InfoHash: t.InfoHash()}
+	if name := t.Name(); !strings.HasPrefix(name, "infohash:") {
+		m.DisplayName = name
+	}
+	for _, tier := range announceList(t) {
+		m.Trackers = append(m.Trackers, tier...)
+	}
+	return m.String()
+}
+
+// Trackers lists the trackers of the torrent with their announce status.
+func (tr *Torrent) Trackers(videoId string) ([]TrackerStatus, error) {
+	t, ok := tr.tor.get(videoId)
+	if !ok {
+		return nil, fmt.Errorf("%w for videoId: %s", tr.lookupErr(videoId), videoId)
+	}
+
+	observed := tr.trackers.sample(tr.cl, time.Now())[t.InfoHash()]
+
+	var list []TrackerStatus
+	for _, tier := range announceList(t) {
+		for _, raw := range tier {
+			status := TrackerStatus{URL: raw, Status: TrackerPending}
+			if o, ok := announcerOf(observed, raw); ok {
+				o.fill(&status)
+			}
+			list = append(list, status)
+		}
+	}
+	return list, nil
+}
+
+// announcerOf returns what is known about the tracker raw. The client
+// announces to udp trackers over IPv4 and IPv6 separately, the more useful of
+// the two results is taken.
+func announcerOf(observed map[string]trackerObservation, raw string) (trackerObservation, bool) {
+	u := normalizeTracker(raw)
+	rest, ok := strings.CutPrefix(u, "udp://")
+	if !ok {
+		o, ok := observed[u]
+		return o, ok
+	}
+
+	var best trackerObservation
+	found := false
+	for _, key := range []string{u, "udp4://" + rest, "udp6://" + rest} {
+		o, ok := observed[key]
+		if !ok {
+			continue
+		}
+		if !found || o.rank() > best.rank() {
+			best = o
+		}
+		if o.lastAnnounce.After(best.lastAnnounce) {
+			best.lastAnnounce = o.lastAnnounce
+		}
+		found = true
+	}
+	return best, found
+}
+
+// SetTrackers replaces the trackers of an active torrent. The defaults are
+// not added back, so they can be removed from a torrent too.
+func (tr *Torrent) SetTrackers(videoId string, trackers []string) ([]TrackerStatus, error) {
+	valid, err := validTrackers(trackers)
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := tr.tor.lookup(videoId)
+	if !ok {
+		return nil, fmt.Errorf("%w for videoId: %s", tr.lookupErr(videoId), videoId)
+	}
+
+	var tiers [][]string
+	if len(valid) > 0 {
+		tiers = [][]string{valid}
+	}
+	e.t.ModifyTrackers(tiers)
+
+	// Restored sessions come back with the new trackers
+	tr.tor.setSource(e, magnetFor(e.t))
+	tr.saveSessions()
+
+	return tr.Trackers(videoId)
+}
+
+// trackerObservation is what is known about announces to one tracker.
+type trackerObservation struct {
+	result       string // Last result as reported by the client
+	next         time.Time
+	lastAnnounce time.Time
+}
+
+// rank orders results from failed through not announced yet to working.
+func (o *trackerObservation) rank() int {
+	switch {
+	case peersResult.MatchString(o.result):
+		return 2
+	case o.result == "never":
+		return 1
+	}
+	return 0
+}
+
+func (o *trackerObservation) fill(s *TrackerStatus) {
+	if !o.next.IsZero() {
+		next := o.next
+		s.NextAnnounce = &next
+	}
+	if !o.lastAnnounce.IsZero() {
+		last := o.lastAnnounce
+		s.LastAnnounce = &last
+	}
+
+	switch {
+	case o.result == "never":
+	case peersResult.MatchString(o.result):
+		s.Status = TrackerWorking
+		s.Peers, _ = strconv.Atoi(peersResult.FindStringSubmatch(o.result)[1])
+	default:
+		s.Status = TrackerError
+		// HTTP errors quote the whole announce URL, peer id included
+		s.LastError = announceURL.ReplaceAllString(o.result, "")
+	}
+}
+
+// trackerMonitor follows the announce results of the client's torrents.
+// The client keeps only the last result of each tracker, and only exposes it
+// as text in its status dump, so announce times are inferred from how the
+// next announce time moves between samples. The dump has no stable format:
+// TestTrackerStatusOfClient reads it from a real client, so an upgrade that
+// changes it is caught.
+type trackerMonitor struct {
+	mu      sync.Mutex
+	samples map[metainfo.Hash]map[string]*trackerObservation
+}
+
+func newTrackerMonitor() *trackerMonitor {
+	return &trackerMonitor{samples: make(map[metainfo.Hash]map[string]*trackerObservation)}
+}
+
+// sample reads the announce results of every torrent of cl and returns a
+// copy of what is known about each tracker, by info-hash and normalized URL.
+// Torrents that are gone are forgotten.
+func (tm *trackerMonitor) sample(cl *torrent.Client, now time.Time) map[metainfo.Hash]map[string]trackerObservation {
+	var status bytes.Buffer
+	cl.WriteStatus(&status)
+	torrents := parseTrackerStatus(status.String())
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	for hash := range tm.samples {
+		if _, ok := torrents[hash]; !ok {
+			delete(tm.samples, hash)
+		}
+	}
+
+	observed := make(map[metainfo.Hash]map[string]trackerObservation, len(torrents))
+	for hash, lines := range torrents {
+		known := tm.samples[hash]
+		if known == nil {
+			known = make(map[string]*trackerObservation)
+			tm.samples[hash] = known
+		}
+
+		observed[hash] = make(map[string]trackerObservation, len(lines))
+		for u, line := range lines {
+			o := known[u]
+			if o == nil {
+				o = &trackerObservation{result: "never"}
+				known[u] = o
+			}
+
+			// Overdue announces have no next time
+			var next time.Time
+			if line.next > 0 {
+				next = now.Add(line.next)
+			}
+			announced := line.result != "never" &&
+				(o.result != line.result || next.After(o.next.Add(2*time.Second)))
+			if announced {
+				o.lastAnnounce = now
+			}
+			o.result, o.next = line.result, next
+			observed[hash][u] = *o
+		}
+	}
+	return observed
+}
+
+// monitorTrackers samples the announce results until the client is closed,
+// so announces are noticed between calls to Trackers. Only registered
+// torrents are reported, so nothing is sampled while there are none.
+func (tr *Torrent) monitorTrackers() {
+	ticker := time.NewTicker(trackerSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if len(tr.tor.torrents()) > 0 {
+				tr.trackers.sample(tr.cl, time.Now())
+			}
+		case <-tr.cl.Closed():
+			return
+		}
+	}
+}
+
+// trackerLine is a tracker entry of the client's status dump.
+type trackerLine struct {
+	next   time.Duration
+	result string
+}
+
+var (
+	// trackerEntry matches `"<url>"  next ann: <duration>, last ann: <result>`
+	trackerEntry = regexp.MustCompile(`^\s*("(?:[^"\\]|\\.)*")\s+next ann: (\S+), last ann: (.*)$`)
+	peersResult  = regexp.MustCompile(`^(\d+) peers$`)
+	announceURL  = regexp.MustCompile(`(?:Get|Post) "[^"]*": `)
+)
+
+// parseTrackerStatus extracts the trackers sections of the client's status
+// dump, by info-hash and normalized URL.
+func parseTrackerStatus(status string) map[metainfo.Hash]map[string]trackerLine {
+	torrents := make(map[metainfo.Hash]map[string]trackerLine)
+	var lines map[string]trackerLine
+	inTrackers := false
+
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		text := scanner.Text()
+		if hex, ok := strings.CutPrefix(text, "Infohash: "); ok {
+			var hash metainfo.Hash
+			if err := hash.FromHexString(strings.TrimSpace(hex)); err != nil {
+				lines = nil
+				continue
+			}
+			lines = make(map[string]trackerLine)
+			torrents[hash] = lines
+			inTrackers = false
+			continue
+		}
+		if lines == nil {
+			continue
+		}
+		if strings.HasPrefix(text, "Enabled trackers:") {
+			inTrackers = true
+			continue
+		}
+		if !inTrackers {
+			continue
+		}
+		if !strings.HasPrefix(text, " ") {
+			inTrackers = false
+			continue
+		}
+
+		m := trackerEntry.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		u, err := strconv.Unquote(m[1])
+		if err != nil {
+			continue
+		}
+
+		var next time.Duration
+		if m[2] != "anytime" {
+			next, _ = time.ParseDuration(m[2])
+		}
+		lines[normalizeTracker(u)] = trackerLine{next: next, result: strings.TrimSpace(m[3])}
+	}
+	return torrents
+}
+
+func normalizeTracker(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.String()
+}
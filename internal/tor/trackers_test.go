@@ -0,0 +1,139 @@
+package tor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// TestTrackerStatusOfClient reads the announce results of a real client out
+// of its status dump, so a client upgrade that changes the dump fails here.
+func TestTrackerStatusOfClient(t *testing.T) {
+	// A tracker that hands out one peer, and one that refuses connections
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := []byte{127, 0, 0, 1, 0x1a, 0xe1}
+		bencode.NewEncoder(w).Encode(map[string]any{"interval": 1800, "peers": string(peer)})
+	}))
+	defer working.Close()
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	workingURL, refusedURL := working.URL+"/announce", refused.URL+"/announce"
+
+	dir := t.TempDir()
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dir
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableIPv6 = true
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer cl.Close()
+	tr := newTorrent(cl, Config{DataDir: dir})
+
+	_, mi := writeTestVideo(t, dir, "movie.mp4", 1<<16, 1<<14)
+	id, err := tr.AddMetainfo("video", mi, workingURL, refusedURL)
+	if err != nil {
+		t.Fatalf("AddMetainfo: %v", err)
+	}
+
+	var list []TrackerStatus
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if list, err = tr.Trackers(id); err != nil {
+			t.Fatalf("Trackers: %v", err)
+		}
+		if len(list) == 2 && list[0].Status != TrackerPending && list[1].Status != TrackerPending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("trackers not announced to in time, got %+v", list)
+		}
+	}
+
+	ok, failed := list[0], list[1]
+	if ok.URL != workingURL || ok.Status != TrackerWorking || ok.Peers != 1 {
+		t.Errorf("got %+v, want a working tracker with 1 peer", ok)
+	}
+	if ok.LastAnnounce == nil || ok.NextAnnounce == nil || ok.NextAnnounce.Before(time.Now().Add(25*time.Minute)) {
+		t.Errorf("got announce times %v and %v, want the last one and the next in 30m", ok.LastAnnounce, ok.NextAnnounce)
+	}
+	if failed.URL != refusedURL || failed.Status != TrackerError || failed.LastError == "" {
+		t.Errorf("got %+v, want a failing tracker", failed)
+	}
+	if strings.Contains(failed.LastError, "peer_id") {
+		t.Errorf("error %q leaks the announce URL", failed.LastError)
+	}
+}
+
+func TestAnnouncerOfUDPTracker(t *testing.T) {
+	// udp trackers are announced to over IPv4 and IPv6 separately
+	udp := map[string]trackerObservation{
+		"udp4://tracker.example:80/announce": {result: "never"},
+		"udp6://tracker.example:80/announce": {result: "3 peers"},
+	}
+	if o, ok := announcerOf(udp, "udp://tracker.example:80/announce"); !ok || o.result != "3 peers" {
+		t.Errorf("got %+v for a udp tracker, want the IPv6 result", o)
+	}
+}
+
+func TestTrackersDefaultsAndReplace(t *testing.T) {
+	dir := t.TempDir()
+	tr := newTestTorrent(t, dir)
+	tr.cfg.Trackers = []string{"udp://default.example:1337/announce"}
+
+	_, mi := writeTestVideo(t, dir, "movie.mp4", 1<<16, 1<<14)
+	id, err := tr.AddMetainfo("video", mi, "http://extra.example/announce", "http://extra.example/announce")
+	if err != nil {
+		t.Fatalf("AddMetainfo: %v", err)
+	}
+
+	list, err := tr.Trackers(id)
+	if err != nil {
+		t.Fatalf("Trackers: %v", err)
+	}
+	urls := trackerURLs(list)
+	if !slices.Equal(urls, []string{"http://extra.example/announce", "udp://default.example:1337/announce"}) {
+		t.Fatalf("got trackers %v, want the extra one then the default", urls)
+	}
+	if list[0].Status != TrackerPending {
+		t.Errorf("got status %q before any announce, want %q", list[0].Status, TrackerPending)
+	}
+
+	if _, err := tr.SetTrackers(id, []string{"ftp://nope"}); err == nil {
+		t.Fatal("SetTrackers accepted an ftp tracker")
+	}
+
+	list, err = tr.SetTrackers(id, []string{"udp://only.example:6969/announce"})
+	if err != nil {
+		t.Fatalf("SetTrackers: %v", err)
+	}
+	if urls := trackerURLs(list); !slices.Equal(urls, []string{"udp://only.example:6969/announce"}) {
+		t.Fatalf("got trackers %v after replacing them", urls)
+	}
+
+	// Restored sessions come back with the replaced trackers
+	e, _ := tr.tor.lookup(id)
+	m, err := metainfo.ParseMagnetUri(e.source)
+	if err != nil {
+		t.Fatalf("invalid session source %q: %v", e.source, err)
+	}
+	if !slices.Equal(m.Trackers, []string{"udp://only.example:6969/announce"}) {
+		t.Errorf("got session trackers %v", m.Trackers)
+	}
+}
+
+func trackerURLs(list []TrackerStatus) []string {
+	var urls []string
+	for _, s := range list {
+		urls = append(urls, s.URL)
+	}
+	return urls
+}
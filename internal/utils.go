@@ -32,18 +32,38 @@ func GenerateSecureToken() string {
 
 var videoExtensions = map[string]bool{
 	".mp4":  true,
+	".m4v":  true,
 	".mkv":  true,
 	".avi":  true,
 	".mov":  true,
 	".webm": true,
 	".flv":  true,
 	".wmv":  true,
+	".ts":   true,
+	".m2ts": true,
+	".mts":  true,
+	".mpg":  true,
+	".mpeg": true,
+	".vob":  true,
+	".ogv":  true,
+	".3gp":  true,
 }
 
 func IsVideoFile(ext string) bool {
 	return videoExtensions[strings.ToLower(ext)]
 }
 
+var subtitleExtensions = map[string]bool{
+	".srt": true,
+	".vtt": true,
+	".ass": true,
+	".ssa": true,
+}
+
+func IsSubtitleFile(ext string) bool {
+	return subtitleExtensions[strings.ToLower(ext)]
+}
+
 // FileExists checks if file exists
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
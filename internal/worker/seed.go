@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"log"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/scythe504/webtorrent/internal/tor"
+)
+
+// seedCheckInterval is how often seeding progress is recorded and checked
+// against the seeding policy.
+const seedCheckInterval = time.Minute
+
+// seeder is implemented by engines that can seed a saved video from the
+// library, such as *tor.Torrent.
+type seeder interface {
+	SeedFile(mi *metainfo.MetaInfo, index int, path string) (*tor.Seed, error)
+}
+
+// seedJob is a saved video to seed back to its swarm.
+type seedJob struct {
+	videoId string
+	mi      *metainfo.MetaInfo
+	index   int
+	path    string
+}
+
+// seed seeds a saved video from the library until the seeding policy is met,
+// adding what was uploaded to the video's row as it goes.
+func (tw *TorrentWorker) seed(job seedJob) {
+	s, err := tw.tor.(seeder).SeedFile(job.mi, job.index, job.path)
+	if err != nil {
+		log.Printf("[Seed] failed to seed %s: %v", job.videoId, err)
+		return
+	}
+	defer s.Stop()
+	log.Printf("[Seed] seeding %s from %s (policy %s)", job.videoId, job.path, tw.seedPolicy.Mode)
+
+	var recorded int64
+	record := func() {
+		uploaded := s.Uploaded()
+		if uploaded == recorded {
+			return
+		}
+		if err := tw.postgresdb.AddUploaded(job.videoId, uploaded-recorded, s.Length); err != nil {
+			log.Printf("[Seed] failed to record upload of %s: %v", job.videoId, err)
+			return
+		}
+		recorded = uploaded
+	}
+
+	ticker := time.NewTicker(seedCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			record()
+			if tw.seedPolicy.Done(s.Uploaded(), s.Length, time.Since(s.Started)) {
+				log.Printf("[Seed] done seeding %s, ratio %.2f", job.videoId, s.Ratio())
+				return
+			}
+		case <-tw.ctx.Done():
+			record()
+			return
+		}
+	}
+}
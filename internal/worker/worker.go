@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -14,12 +15,26 @@ import (
 type TorrentWorker struct {
 	rdb        redisdb.Service
 	postgresdb postgresdb.Service
-	tor        tor.Torrent
+	tor        tor.Engine
 	st         storage.Service
 	jobsChan   chan redisdb.Job
 	numWorker  int
 	errChan    chan WorkerError
 	ctx        context.Context
+	seedPolicy tor.SeedPolicy
+}
+
+// bandwidthSetter is implemented by engines whose transfer rates can be
+// limited, such as *tor.Torrent.
+type bandwidthSetter interface {
+	SetBandwidth(bw tor.Bandwidth) error
+}
+
+// torrentRemover is implemented by engines that keep torrent data on disk,
+// such as *tor.Torrent. Saved videos are seeded from the library, so a job's
+// download is of no use once the job is over.
+type torrentRemover interface {
+	RemoveTorrent(id string) error
 }
 
 type WorkerError struct {
@@ -42,17 +57,20 @@ const (
 func NewTorrentWorker(worker int) *TorrentWorker {
 	ctx := context.Background()
 
+	torrentConfig := tor.WorkerConfig(42070)
+
 	jobsChan := make(chan redisdb.Job, worker)
 	errChan := make(chan WorkerError, worker)
 	tw := &TorrentWorker{
 		rdb:        redisdb.New(ctx),
 		postgresdb: postgresdb.New(),
-		tor:        tor.New(42070),
+		tor:        tor.New(torrentConfig),
 		st:         storage.New(),
 		jobsChan:   jobsChan,
 		ctx:        ctx,
 		numWorker:  worker,
 		errChan:    errChan,
+		seedPolicy: tor.DefaultSeedPolicy(),
 	}
 
 	return tw
@@ -71,7 +89,7 @@ func (tw *TorrentWorker) Start(consumerName string) {
 			continue
 		}
 
-		if err := tw.postgresdb.UpdateStatus(postgresdb.DOWNLOADING, job.Id, nil); err != nil {
+		if err := tw.setStatus(postgresdb.DOWNLOADING, job.Id, nil); err != nil {
 			log.Printf("[%s] UpdateStatus DOWNLOADING failed: %v\n", consumerName, err)
 			continue
 		}
@@ -80,26 +98,51 @@ func (tw *TorrentWorker) Start(consumerName string) {
 	}
 }
 
+// WatchBandwidth applies the bandwidth limits set through the API to the
+// worker's downloads.
+func (tw *TorrentWorker) WatchBandwidth() {
+	limiter, ok := tw.tor.(bandwidthSetter)
+	if !ok {
+		return
+	}
+	tw.rdb.WatchBandwidth(tw.ctx, func(settings []byte) {
+		var bw tor.Bandwidth
+		if err := json.Unmarshal(settings, &bw); err != nil {
+			log.Println("[Bandwidth] invalid shared limits", err)
+			return
+		}
+		if err := limiter.SetBandwidth(bw); err != nil {
+			log.Println("[Bandwidth] invalid shared limits", err)
+		}
+	})
+}
+
 func (tw *TorrentWorker) DownloadWorker(i int) {
 	for job := range tw.jobsChan {
-		tw.processJob(job)
+		if seed := tw.processJob(job); seed != nil {
+			go tw.seed(*seed)
+		}
 	}
 }
 
-func (tw *TorrentWorker) processJob(job redisdb.Job) {
+// processJob saves the video of a job to the library. It returns what to seed
+// once the torrent has been cleaned up, or nil when there is nothing to seed.
+func (tw *TorrentWorker) processJob(job redisdb.Job) *seedJob {
 	// 1. Add torrent
-	if err := tw.tor.AddMagnet(job.Id, job.Link); err != nil {
+	// A torrent this worker is already downloading keeps its first id
+	torrentId, err := tw.tor.AddMagnet(job.Id, job.Link)
+	if err != nil {
 		tw.errChan <- WorkerError{
 			JobId: job.Id,
 			Err:   err,
 			Phase: MAGNET,
 		}
-		return
+		return nil
 	}
 
 	defer func() {
-		// Cleanup torrent connection
-		if err := tw.tor.CleanupTorrent(job.Id); err != nil {
+		// Cleanup torrent connection, and its data where the engine keeps any
+		if err := tw.cleanup(torrentId); err != nil {
 			tw.errChan <- WorkerError{
 				JobId: job.Id,
 				Err:   err,
@@ -109,18 +152,18 @@ func (tw *TorrentWorker) processJob(job redisdb.Job) {
 	}()
 
 	// 4. Get file reader
-	reader := tw.tor.GetReader(job.Id)
+	reader := tw.tor.GetReader(torrentId)
 	if reader == nil {
 		tw.errChan <- WorkerError{
 			JobId: job.Id,
 			Err:   fmt.Errorf("torrent reader not found"),
 			Phase: DOWNLOAD_FAILED,
 		}
-		return
+		return nil
 	}
 	defer (*reader).Close()
 
-	metadata, err := tw.tor.GetMetadata(job.Id)
+	metadata, err := tw.tor.GetMetadata(torrentId)
 
 	if err != nil {
 		tw.errChan <- WorkerError{
@@ -128,7 +171,7 @@ func (tw *TorrentWorker) processJob(job redisdb.Job) {
 			Err:   err,
 			Phase: METADATA_FETCH_ERR,
 		}
-		return
+		return nil
 	}
 
 	// 5. Save video file to storage
@@ -139,19 +182,52 @@ func (tw *TorrentWorker) processJob(job redisdb.Job) {
 			Err:   err,
 			Phase: BUCKET_WRITE_ERR,
 		}
-		return
+		return nil
 	}
 
 	// 6. Update DB with file path
-	if err := tw.postgresdb.UpdateStatus(postgresdb.DOWNLOADED, job.Id, &filepath); err != nil {
+	if err := tw.setStatus(postgresdb.DOWNLOADED, job.Id, &filepath); err != nil {
 		tw.errChan <- WorkerError{
 			JobId: job.Id,
 			Err:   err,
 			Phase: UPDATE_FAILED,
 		}
-		return
+		return nil
+	}
+
+	// 7. Seed from the saved file once the torrent and its download are gone
+	if _, ok := tw.tor.(seeder); !ok || tw.seedPolicy.Mode == tor.SeedNever {
+		return nil
+	}
+	mi, err := tw.tor.GetMetainfo(torrentId)
+	if err != nil {
+		log.Printf("[Seed] not seeding %s: %v", job.Id, err)
+		return nil
 	}
+	return &seedJob{videoId: job.Id, mi: mi, index: metadata.Index, path: filepath}
+}
+
+// cleanup unregisters the torrent of a job, removing its data when the engine
+// supports it.
+func (tw *TorrentWorker) cleanup(torrentId string) error {
+	if remover, ok := tw.tor.(torrentRemover); ok {
+		return remover.RemoveTorrent(torrentId)
+	}
+	return tw.tor.CleanupTorrent(torrentId)
+}
 
+// setStatus records the status of a video and announces the change, so the
+// API stops serving a saved file that is being replaced or has failed.
+func (tw *TorrentWorker) setStatus(status postgresdb.STATUS, videoId string, filePath *string) error {
+	if err := tw.postgresdb.UpdateStatus(status, videoId, filePath); err != nil {
+		return err
+	}
+
+	change := redisdb.StatusChange{VideoId: videoId, Status: string(status)}
+	if err := tw.rdb.PublishStatus(tw.ctx, change); err != nil {
+		log.Printf("[Status] failed to announce %s for %s: %v", status, videoId, err)
+	}
+	return nil
 }
 
 func (tw *TorrentWorker) HandleErrors() {
@@ -159,7 +235,7 @@ func (tw *TorrentWorker) HandleErrors() {
 		log.Printf("[ERROR] JobId: %s, Phase: %s, Error: %v\n", we.JobId, we.Phase, we.Err)
 
 		// Update DB status to FAILED
-		if err := tw.postgresdb.UpdateStatus(postgresdb.FAILED, we.JobId, nil); err != nil {
+		if err := tw.setStatus(postgresdb.FAILED, we.JobId, nil); err != nil {
 			log.Printf("[ERROR] Failed to update status to FAILED for jobId %s: %v\n", we.JobId, err)
 		}
 	}
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	postgresdb "github.com/scythe504/webtorrent/internal/postgres-db"
+	redisdb "github.com/scythe504/webtorrent/internal/redis-db"
+	"github.com/scythe504/webtorrent/internal/storage"
+	"github.com/scythe504/webtorrent/internal/tor"
+	"github.com/scythe504/webtorrent/internal/tor/tortest"
+)
+
+// statusDB records the status updates of videos.
+type statusDB struct {
+	postgresdb.Service
+
+	mu     sync.Mutex
+	status map[string]postgresdb.STATUS
+	paths  map[string]string
+}
+
+func (db *statusDB) UpdateStatus(status postgresdb.STATUS, videoId string, filePath *string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.status[videoId] = status
+	if filePath != nil {
+		db.paths[videoId] = *filePath
+	}
+	return nil
+}
+
+// quietRedis drops the status changes the worker announces.
+type quietRedis struct{ redisdb.Service }
+
+func (quietRedis) PublishStatus(context.Context, redisdb.StatusChange) error { return nil }
+
+func newTestWorker(t *testing.T, engine tor.Engine) (*TorrentWorker, *statusDB) {
+	t.Helper()
+	t.Setenv("DOWNLOAD_PATH", t.TempDir())
+
+	db := &statusDB{status: make(map[string]postgresdb.STATUS), paths: make(map[string]string)}
+	return &TorrentWorker{
+		rdb:        quietRedis{},
+		postgresdb: db,
+		tor:        engine,
+		st:         storage.New(),
+		errChan:    make(chan WorkerError, 4),
+		ctx:        context.Background(),
+		seedPolicy: tor.DefaultSeedPolicy(),
+	}, db
+}
+
+func TestProcessJobSavesToLibrary(t *testing.T) {
+	engine := tortest.New()
+	video := bytes.Repeat([]byte("frame"), 50_000)
+	added := engine.Add("movie.mp4", tortest.File{Path: "movie.mp4", Data: video})
+	tw, db := newTestWorker(t, engine)
+
+	if seed := tw.processJob(redisdb.Job{Id: "job", Link: added.Magnet}); seed != nil {
+		t.Errorf("got %+v to seed from an engine that cannot seed", seed)
+	}
+
+	if db.status["job"] != postgresdb.DOWNLOADED {
+		t.Fatalf("status = %q, want DOWNLOADED", db.status["job"])
+	}
+	saved, err := os.ReadFile(db.paths["job"])
+	if err != nil || !bytes.Equal(saved, video) {
+		t.Errorf("saved file differs from the torrent: %v", err)
+	}
+	if engine.Has("job") || engine.OpenReaders() != 0 {
+		t.Error("torrent or reader left behind after the job")
+	}
+}
+
+func TestProcessJobRemovesDownload(t *testing.T) {
+	workerDir := t.TempDir()
+	engine := tor.New(tor.Config{DataDir: workerDir})
+	tw, db := newTestWorker(t, engine)
+	tw.seedPolicy.Mode = tor.SeedRatio
+
+	// A download of the worker that is complete, with its metainfo cached so
+	// the job's magnet link resolves without peers
+	video := make([]byte, 64*1024)
+	rand.Read(video)
+	if err := os.WriteFile(filepath.Join(workerDir, "movie.mp4"), video, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 16 * 1024}
+	if err := info.BuildFromFilePath(filepath.Join(workerDir, "movie.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+	if _, err := engine.AddMetainfo("earlier", mi); err != nil {
+		t.Fatal(err)
+	}
+	engine.CleanupTorrent("earlier")
+	magnet, err := mi.MagnetV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed := tw.processJob(redisdb.Job{Id: "job", Link: magnet.String()})
+	if db.status["job"] != postgresdb.DOWNLOADED {
+		t.Fatalf("status = %q, want DOWNLOADED", db.status["job"])
+	}
+	if seed == nil {
+		t.Fatal("nothing to seed after saving")
+	}
+
+	// Client state such as the piece completion database is kept
+	entries, err := os.ReadDir(workerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") {
+			t.Errorf("download %s left in the worker data dir", e.Name())
+		}
+	}
+
+	// The library file is all there is to seed from
+	s, err := engine.SeedFile(seed.mi, seed.index, seed.path)
+	if err != nil {
+		t.Fatalf("seed from the library: %v", err)
+	}
+	s.Stop()
+}
+
+func TestProcessJobReportsUnresolvedTorrent(t *testing.T) {
+	tw, _ := newTestWorker(t, tortest.New())
+
+	tw.processJob(redisdb.Job{Id: "job", Link: "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"})
+
+	select {
+	case we := <-tw.errChan:
+		if we.JobId != "job" || we.Phase != DOWNLOAD_FAILED {
+			t.Errorf("got error %+v", we)
+		}
+	default:
+		t.Fatal("no error reported for a torrent that never resolved")
+	}
+}